@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"github.com/nabeeladzan/peridot/internal"
+	"github.com/nabeeladzan/peridot/internal/codec"
+)
+
+// isBlob reports whether a node payload refers to a blob
+func isBlob(value [64]byte) bool {
+	return value[0] == blobTag
+}
+
+// blobSize returns the length of the blob a node payload refers to
+func blobSize(value [64]byte) int64 {
+	return int64(codec.Order.Uint32(value[9:]))
+}
+
+// blobPayload returns how many bytes storing data as a blob adds to the
+// overflow store, where every blob goes
+func blobPayload(data []byte) int64 {
+	return 4 + int64(len(data))
+}
+
+// insertBlob stores data as the value of a new node, without any JSON
+// encoding, and returns the node ID
+func insertBlob(store *Store, data []byte) (uint32, error) {
+	f, err := overflowFile(store)
+	if err != nil {
+		return 0, err
+	}
+	ref, err := appendOverflow(f, data)
+	if err != nil {
+		return 0, err
+	}
+	ref[0] = blobTag
+	return writeNode(store.nodestore, store.freestore, ref)
+}
+
+// blobReader returns a reader over the blob of a node, so large blobs can
+// be copied out without loading them whole
+func blobReader(store *Store, node internal.Node) (io.Reader, error) {
+	if !isBlob(node.Value) {
+		return nil, fmt.Errorf("node %d does not hold a blob", node.ID)
+	}
+	f, err := overflowFile(store)
+	if err != nil {
+		return nil, err
+	}
+	// skip the length prefix
+	offset := int64(codec.Order.Uint64(node.Value[1:])) + 4
+	return io.NewSectionReader(f, offset, blobSize(node.Value)), nil
+}
+
+// decodeBlob decodes a blob typed at the prompt as base64
+func decodeBlob(s string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("blob is not valid base64: %v", err)
+	}
+	return data, nil
+}
+
+// showValue returns the value of a node as it is printed. Blobs are only
+// summarised; use getblob to read them.
+func showValue(store *Store, node internal.Node) (string, error) {
+	if isBlob(node.Value) {
+		return fmt.Sprintf("<blob, %d bytes>", blobSize(node.Value)), nil
+	}
+	value, err := nodeValue(store, node)
+	return string(value), err
+}
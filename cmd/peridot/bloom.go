@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strings"
+
+	"github.com/nabeeladzan/peridot/internal/codec"
+)
+
+// The bloom filter remembers every key that has been indexed in a store:
+// node UUIDs and the keys of the property indexes. A key it has never seen
+// is absent for sure, so such lookups are answered without loading an
+// index or reading the nodestore. Deleted keys stay in the filter; they
+// only cost a lookup that finds nothing.
+//
+// <name>_bloom.db holds a header followed by the bit array:
+//
+//	0  4 bytes  number of bits
+//	4  4 bytes  number of hash functions
+//	8  4 bytes  number of keys added
+//	12 8 bytes  signature of the index definitions the filter covers
+const (
+	bloomHeaderSize = 20
+	bloomMinBits    = 1 << 13
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+)
+
+type bloomFilter struct {
+	f         *os.File
+	bits      []uint64
+	hashes    uint32
+	count     uint32
+	signature uint64
+}
+
+// bloomSignature identifies what a store's filter has to cover, so a
+// filter written before an index was created or dropped gets rebuilt
+func bloomSignature(store *Store) uint64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "uuids=%t", store.meta.UUIDs)
+	for _, def := range store.meta.Indexes {
+		fmt.Fprintf(h, ";%s", strings.Join(def.Properties, ","))
+	}
+	return h.Sum64()
+}
+
+// uuidKey and indexedKey are the filter keys of a UUID and of an index key
+func uuidKey(u UUID) []byte {
+	return append([]byte("u:"), u[:]...)
+}
+
+func indexedKey(props []string, key []any) []byte {
+	data, _ := json.Marshal(key)
+	return []byte("i:" + strings.Join(props, ",") + ":" + string(data))
+}
+
+// positions returns the bit positions of key, by double hashing
+func (b *bloomFilter) positions(key []byte) []uint64 {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	m := uint64(len(b.bits)) * 64
+	pos := make([]uint64, b.hashes)
+	for i := range pos {
+		pos[i] = (h1 + uint64(i)*h2) % m
+	}
+	return pos
+}
+
+// mayContain reports whether key may have been added. False is certain.
+func (b *bloomFilter) mayContain(key []byte) bool {
+	for _, p := range b.positions(key) {
+		if b.bits[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// add sets the bits of key and writes the words that changed
+func (b *bloomFilter) add(key []byte) error {
+	buf := make([]byte, 8)
+	for _, p := range b.positions(key) {
+		word := p / 64
+		if b.bits[word]&(1<<(p%64)) != 0 {
+			continue
+		}
+		b.bits[word] |= 1 << (p % 64)
+		codec.Order.PutUint64(buf, b.bits[word])
+		if _, err := b.f.WriteAt(buf, bloomHeaderSize+int64(word)*8); err != nil {
+			return err
+		}
+	}
+	b.count++
+	codec.Order.PutUint32(buf, b.count)
+	_, err := b.f.WriteAt(buf[:4], 8)
+	return err
+}
+
+// full reports whether the filter holds more keys than it was sized for
+func (b *bloomFilter) full() bool {
+	return uint64(b.count)*bloomBitsPerKey > uint64(len(b.bits))*64
+}
+
+// readBloom reads a filter file, returning nil if it is missing or does
+// not parse
+func readBloom(name string) *bloomFilter {
+	data, err := os.ReadFile(name + "_bloom.db")
+	if err != nil || len(data) < bloomHeaderSize {
+		return nil
+	}
+	m := codec.Order.Uint32(data[0:])
+	if m == 0 || m%64 != 0 || len(data) != bloomHeaderSize+int(m/8) {
+		return nil
+	}
+	b := &bloomFilter{
+		bits:      make([]uint64, m/64),
+		hashes:    codec.Order.Uint32(data[4:]),
+		count:     codec.Order.Uint32(data[8:]),
+		signature: codec.Order.Uint64(data[12:]),
+	}
+	for i := range b.bits {
+		b.bits[i] = codec.Order.Uint64(data[bloomHeaderSize+i*8:])
+	}
+	return b
+}
+
+// buildBloom writes a new filter for every key currently indexed in the
+// store, sized for the key count
+func buildBloom(store *Store) (*bloomFilter, error) {
+	var keys [][]byte
+	if store.meta.UUIDs {
+		idx, err := loadUUIDIndex(store)
+		if err != nil {
+			return nil, err
+		}
+		for u := range idx.ids {
+			keys = append(keys, uuidKey(u))
+		}
+	}
+	if len(store.meta.Indexes) > 0 {
+		// scan the nodestore rather than the indexes, which may not be built
+		nodes, err := readStore(store.nodestore)
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range nodes {
+			if node.InUse != 1 || isBlob(node.Value) {
+				continue
+			}
+			value, err := nodeValue(store, node)
+			if err != nil {
+				return nil, err
+			}
+			for _, def := range store.meta.Indexes {
+				if key, ok := indexKey(def.Properties, value); ok {
+					keys = append(keys, indexedKey(def.Properties, key))
+				}
+			}
+		}
+	}
+
+	m := uint64(bloomMinBits)
+	for m < uint64(len(keys))*bloomBitsPerKey*2 {
+		m *= 2
+	}
+	b := &bloomFilter{bits: make([]uint64, m/64), hashes: bloomHashes, signature: bloomSignature(store)}
+	for _, key := range keys {
+		for _, p := range b.positions(key) {
+			b.bits[p/64] |= 1 << (p % 64)
+		}
+	}
+	b.count = uint32(len(keys))
+
+	data := make([]byte, bloomHeaderSize+len(b.bits)*8)
+	codec.Order.PutUint32(data[0:], uint32(m))
+	codec.Order.PutUint32(data[4:], b.hashes)
+	codec.Order.PutUint32(data[8:], b.count)
+	codec.Order.PutUint64(data[12:], b.signature)
+	for i, word := range b.bits {
+		codec.Order.PutUint64(data[bloomHeaderSize+i*8:], word)
+	}
+	tmp := store.name + "_bloom.db.tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return nil, err
+	}
+	if err := replaceFile(tmp, store.name+"_bloom.db"); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// loadBloom returns the bloom filter of the store, reading it on first use
+// and rebuilding it when it is missing, covers other indexes or is full.
+// There is no filter while an index is being built: it is rebuilt once
+// the build is done.
+func loadBloom(store *Store) (*bloomFilter, error) {
+	if store.bloom != nil && store.bloom.signature == bloomSignature(store) {
+		return store.bloom, nil
+	}
+	if store.bloom != nil {
+		store.bloom.f.Close()
+		store.bloom = nil
+	}
+	if building(store) {
+		return nil, nil
+	}
+	b := readBloom(store.name)
+	if b == nil || b.signature != bloomSignature(store) || b.full() {
+		var err error
+		if b, err = buildBloom(store); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(store.name+"_bloom.db", os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s_bloom", store.name)
+	}
+	b.f = f
+	store.bloom = b
+	return b, nil
+}
+
+// bloomAdd records newly indexed keys in the filter of the store
+func bloomAdd(store *Store, keys ...[]byte) error {
+	b, err := loadBloom(store)
+	if err != nil || b == nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := b.add(key); err != nil {
+			return err
+		}
+	}
+	if b.full() {
+		// grow it on next use
+		b.f.Close()
+		store.bloom = nil
+		return os.Remove(store.name + "_bloom.db")
+	}
+	return nil
+}
+
+// bloomMayContain reports whether key may be indexed in the store
+func bloomMayContain(store *Store, key []byte) (bool, error) {
+	b, err := loadBloom(store)
+	if err != nil || b == nil {
+		return true, err
+	}
+	return b.mayContain(key), nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import "sort"
+
+// btreeDegree is the minimum degree of index B-trees: nodes other than the
+// root hold between btreeDegree-1 and 2*btreeDegree-1 entries
+const btreeDegree = 16
+
+// btree is an in-memory B-tree of index entries, ordered by indexEntry.less
+type btree struct {
+	root *btreeNode
+	len  int
+}
+
+type btreeNode struct {
+	items    []indexEntry
+	children []*btreeNode // empty for leaves
+}
+
+func (n *btreeNode) leaf() bool {
+	return len(n.children) == 0
+}
+
+// find returns the position of the first item not less than e, and
+// whether that item is e
+func (n *btreeNode) find(e indexEntry) (int, bool) {
+	i := sort.Search(len(n.items), func(i int) bool { return !n.items[i].less(e) })
+	return i, i < len(n.items) && !e.less(n.items[i])
+}
+
+// insert adds e to the tree
+func (t *btree) insert(e indexEntry) {
+	if t.root == nil {
+		t.root = &btreeNode{}
+	}
+	if len(t.root.items) == 2*btreeDegree-1 {
+		root := &btreeNode{children: []*btreeNode{t.root}}
+		root.split(0)
+		t.root = root
+	}
+	t.root.insert(e)
+	t.len++
+}
+
+// split moves the upper half of the full child i into a new sibling and
+// its middle item up into n
+func (n *btreeNode) split(i int) {
+	child := n.children[i]
+	mid := child.items[btreeDegree-1]
+	right := &btreeNode{items: append([]indexEntry{}, child.items[btreeDegree:]...)}
+	if !child.leaf() {
+		right.children = append([]*btreeNode{}, child.children[btreeDegree:]...)
+		child.children = child.children[:btreeDegree]
+	}
+	child.items = child.items[:btreeDegree-1]
+
+	n.items = append(n.items, indexEntry{})
+	copy(n.items[i+1:], n.items[i:])
+	n.items[i] = mid
+	n.children = append(n.children, nil)
+	copy(n.children[i+2:], n.children[i+1:])
+	n.children[i+1] = right
+}
+
+// insert adds e below n, which is not full
+func (n *btreeNode) insert(e indexEntry) {
+	i := sort.Search(len(n.items), func(i int) bool { return e.less(n.items[i]) })
+	if n.leaf() {
+		n.items = append(n.items, indexEntry{})
+		copy(n.items[i+1:], n.items[i:])
+		n.items[i] = e
+		return
+	}
+	if len(n.children[i].items) == 2*btreeDegree-1 {
+		n.split(i)
+		if n.items[i].less(e) {
+			i++
+		}
+	}
+	n.children[i].insert(e)
+}
+
+// delete removes e from the tree and reports whether it was there
+func (t *btree) delete(e indexEntry) bool {
+	if t.root == nil || !t.root.remove(e) {
+		return false
+	}
+	if len(t.root.items) == 0 && !t.root.leaf() {
+		t.root = t.root.children[0]
+	}
+	t.len--
+	return true
+}
+
+// remove deletes e below n. Every child it descends into is first given
+// at least btreeDegree items, so removing one never leaves it underfull.
+func (n *btreeNode) remove(e indexEntry) bool {
+	i, found := n.find(e)
+	if n.leaf() {
+		if !found {
+			return false
+		}
+		n.items = append(n.items[:i], n.items[i+1:]...)
+		return true
+	}
+
+	if found {
+		switch {
+		case len(n.children[i].items) >= btreeDegree:
+			prev := n.children[i].max()
+			n.items[i] = prev
+			return n.children[i].remove(prev)
+		case len(n.children[i+1].items) >= btreeDegree:
+			next := n.children[i+1].min()
+			n.items[i] = next
+			return n.children[i+1].remove(next)
+		}
+		n.merge(i)
+		return n.children[i].remove(e)
+	}
+
+	if len(n.children[i].items) < btreeDegree {
+		switch {
+		case i > 0 && len(n.children[i-1].items) >= btreeDegree:
+			n.rotateRight(i)
+		case i < len(n.children)-1 && len(n.children[i+1].items) >= btreeDegree:
+			n.rotateLeft(i)
+		default:
+			if i == len(n.children)-1 {
+				i--
+			}
+			n.merge(i)
+		}
+	}
+	return n.children[i].remove(e)
+}
+
+func (n *btreeNode) min() indexEntry {
+	for !n.leaf() {
+		n = n.children[0]
+	}
+	return n.items[0]
+}
+
+func (n *btreeNode) max() indexEntry {
+	for !n.leaf() {
+		n = n.children[len(n.children)-1]
+	}
+	return n.items[len(n.items)-1]
+}
+
+// merge joins child i, item i and child i+1 into child i
+func (n *btreeNode) merge(i int) {
+	left, right := n.children[i], n.children[i+1]
+	left.items = append(append(left.items, n.items[i]), right.items...)
+	left.children = append(left.children, right.children...)
+	n.items = append(n.items[:i], n.items[i+1:]...)
+	n.children = append(n.children[:i+1], n.children[i+2:]...)
+}
+
+// rotateRight moves an item from child i-1 through n into child i
+func (n *btreeNode) rotateRight(i int) {
+	left, child := n.children[i-1], n.children[i]
+	child.items = append([]indexEntry{n.items[i-1]}, child.items...)
+	n.items[i-1] = left.items[len(left.items)-1]
+	left.items = left.items[:len(left.items)-1]
+	if !left.leaf() {
+		child.children = append([]*btreeNode{left.children[len(left.children)-1]}, child.children...)
+		left.children = left.children[:len(left.children)-1]
+	}
+}
+
+// rotateLeft moves an item from child i+1 through n into child i
+func (n *btreeNode) rotateLeft(i int) {
+	child, right := n.children[i], n.children[i+1]
+	child.items = append(child.items, n.items[i])
+	n.items[i] = right.items[0]
+	right.items = append([]indexEntry{}, right.items[1:]...)
+	if !right.leaf() {
+		child.children = append(child.children, right.children[0])
+		right.children = append([]*btreeNode{}, right.children[1:]...)
+	}
+}
+
+// ascend calls fn on the entries for which from holds, in order, until fn
+// returns false. from must hold for every entry after the first it holds
+// for.
+func (t *btree) ascend(from func(indexEntry) bool, fn func(indexEntry) bool) {
+	if t.root != nil {
+		t.root.ascend(from, fn)
+	}
+}
+
+func (n *btreeNode) ascend(from func(indexEntry) bool, fn func(indexEntry) bool) bool {
+	i := sort.Search(len(n.items), func(i int) bool { return from(n.items[i]) })
+	for ; i <= len(n.items); i++ {
+		if !n.leaf() && !n.children[i].ascend(from, fn) {
+			return false
+		}
+		if i == len(n.items) {
+			break
+		}
+		if !fn(n.items[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// queryCacheSize is the number of find results kept in memory, 0 to turn
+// the cache off. It is set by the -query-cache flag.
+var queryCacheSize int
+
+// queryCache keeps the results of recent finds, least recently used
+// first out. Entries are keyed by the store, the write epoch of its
+// nodestore and the normalized filter, so a write to a store makes its
+// cached results unreachable and they age out. The epoch starts again
+// when the nodestore is opened anew, and its files may have changed on
+// disk meanwhile, so the results of a store are forgotten when it is
+// closed or attached.
+type queryCache struct {
+	entries map[string]*list.Element
+	order   *list.List // front is most recently used
+}
+
+type cachedQuery struct {
+	key   string
+	nodes []internal.Node
+	plan  string
+}
+
+var findCache = &queryCache{entries: make(map[string]*list.Element), order: list.New()}
+
+// String writes a predicate in a canonical form
+func (p predicate) String() string {
+	var b strings.Builder
+	b.WriteByte('$')
+	for _, step := range p.path {
+		if step.key != "" {
+			fmt.Fprintf(&b, ".%s", step.key)
+		} else {
+			fmt.Fprintf(&b, "[%d]", step.index)
+		}
+	}
+	operand, _ := json.Marshal(p.operand)
+	fmt.Fprintf(&b, " %s %s", p.op, operand)
+	return b.String()
+}
+
+// cacheKey identifies a find on the store as it is now. Filters that
+// differ only in spacing, path spelling or predicate order share a key.
+func cacheKey(store *Store, preds []predicate) string {
+	parts := make([]string, len(preds))
+	for i, p := range preds {
+		parts[i] = p.String()
+	}
+	sort.Strings(parts)
+	return fmt.Sprintf("%s@%d:%s", store.name, store.nodestore.epoch, strings.Join(parts, " AND "))
+}
+
+// forget drops every cached result of the named store
+func (c *queryCache) forget(name string) {
+	prefix := name + "@"
+	for key, e := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.order.Remove(e)
+			delete(c.entries, key)
+		}
+	}
+}
+
+func (c *queryCache) get(key string) (*cachedQuery, bool) {
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedQuery), true
+}
+
+func (c *queryCache) put(q *cachedQuery) {
+	if queryCacheSize <= 0 {
+		return
+	}
+	if e, ok := c.entries[q.key]; ok {
+		e.Value = q
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[q.key] = c.order.PushFront(q)
+	for c.order.Len() > queryCacheSize {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(*cachedQuery).key)
+	}
+}
+
+// trim evicts results beyond queryCacheSize, after it was lowered
+func (c *queryCache) trim() {
+	for c.order.Len() > max(queryCacheSize, 0) {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(*cachedQuery).key)
+	}
+}
+
+// cachedFind is findNodes through the query cache
+func cachedFind(store *Store, preds []predicate) ([]internal.Node, string, error) {
+	if queryCacheSize <= 0 {
+		return findNodes(store, preds)
+	}
+	key := cacheKey(store, preds)
+	q, ok := findCache.get(key)
+	store.nodestore.io.cached(ok, true)
+	if ok {
+		return q.nodes, q.plan + ", cached", nil
+	}
+	nodes, plan, err := findNodes(store, preds)
+	if err != nil {
+		return nil, "", err
+	}
+	findCache.put(&cachedQuery{key, nodes, plan})
+	return nodes, plan, nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/nabeeladzan/peridot/internal"
+	"github.com/nabeeladzan/peridot/internal/codec"
+)
+
+// Problem is one inconsistency found by checkStore. Problems that can be
+// fixed from the nodestore carry a repair function.
+type Problem struct {
+	What   string
+	repair func() error
+}
+
+// checkStore cross-checks a store: the free list against the nodestore,
+// and every index against the nodes it covers. The nodestore is taken as
+// the truth.
+func checkStore(store *Store) ([]Problem, error) {
+	if building(store) {
+		return nil, fmt.Errorf("store %s is building an index, check it when the build is done", store.name)
+	}
+	nodes, err := readStore(store.nodestore)
+	if err != nil {
+		return nil, err
+	}
+	var problems []Problem
+	add := func(repair func() error, format string, args ...any) {
+		problems = append(problems, Problem{fmt.Sprintf(format, args...), repair})
+	}
+
+	// every free slot is on the free list exactly once, and nothing else is
+	onList := make(map[uint32]bool)
+	id, err := getFree(store.freestore)
+	if err != nil {
+		return nil, err
+	}
+	for id != ^uint32(0) {
+		if int(id) >= len(nodes) {
+			add(nil, "free list points past the end of the nodestore at slot %d", id)
+			break
+		}
+		if onList[id] {
+			add(nil, "free list loops back to slot %d", id)
+			break
+		}
+		if nodes[id].InUse == 1 {
+			add(nil, "free list holds slot %d, which is in use", id)
+		}
+		onList[id] = true
+		id = codec.Order.Uint32(nodes[id].Value[0:4])
+	}
+	// reserved slots are kept off the list until they are released
+	for _, node := range nodes {
+		if node.InUse != 1 && !onList[node.ID] && !reserved(store.meta, node.ID) {
+			id := node.ID
+			add(func() error { return relinkFree(store, id) }, "slot %d is free but not on the free list", id)
+		}
+	}
+
+	// the values of the in-use nodes, to check the indexes against
+	values := make(map[uint32][]byte)
+	for _, node := range nodes {
+		if node.InUse != 1 || isBlob(node.Value) {
+			continue
+		}
+		value, err := nodeValue(store, node)
+		if err != nil {
+			add(nil, "node %d: %v", node.ID, err)
+			continue
+		}
+		values[node.ID] = value
+	}
+	inUse := func(id uint32) bool {
+		return int(id) < len(nodes) && nodes[id].InUse == 1
+	}
+
+	for _, idx := range store.indexes {
+		checkPropIndex(store, idx, nodes, values, add)
+	}
+	if err := checkUUIDs(store, nodes, inUse, add); err != nil {
+		return nil, err
+	}
+	if err := checkPoints(store, inUse, add); err != nil {
+		return nil, err
+	}
+	if store.textindex != nil {
+		want := &textIndex{postings: make(map[string]map[uint32]int), words: make(map[uint32][]string)}
+		for id, value := range values {
+			want.add(id, valueWords(value))
+		}
+		if !reflect.DeepEqual(want.postings, store.textindex.postings) {
+			add(func() error {
+				store.textindex = want
+				return nil
+			}, "full-text index does not match the node values")
+		}
+	}
+	if store.typeindex != nil {
+		want := newTypeIndex()
+		for _, node := range nodes {
+			if node.InUse == 1 {
+				want.add(node.ID, node.Type)
+			}
+		}
+		if !reflect.DeepEqual(want.postings, store.typeindex.postings) {
+			add(func() error {
+				store.typeindex = want
+				return nil
+			}, "type index does not match the node types")
+		}
+	}
+	checkBloom(store, values, add)
+	return problems, nil
+}
+
+// recoverStore checks a store that was not closed cleanly and repairs what
+// it can, as check with repair would. In a dry run it only reports.
+func recoverStore(store *Store) error {
+	problems, err := checkStore(store)
+	if err != nil {
+		return fmt.Errorf("recovering store %s: %v", store.name, err)
+	}
+	repaired := 0
+	for _, p := range problems {
+		if dryRun || p.repair == nil {
+			continue
+		}
+		if err := p.repair(); err != nil {
+			return fmt.Errorf("recovering store %s: %v", store.name, err)
+		}
+		repaired++
+	}
+	fmt.Printf("Store %s was not closed cleanly: %d problems found, %d repaired\n", store.name, len(problems), repaired)
+	return nil
+}
+
+// relinkFree puts a free slot that was lost from the free list back at its
+// head
+func relinkFree(store *Store, id uint32) error {
+	node, err := readNode(store.nodestore, id)
+	if errors.Is(err, codec.ErrCorrupt) {
+		// a quarantined record; its generation is lost with it
+		node, err = internal.Node{ID: id}, nil
+	}
+	if err != nil {
+		return err
+	}
+	head, err := getFree(store.freestore)
+	if err != nil {
+		return err
+	}
+	codec.Order.PutUint32(node.Value[0:], head)
+	f := store.nodestore
+	buf := make([]byte, f.codec.RecordSize())
+	if err := f.codec.EncodeNode(buf, node); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(buf, f.offset(id)); err != nil {
+		return err
+	}
+	return setFree(store.freestore, id)
+}
+
+// checkPropIndex compares a property index with the keys the node values
+// give
+func checkPropIndex(store *Store, idx *propIndex, nodes []internal.Node, values map[uint32][]byte, add func(func() error, string, ...any)) {
+	reindex := func(id uint32) func() error {
+		return func() error {
+			idx.mu.Lock()
+			defer idx.mu.Unlock()
+			return idx.update(store, nodes[id])
+		}
+	}
+	ids := make([]uint32, 0, len(idx.keys))
+	for id := range idx.keys {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		key := idx.keys[id]
+		value, ok := values[id]
+		if !ok {
+			add(reindex(id), "index %s holds node %d, which is not an in-use document", idx.name(), id)
+			continue
+		}
+		if want, _ := indexKey(idx.props, value); !reflect.DeepEqual(want, key) {
+			add(reindex(id), "index %s holds node %d under %v, its value gives %v", idx.name(), id, key, want)
+		}
+	}
+	for id, value := range values {
+		if _, ok := idx.keys[id]; ok {
+			continue
+		}
+		if _, ok := indexKey(idx.props, value); ok {
+			add(reindex(id), "index %s is missing node %d", idx.name(), id)
+		}
+	}
+}
+
+// checkUUIDs checks that the UUID index matches the UUID store, that only
+// in-use nodes have UUIDs, that no two nodes share one and, if the store
+// assigns UUIDs, that every node has one
+func checkUUIDs(store *Store, nodes []internal.Node, inUse func(uint32) bool, add func(func() error, string, ...any)) error {
+	if !store.meta.UUIDs {
+		if _, err := os.Stat(store.name + "_uuid.db"); errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+	}
+	// read the UUID store afresh, keeping the index in use until a repair
+	loaded := store.uuidindex
+	store.uuidindex = nil
+	disk, err := loadUUIDIndex(store)
+	if err != nil {
+		return err
+	}
+	if loaded != nil {
+		store.uuidindex = loaded
+		if !reflect.DeepEqual(loaded.uuids, disk.uuids) {
+			add(func() error {
+				store.uuidindex = disk
+				return nil
+			}, "UUID index does not match the UUID store")
+		}
+	}
+
+	ids := make([]uint32, 0, len(disk.uuids))
+	for id := range disk.uuids {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		u := disk.uuids[id]
+		switch {
+		case !inUse(id):
+			add(func() error { return removeUUID(store, id) }, "slot %d is free but has UUID %s", id, u)
+		case disk.ids[u] != id:
+			other := disk.ids[u]
+			add(func() error {
+				if _, err := assignUUID(store, id); err != nil {
+					return err
+				}
+				// assignUUID unmapped the shared UUID, it stays with other
+				store.uuidindex.ids[u] = other
+				return nil
+			}, "node %d shares UUID %s with node %d", id, u, other)
+		}
+	}
+	if store.meta.UUIDs {
+		for _, node := range nodes {
+			if _, ok := disk.uuids[node.ID]; node.InUse == 1 && !ok {
+				id := node.ID
+				add(func() error {
+					_, err := assignUUID(store, id)
+					return err
+				}, "node %d has no UUID", id)
+			}
+		}
+	}
+	return nil
+}
+
+// checkPoints checks that only in-use nodes have positions
+func checkPoints(store *Store, inUse func(uint32) bool, add func(func() error, string, ...any)) error {
+	if !hasPoints(store) {
+		return nil
+	}
+	idx, err := loadGeoIndex(store)
+	if err != nil {
+		return err
+	}
+	var ids []uint32
+	for id := range idx.points {
+		if !inUse(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		add(func() error { return removePoint(store, id) }, "slot %d is free but has a position", id)
+	}
+	return nil
+}
+
+// checkBloom checks that the bloom filter holds every indexed key, since a
+// missing key would hide a node from lookups
+func checkBloom(store *Store, values map[uint32][]byte, add func(func() error, string, ...any)) {
+	b := readBloom(store.name)
+	if b == nil || b.signature != bloomSignature(store) {
+		// rebuilt from the data on next use
+		return
+	}
+	rebuild := func() error {
+		if store.bloom != nil {
+			store.bloom.f.Close()
+			store.bloom = nil
+		}
+		return os.Remove(store.name + "_bloom.db")
+	}
+	if store.uuidindex != nil {
+		for u := range store.uuidindex.ids {
+			if !b.mayContain(uuidKey(u)) {
+				add(rebuild, "bloom filter is missing UUID %s", u)
+				return
+			}
+		}
+	}
+	for id, value := range values {
+		for _, def := range store.meta.Indexes {
+			key, ok := indexKey(def.Properties, value)
+			if ok && !b.mayContain(indexedKey(def.Properties, key)) {
+				add(rebuild, "bloom filter is missing the %s key of node %d", strings.Join(def.Properties, ","), id)
+				return
+			}
+		}
+	}
+}
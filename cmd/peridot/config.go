@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// defaultConfig is the config file read at startup unless -config names
+// another. It is fine for it not to exist.
+const defaultConfig = "peridot.toml"
+
+// configPath is the config file to read, set by the -config flag
+var configPath = defaultConfig
+
+// Config is what peridot.toml sets besides the settings below: aliases
+// for REPL commands.
+//
+//	[aliases]
+//	i = "insert"
+//	r = "read"
+type Config struct {
+	// Prompt is the REPL prompt. {store} is replaced by the store used
+	// last, {pending} by the number of writes in the open transaction and
+	// {tx} by " [N pending]" while one is open.
+	Prompt  string
+	Aliases map[string]string
+}
+
+// config is the configuration in effect
+var config = Config{Prompt: "Peridot{tx}> "}
+
+// currentStore is the store the last command used, for the prompt
+var currentStore string
+
+// dataDir is the directory the stores are in, set by -data-dir
+var dataDir = "."
+
+// setting is a value that can be set in the config file, by an
+// environment variable or by a flag. A flag wins over the environment,
+// and the environment over the file; the file is read first, so -config
+// is only a flag.
+type setting struct {
+	key    string // table.key in the config file
+	env    string
+	flag   string
+	reload bool // can change while stores are open
+}
+
+var settings = []setting{
+	{"storage.data_dir", "PERIDOT_DATA_DIR", "data-dir", false},
+	{"storage.cold_dir", "PERIDOT_COLD_DIR", "cold", false},
+	{"storage.max_open_stores", "PERIDOT_MAX_OPEN_STORES", "max-open-stores", true},
+	{"storage.quarantine", "PERIDOT_QUARANTINE", "quarantine", true},
+	{"cache.nodes", "PERIDOT_NODE_CACHE", "node-cache", true},
+	{"cache.queries", "PERIDOT_QUERY_CACHE", "query-cache", true},
+	{"repl.prompt", "PERIDOT_PROMPT", "prompt", true},
+	{"repl.format", "PERIDOT_FORMAT", "format", true},
+	{"server.protect", "PERIDOT_PROTECT", "protect", true},
+	{"limits.scan_memory", "PERIDOT_SCAN_MEMORY", "scan-memory", true},
+	{"limits.max_rows", "PERIDOT_MAX_ROWS", "max-rows", true},
+	{"limits.max_scan_time", "PERIDOT_MAX_SCAN_TIME", "max-scan-time", true},
+	{"maintenance.interval", "PERIDOT_MAINTENANCE", "maintenance", true},
+}
+
+// reloadRequested is set on SIGHUP. The REPL reloads the config before
+// the next command it runs, so a reload never lands in the middle of one.
+var reloadRequested atomic.Bool
+
+// reloadConfig reads the config file and environment again and applies
+// the settings that can change while stores are open: caches, prompt,
+// output format, protect and aliases. Settings set by flags keep their
+// flag values. The data and cold directories only change on restart; the
+// names of any that were edited are returned. On error nothing changes.
+func reloadConfig(stores *registry) ([]string, error) {
+	saved := make(map[string]string)
+	for _, s := range settings {
+		saved[s.flag] = flag.Lookup(s.flag).Value.String()
+	}
+	savedSources := maps.Clone(settingSources)
+	savedAliases := config.Aliases
+	restore := func() {
+		for _, s := range settings {
+			setFlag(s.flag, saved[s.flag])
+		}
+		settingSources, config.Aliases = savedSources, savedAliases
+	}
+
+	// start from the defaults, so settings removed from the file go back
+	for _, s := range settings {
+		if s.reload {
+			setFlag(s.flag, flag.Lookup(s.flag).DefValue)
+		}
+	}
+	config.Aliases = nil
+	if err := loadConfig(); err != nil {
+		restore()
+		return nil, err
+	}
+
+	var pending []string
+	for _, s := range settings {
+		if s.reload {
+			continue
+		}
+		if flag.Lookup(s.flag).Value.String() != saved[s.flag] {
+			pending = append(pending, s.key)
+		}
+		setFlag(s.flag, saved[s.flag])
+		settingSources[s.key] = savedSources[s.key]
+	}
+
+	findCache.trim()
+	for _, store := range stores.List() {
+		if store.cold || store.closed {
+			continue
+		}
+		if store.nodestore.cache != nil {
+			store.nodestore.cache.resize(nodeCacheSize)
+		} else if !building(store) {
+			// index builds read through the cache, so it is only
+			// added to a store that is not building one
+			store.nodestore.cache = newNodeCache(nodeCacheSize)
+		}
+	}
+	return pending, nil
+}
+
+// watchReload asks for a reload on every SIGHUP
+func watchReload() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadRequested.Store(true)
+		}
+	}()
+}
+
+// settingSources says where each setting got its value from
+var settingSources = make(map[string]string)
+
+// cmdlineFlags are the flags given on the command line
+var cmdlineFlags map[string]bool
+
+// setFlag changes the value of a flag without counting it as given on the
+// command line
+func setFlag(name, value string) error {
+	return flag.Lookup(name).Value.Set(value)
+}
+
+// loadConfig applies configPath and the environment to the settings that
+// were not given as flags. It is called after the flags are parsed.
+func loadConfig() error {
+	if cmdlineFlags == nil {
+		cmdlineFlags = make(map[string]bool)
+		flag.Visit(func(f *flag.Flag) { cmdlineFlags[f.Name] = true })
+	}
+	flagged := cmdlineFlags
+	for _, s := range settings {
+		settingSources[s.key] = "default"
+		if flagged[s.flag] {
+			settingSources[s.key] = "flag -" + s.flag
+		}
+	}
+
+	f, err := os.Open(configPath)
+	if errors.Is(err, os.ErrNotExist) && configPath == defaultConfig {
+		f = nil
+	} else if err != nil {
+		return err
+	}
+	if f != nil {
+		defer f.Close()
+		tables, err := parseTOML(f)
+		if err != nil {
+			return fmt.Errorf("%s: %v", configPath, err)
+		}
+		for table, values := range tables {
+			for key, v := range values {
+				if err := config.set(table, key, v, flagged); err != nil {
+					return fmt.Errorf("%s: %v", configPath, err)
+				}
+			}
+		}
+	}
+
+	for _, s := range settings {
+		v, ok := os.LookupEnv(s.env)
+		if !ok || flagged[s.flag] {
+			continue
+		}
+		if err := setFlag(s.flag, v); err != nil {
+			return fmt.Errorf("%s: invalid value %q: %v", s.env, v, err)
+		}
+		settingSources[s.key] = "env " + s.env
+	}
+	return nil
+}
+
+// set applies one key of table from the config file
+func (c *Config) set(table, key string, v any, flagged map[string]bool) error {
+	name := key
+	if table != "" {
+		name = table + "." + key
+	}
+	if table == "aliases" {
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s must be a string", name)
+		}
+		if !slices.Contains(replCommands, s) {
+			return fmt.Errorf("alias %s is for unknown command %q", key, s)
+		}
+		if slices.Contains(replCommands, key) {
+			return fmt.Errorf("alias %s would hide the command of that name", key)
+		}
+		if c.Aliases == nil {
+			c.Aliases = make(map[string]string)
+		}
+		c.Aliases[key] = s
+		return nil
+	}
+	for _, s := range settings {
+		if s.key != name {
+			continue
+		}
+		if flagged[s.flag] {
+			return nil
+		}
+		if err := setFlag(s.flag, fmt.Sprint(v)); err != nil {
+			return fmt.Errorf("%s: invalid value %v: %v", name, v, err)
+		}
+		settingSources[s.key] = "file " + configPath
+		return nil
+	}
+	return fmt.Errorf("unknown setting %s", name)
+}
+
+// showConfig prints the settings in effect as a config file, noting where
+// each value came from
+func showConfig() {
+	table := ""
+	for _, s := range settings {
+		t, key, _ := strings.Cut(s.key, ".")
+		if t != table {
+			if table != "" {
+				fmt.Println()
+			}
+			fmt.Printf("[%s]\n", t)
+			table = t
+		}
+		f := flag.Lookup(s.flag)
+		value := strconv.Quote(f.Value.String())
+		if g, ok := f.Value.(flag.Getter); ok {
+			switch v := g.Get().(type) {
+			case int, int64, bool:
+				value = fmt.Sprint(v)
+			}
+		}
+		fmt.Printf("%s = %s # %s\n", key, value, settingSources[s.key])
+	}
+	if len(config.Aliases) > 0 {
+		fmt.Println()
+		fmt.Println("[aliases]")
+		names := slices.Sorted(maps.Keys(config.Aliases))
+		for _, name := range names {
+			fmt.Printf("%s = %q\n", name, config.Aliases[name])
+		}
+	}
+}
+
+// resolveAlias returns the command an alias stands for, or command itself
+func resolveAlias(command string) string {
+	if target, ok := config.Aliases[command]; ok {
+		return target
+	}
+	return command
+}
+
+// prompt is the REPL prompt, from the configured template
+func prompt() string {
+	pending, tag := "0", ""
+	if tx != nil {
+		pending = strconv.Itoa(len(tx.ops))
+		tag = " [" + pending + " pending]"
+	}
+	return strings.NewReplacer("{store}", currentStore, "{pending}", pending, "{tx}", tag).Replace(config.Prompt)
+}
+
+// parseTOML reads the part of TOML the config file needs: [tables] and
+// key = value lines with string, integer and boolean values, and comments.
+// Keys before the first table are in the table "".
+func parseTOML(r io.Reader) (map[string]map[string]any, error) {
+	tables := map[string]map[string]any{"": {}}
+	table := ""
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			name, ok := strings.CutSuffix(stripComment(line), "]")
+			name = strings.TrimSpace(strings.TrimPrefix(name, "["))
+			if !ok || name == "" {
+				return nil, fmt.Errorf("line %d: invalid table header %s", n, line)
+			}
+			if _, ok := tables[name]; ok && name != "" {
+				return nil, fmt.Errorf("line %d: table %s defined twice", n, name)
+			}
+			table = name
+			tables[table] = make(map[string]any)
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		key = strings.Trim(strings.TrimSpace(key), `"`)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		v, err := parseTOMLValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		if _, ok := tables[table][key]; ok {
+			return nil, fmt.Errorf("line %d: %s set twice", n, key)
+		}
+		tables[table][key] = v
+	}
+	return tables, scanner.Err()
+}
+
+func parseTOMLValue(s string) (any, error) {
+	if strings.HasPrefix(s, `"`) {
+		// the closing quote is the first one not escaped
+		end := 1
+		for ; end < len(s) && s[end] != '"'; end++ {
+			if s[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(s) || stripComment(s[end+1:]) != "" {
+			return nil, fmt.Errorf("invalid string %s", s)
+		}
+		return strconv.Unquote(s[:end+1])
+	}
+	if strings.HasPrefix(s, "'") {
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 || stripComment(s[end+2:]) != "" {
+			return nil, fmt.Errorf("invalid string %s", s)
+		}
+		return s[1 : end+1], nil
+	}
+	s = stripComment(s)
+	switch s {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	n, err := strconv.ParseInt(strings.ReplaceAll(s, "_", ""), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %s, expected a string, integer or boolean", s)
+	}
+	return n, nil
+}
+
+// stripComment drops a trailing # comment from a line without strings
+func stripComment(s string) string {
+	if i := strings.IndexByte(s, '#'); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// dryRun makes insert, update, delete and putblob check what they would
+// do and report it instead of writing. Other commands that write refuse to
+// run. It is set by the -dry-run flag.
+var dryRun bool
+
+// ErrDryRun is returned by commands that write and cannot be simulated
+var ErrDryRun = errors.New("not available in a dry run")
+
+// nextSlot returns the ID the next node inserted into the store would get
+func nextSlot(store *Store) (uint32, error) {
+	head, err := getFree(store.freestore)
+	if err != nil || head != ^uint32(0) {
+		return head, err
+	}
+	fi, err := store.nodestore.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return uint32(store.nodestore.slots(fi.Size())), nil
+}
+
+// placement describes where a new value would be kept
+func placement(store *Store, overflow bool) string {
+	where := "in the node"
+	if overflow {
+		where = "in the overflow store"
+	}
+	if store.meta.UUIDs {
+		where += ", with a new UUID"
+	}
+	return where
+}
+
+func dryInsert(store *Store, value string) error {
+	data, err := valueData(store, value)
+	if err != nil {
+		return err
+	}
+	if err := checkQuota(store, overflowPayload(data)); err != nil {
+		return err
+	}
+	id, err := nextSlot(store)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Dry run: would insert node %d, %d bytes %s: %s\n", id, len(data), placement(store, len(data) > 64), data)
+	return nil
+}
+
+func dryPutBlob(store *Store, blob string) error {
+	data, err := decodeBlob(blob)
+	if err != nil {
+		return err
+	}
+	if err := checkQuota(store, blobPayload(data)); err != nil {
+		return err
+	}
+	id, err := nextSlot(store)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Dry run: would insert blob node %d, %d bytes %s\n", id, len(data), placement(store, true))
+	return nil
+}
+
+func dryUpdate(store *Store, handle string, value string) error {
+	h, checked, err := parseHandle(handle)
+	if err != nil {
+		return err
+	}
+	node, err := getNode(store, h, checked)
+	if err != nil {
+		return err
+	}
+	old, err := showValue(store, node)
+	if err != nil {
+		return err
+	}
+	data, err := valueData(store, value)
+	if err != nil {
+		return err
+	}
+	if err := checkBytes(store, false, overflowPayload(data)); err != nil {
+		return err
+	}
+	fmt.Printf("Dry run: would replace the value of node %s: %s -> %s\n", Handle{node.ID, node.Gen}, old, data)
+	return nil
+}
+
+func dryDelete(store *Store, id uint32) error {
+	node, err := readNode(store.nodestore, id)
+	if err != nil || node.InUse != 1 {
+		return fmt.Errorf("node %d not found", id)
+	}
+	value, err := showValue(store, node)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Dry run: would delete node %d: %s\n", id, value)
+	return nil
+}
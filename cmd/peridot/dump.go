@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nabeeladzan/peridot/internal"
+	"github.com/nabeeladzan/peridot/internal/codec"
+)
+
+// dumpVersion is the version of the dump format written. restore reads
+// dumps up to this version.
+const dumpVersion = 1
+
+// A dump is a logical copy of a store in JSON Lines: a dumpHeader with
+// the metadata, then one dumpRecord per node slot in ID order. It holds
+// what the store means, not how its files are laid out, so it can be
+// restored with another codec or by a later version.
+type dumpHeader struct {
+	Kind    string              `json:"kind"` // always "dump"
+	Version int                 `json:"version"`
+	Store   string              `json:"store"`
+	Meta    *internal.StoreMeta `json:"meta"`
+}
+
+// dumpRecord is one node slot. Free slots keep their generation, so the
+// handles of deleted nodes stay stale after a restore.
+type dumpRecord struct {
+	Kind   string          `json:"kind"` // node or free
+	ID     uint32          `json:"id"`
+	Type   byte            `json:"type,omitempty"`
+	Gen    uint16          `json:"gen,omitempty"`
+	Value  json.RawMessage `json:"value,omitempty"`
+	Blob   []byte          `json:"blob,omitempty"`
+	UUID   string          `json:"uuid,omitempty"`
+	Point  *[2]float64     `json:"point,omitempty"`
+	Vector []float32       `json:"vector,omitempty"`
+}
+
+// dumpStore writes a dump of the store to w and returns the number of
+// nodes in it
+func dumpStore(store *Store, w io.Writer) (int, error) {
+	nodes, err := readStore(store.nodestore)
+	if err != nil {
+		return 0, err
+	}
+	var uuids *uuidIndex
+	if store.meta.UUIDs {
+		if uuids, err = loadUUIDIndex(store); err != nil {
+			return 0, err
+		}
+	}
+	var points map[uint32][2]float64
+	if hasPoints(store) {
+		f, err := geoFile(store)
+		if err != nil {
+			return 0, err
+		}
+		if points, err = readPoints(f); err != nil {
+			return 0, err
+		}
+	}
+	var vectors map[uint32][]float32
+	if store.meta.VectorDim != 0 {
+		f, err := vectorFile(store)
+		if err != nil {
+			return 0, err
+		}
+		if vectors, err = readVectors(f, store.meta.VectorDim); err != nil {
+			return 0, err
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(dumpHeader{"dump", dumpVersion, store.name, store.meta}); err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, node := range nodes {
+		rec := dumpRecord{Kind: "free", ID: node.ID, Gen: node.Gen}
+		if node.InUse == 1 {
+			rec.Kind, rec.Type = "node", node.Type
+			if isBlob(node.Value) {
+				r, err := blobReader(store, node)
+				if err != nil {
+					return n, err
+				}
+				if rec.Blob, err = io.ReadAll(r); err != nil {
+					return n, err
+				}
+			} else {
+				value, err := nodeValue(store, node)
+				if err != nil {
+					return n, err
+				}
+				rec.Value = asJSON(value)
+			}
+			if uuids != nil {
+				if u, ok := uuids.uuids[node.ID]; ok {
+					rec.UUID = u.String()
+				}
+			}
+			if p, ok := points[node.ID]; ok {
+				rec.Point = &p
+			}
+			rec.Vector = vectors[node.ID]
+			n++
+		}
+		if err := enc.Encode(rec); err != nil {
+			return n, err
+		}
+	}
+	return n, bw.Flush()
+}
+
+// restoreStore creates a store from the dump read from r, with codec c,
+// and returns its name and the number of nodes restored. It is named name,
+// or as in the dump if name is blank. The store must not exist; if the
+// restore fails, what was made of it is removed.
+func restoreStore(name string, c codec.Codec, r io.Reader) (_ string, n int, err error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var header dumpHeader
+	if err := dec.Decode(&header); err != nil {
+		return "", 0, fmt.Errorf("invalid dump header: %v", err)
+	}
+	if header.Kind != "dump" || header.Meta == nil {
+		return "", 0, fmt.Errorf("not a dump")
+	}
+	if header.Version > dumpVersion {
+		return "", 0, fmt.Errorf("dump version %d is newer than this server reads, %d", header.Version, dumpVersion)
+	}
+	if name == "" {
+		name = header.Store
+	}
+	if err := validateStoreName(name); err != nil {
+		return "", 0, err
+	}
+
+	nodestore, freestore, err := createStore(name, c)
+	if err != nil {
+		return "", 0, err
+	}
+	store := &Store{name: name, nodestore: nodestore, freestore: freestore, meta: &internal.StoreMeta{}}
+	defer func() {
+		if cerr := comClose(store); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			for _, file := range storeFiles(name) {
+				os.Remove(file)
+			}
+		}
+	}()
+	// the vector dimension is set again by the first vector restored
+	meta := *header.Meta
+	meta.VectorDim = 0
+	if err := writeMeta(name, &meta); err != nil {
+		return "", 0, err
+	}
+	*store.meta = meta
+
+	head := ^uint32(0)
+	buf := make([]byte, c.RecordSize())
+	for id := uint32(0); ; id++ {
+		var rec dumpRecord
+		if err := dec.Decode(&rec); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return name, n, fmt.Errorf("slot %d: invalid record: %v", id, err)
+		}
+		if rec.ID != id {
+			return name, n, fmt.Errorf("slot %d: record is for slot %d, records must cover every slot in order", id, rec.ID)
+		}
+		node := internal.Node{ID: id, Type: rec.Type, Gen: rec.Gen}
+		switch rec.Kind {
+		case "free":
+			if reserved(&meta, id) {
+				// still reserved, kept off the free list
+				codec.Order.PutUint32(node.Value[0:], ^uint32(0))
+				break
+			}
+			// link to next free
+			codec.Order.PutUint32(node.Value[0:], head)
+			head = id
+		case "node":
+			node.InUse = 1
+			if rec.Blob != nil {
+				f, err := overflowFile(store)
+				if err != nil {
+					return name, n, err
+				}
+				if node.Value, err = appendOverflow(f, rec.Blob); err != nil {
+					return name, n, err
+				}
+				node.Value[0] = blobTag
+			} else if node.Value, err = storeValue(store, rec.Value); err != nil {
+				return name, n, err
+			}
+		default:
+			return name, n, fmt.Errorf("slot %d: unknown record kind %q", id, rec.Kind)
+		}
+		if err := c.EncodeNode(buf, node); err != nil {
+			return name, n, err
+		}
+		if _, err := nodestore.WriteAt(buf, nodestore.offset(id)); err != nil {
+			return name, n, err
+		}
+		if node.InUse != 1 {
+			continue
+		}
+		if rec.UUID != "" {
+			u, err := parseUUID(rec.UUID)
+			if err != nil {
+				return name, n, fmt.Errorf("slot %d: %v", id, err)
+			}
+			if err := setUUID(store, id, u); err != nil {
+				return name, n, err
+			}
+		}
+		if rec.Point != nil {
+			if err := setPoint(store, id, rec.Point[0], rec.Point[1]); err != nil {
+				return name, n, err
+			}
+		}
+		if rec.Vector != nil {
+			if err := setVector(store, id, rec.Vector); err != nil {
+				return name, n, err
+			}
+		}
+		n++
+	}
+	return name, n, setFree(freestore, head)
+}
+
+// runDump is the dump subcommand: peridot dump -store name [file|-],
+// writing to standard output for - or no file
+func runDump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	storename := fs.String("store", "", "store to dump")
+	fs.Parse(args)
+	if *storename == "" {
+		return fmt.Errorf("no store given, use -store")
+	}
+	store := &Store{name: *storename, closed: true}
+	if err := useStore(store); err != nil {
+		return err
+	}
+	defer comClose(store)
+
+	var n int
+	var err error
+	if path := fs.Arg(0); path != "" && path != "-" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if n, err = dumpStore(store, f); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	} else if n, err = dumpStore(store, os.Stdout); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Dumped %d nodes from %s\n", n, *storename)
+	return nil
+}
+
+// runRestore is the restore subcommand: peridot restore [-store name]
+// [-codec name] [file|-], reading standard input for - or no file. The
+// store is named as in the dump unless -store is given.
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	storename := fs.String("store", "", "store to create, the dumped store's name by default")
+	codecname := fs.String("codec", "binary", "codec to write the nodes with")
+	fs.Parse(args)
+	if dryRun {
+		return ErrDryRun
+	}
+	c, err := codec.ByName(*codecname)
+	if err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if path := fs.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	name, n, err := restoreStore(*storename, c, in)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Restored %d nodes into %s\n", n, name)
+	return nil
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nabeeladzan/peridot/internal"
+	"github.com/nabeeladzan/peridot/internal/parquet"
+)
+
+// exportRow is one in-use node as exported: its value decoded, and the
+// raw bytes of blobs
+type exportRow struct {
+	node  internal.Node
+	value []byte // JSON, nil for blobs
+	doc   map[string]any
+	blob  []byte
+	uuid  string
+}
+
+// exportRows reads the in-use nodes of the store in ID order
+func exportRows(store *Store) ([]exportRow, error) {
+	nodes, err := scanStore(store.nodestore, opMemory)
+	if err != nil {
+		return nil, err
+	}
+	return nodeRows(store, nodes)
+}
+
+// selectRows reads the in-use nodes the options select, in ID order: those
+// of one node type, those matching a filter, or all of them
+func selectRows(store *Store, opts exportOptions) ([]exportRow, error) {
+	if opts.typ < 0 && opts.filter == nil {
+		return exportRows(store)
+	}
+	var nodes []internal.Node
+	var err error
+	switch {
+	case opts.filter != nil:
+		if nodes, _, err = findNodes(store, opts.filter); err != nil {
+			return nil, err
+		}
+		if opts.typ >= 0 {
+			nodes = slices.DeleteFunc(nodes, func(node internal.Node) bool { return node.Type != byte(opts.typ) })
+		}
+	default:
+		if nodes, err = typedNodes(store, byte(opts.typ)); err != nil {
+			return nil, err
+		}
+	}
+	return nodeRows(store, nodes)
+}
+
+// nodeRows reads the values of the in-use nodes among nodes
+func nodeRows(store *Store, nodes []internal.Node) ([]exportRow, error) {
+	var err error
+	var uuids *uuidIndex
+	if store.meta.UUIDs {
+		if uuids, err = loadUUIDIndex(store); err != nil {
+			return nil, err
+		}
+	}
+	var rows []exportRow
+	for _, node := range nodes {
+		if node.InUse != 1 {
+			continue
+		}
+		row := exportRow{node: node}
+		if uuids != nil {
+			if u, ok := uuids.uuids[node.ID]; ok {
+				row.uuid = u.String()
+			}
+		}
+		if isBlob(node.Value) {
+			r, err := blobReader(store, node)
+			if err != nil {
+				return nil, err
+			}
+			if row.blob, err = io.ReadAll(r); err != nil {
+				return nil, err
+			}
+		} else {
+			value, err := nodeValue(store, node)
+			if err != nil {
+				return nil, err
+			}
+			row.value = asJSON(value)
+			json.Unmarshal(row.value, &row.doc)
+		}
+		if err := opMemory.charge(len(row.blob) + len(row.value)); err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// propertyColumns decides the Parquet column of every top-level document
+// property: the schema type if the store has a schema, otherwise the type
+// all its values share. Properties with values of mixed types, arrays or
+// objects are exported as JSON text.
+func propertyColumns(store *Store, rows []exportRow) []parquet.Column {
+	kinds := make(map[string]map[string]bool)
+	var names []string
+	for _, prop := range store.meta.Schema {
+		kinds[prop.Name] = map[string]bool{prop.Type: true}
+		names = append(names, prop.Name)
+	}
+	if store.meta.Schema == nil {
+		for _, row := range rows {
+			for name, v := range row.doc {
+				if kinds[name] == nil {
+					kinds[name] = make(map[string]bool)
+					names = append(names, name)
+				}
+				switch v.(type) {
+				case nil:
+				case string:
+					kinds[name][typeString] = true
+				case float64:
+					kinds[name][typeNumber] = true
+				case bool:
+					kinds[name][typeBool] = true
+				default:
+					kinds[name]["json"] = true
+				}
+			}
+		}
+		sort.Strings(names)
+	}
+
+	var columns []parquet.Column
+	for _, name := range names {
+		c := parquet.Column{Name: name, Group: "properties", Optional: true, Type: parquet.ByteArray, UTF8: true}
+		kind := "json"
+		if len(kinds[name]) == 1 {
+			for k := range kinds[name] {
+				kind = k
+			}
+		}
+		switch kind {
+		case typeNumber:
+			c.Type, c.UTF8 = parquet.Double, false
+		case typeBool:
+			c.Type, c.UTF8 = parquet.Boolean, false
+		}
+		for _, row := range rows {
+			v, ok := row.doc[name]
+			switch {
+			case !ok || v == nil:
+				c.Values = append(c.Values, nil)
+			case kind == "json":
+				data, _ := json.Marshal(v)
+				c.Values = append(c.Values, string(data))
+			default:
+				c.Values = append(c.Values, v)
+			}
+		}
+		columns = append(columns, c)
+	}
+	return columns
+}
+
+// exportParquet writes the nodes of the store to w as a Parquet file with
+// columns id, type, gen, value (JSON text, null for blobs), blob, uuid if
+// the store has UUIDs, and a properties group with a column per top-level
+// document property. It returns the number of nodes written.
+func exportParquet(store *Store, opts exportOptions, w io.Writer) (int, error) {
+	rows, err := selectRows(store, opts)
+	if err != nil {
+		return 0, err
+	}
+	id := parquet.Column{Name: "id", Type: parquet.Int64}
+	typ := parquet.Column{Name: "type", Type: parquet.Int32}
+	gen := parquet.Column{Name: "gen", Type: parquet.Int32}
+	value := parquet.Column{Name: "value", Type: parquet.ByteArray, Optional: true, UTF8: true}
+	blob := parquet.Column{Name: "blob", Type: parquet.ByteArray, Optional: true}
+	uuid := parquet.Column{Name: "uuid", Type: parquet.ByteArray, Optional: true, UTF8: true}
+	for _, row := range rows {
+		id.Values = append(id.Values, int64(row.node.ID))
+		typ.Values = append(typ.Values, int32(row.node.Type))
+		gen.Values = append(gen.Values, int32(row.node.Gen))
+		if row.value != nil {
+			value.Values = append(value.Values, row.value)
+		} else {
+			value.Values = append(value.Values, nil)
+		}
+		if row.blob != nil {
+			blob.Values = append(blob.Values, row.blob)
+		} else {
+			blob.Values = append(blob.Values, nil)
+		}
+		if row.uuid != "" {
+			uuid.Values = append(uuid.Values, row.uuid)
+		} else {
+			uuid.Values = append(uuid.Values, nil)
+		}
+	}
+	columns := []parquet.Column{id, typ, gen, value, blob}
+	if store.meta.UUIDs {
+		columns = append(columns, uuid)
+	}
+	columns = append(columns, propertyColumns(store, rows)...)
+	return len(rows), parquet.Write(w, columns)
+}
+
+// exportOptions says how to export a store. Columns and header only
+// apply to CSV. Typ and filter select the nodes exported: those of node
+// type typ unless it is -1, and those matching filter unless it is nil.
+type exportOptions struct {
+	format  string
+	columns []string
+	header  bool
+	typ     int
+	filter  []predicate
+}
+
+// parseSelection parses the node type and filter choosing the nodes to
+// export, either of which may be blank
+func (opts *exportOptions) parseSelection(typename, filter string) error {
+	var err error
+	if opts.typ, err = parseNodeType(typename); err != nil {
+		return err
+	}
+	if filter != "" {
+		if opts.filter, err = parseFilter(filter); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvColumns are the columns of a CSV export unless others are chosen
+var csvColumns = []string{"id", "type", "value"}
+
+// exportCSV writes the nodes of the store to w as CSV, one row per node.
+// Columns are id, type, gen, value, blob (base64), uuid, or a path such as
+// $.address.city into document values. Text values are written as their
+// text and other values as JSON; missing values are left empty.
+func exportCSV(store *Store, opts exportOptions, w io.Writer) (int, error) {
+	columns := opts.columns
+	if len(columns) == 0 {
+		columns = csvColumns
+	}
+	paths := make([][]pathStep, len(columns))
+	for i, c := range columns {
+		switch c {
+		case "id", "type", "gen", "value", "blob", "uuid":
+		default:
+			if !strings.HasPrefix(c, "$.") && !strings.HasPrefix(c, "$[") {
+				return 0, fmt.Errorf("unknown column %q, expected id, type, gen, value, blob, uuid or a $. path", c)
+			}
+			path, err := parsePath(c)
+			if err != nil {
+				return 0, err
+			}
+			paths[i] = path
+		}
+	}
+	rows, err := selectRows(store, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	out := csv.NewWriter(w)
+	if opts.header {
+		if err := out.Write(columns); err != nil {
+			return 0, err
+		}
+	}
+	record := make([]string, len(columns))
+	for _, row := range rows {
+		var value any
+		if row.value != nil {
+			json.Unmarshal(row.value, &value)
+		}
+		for i, c := range columns {
+			switch c {
+			case "id":
+				record[i] = strconv.FormatUint(uint64(row.node.ID), 10)
+			case "type":
+				record[i] = strconv.Itoa(int(row.node.Type))
+			case "gen":
+				record[i] = strconv.Itoa(int(row.node.Gen))
+			case "value":
+				record[i] = csvCell(value)
+			case "blob":
+				record[i] = ""
+				if row.blob != nil {
+					record[i] = base64.StdEncoding.EncodeToString(row.blob)
+				}
+			case "uuid":
+				record[i] = row.uuid
+			default:
+				v, _ := lookupPath(value, paths[i])
+				record[i] = csvCell(v)
+			}
+		}
+		if err := out.Write(record); err != nil {
+			return 0, err
+		}
+	}
+	out.Flush()
+	return len(rows), out.Error()
+}
+
+// csvCell formats one value for a CSV cell
+func csvCell(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// exportStore writes the nodes of the store to w in the chosen format
+func exportStore(store *Store, opts exportOptions, w io.Writer) (int, error) {
+	switch opts.format {
+	case "parquet":
+		return exportParquet(store, opts, w)
+	case "ntriples":
+		return exportNTriples(store, opts, w)
+	case "csv":
+		return exportCSV(store, opts, w)
+	}
+	return 0, fmt.Errorf("unknown export format %q", opts.format)
+}
+
+// parseColumns splits a comma-separated list of columns
+func parseColumns(s string) []string {
+	var columns []string
+	for _, c := range strings.Split(s, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			columns = append(columns, c)
+		}
+	}
+	return columns
+}
+
+// runExport is the export subcommand: peridot export -format
+// parquet|ntriples|csv -store name [file|-], writing to standard output
+// for - or no file
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "parquet", "format to write, parquet, ntriples or csv")
+	storename := fs.String("store", "", "store to export")
+	columns := fs.String("columns", strings.Join(csvColumns, ","), "CSV columns: id, type, gen, value, blob, uuid or $. paths")
+	header := fs.Bool("header", true, "write a CSV header row")
+	typename := fs.String("type", "", "export only the nodes of this node type")
+	filter := fs.String("filter", "", "export only the nodes matching this find filter, e.g. $.city = Oslo")
+	fs.Parse(args)
+	opts := exportOptions{format: *format, columns: parseColumns(*columns), header: *header}
+	if err := opts.parseSelection(*typename, *filter); err != nil {
+		return err
+	}
+	if *storename == "" {
+		return fmt.Errorf("no store given, use -store")
+	}
+	store := &Store{name: *storename, closed: true}
+	if err := useStore(store); err != nil {
+		return err
+	}
+	defer comClose(store)
+
+	if path := fs.Arg(0); path != "" && path != "-" {
+		n, err := exportFile(store, opts, path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d nodes from %s to %s\n", n, *storename, path)
+		return nil
+	}
+	n, err := exportStore(store, opts, os.Stdout)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d nodes from %s\n", n, *storename)
+	return nil
+}
+
+// exportFile exports the store into a new file at path. The file is
+// written under a temporary name and renamed into place when complete.
+func exportFile(store *Store, opts exportOptions, path string) (int, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := tmp.Chmod(0644); err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(tmp)
+	n, err := exportStore(store, opts, w)
+	if err != nil {
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return n, replaceFile(tmp.Name(), path)
+}
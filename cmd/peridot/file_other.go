@@ -0,0 +1,15 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package main
+
+import "os"
+
+// lockFile does nothing where there is no file locking to use
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// replaceFile renames src over dst
+func replaceFile(src, dst string) error {
+	return os.Rename(src, dst)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32        = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx  = kernel32.NewProc("LockFileEx")
+	procMoveFileExW = kernel32.NewProc("MoveFileExW")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+	movefileReplaceExisting = 0x1
+	movefileWriteThrough    = 0x8
+)
+
+// lockFile takes an exclusive lock on the first byte of f with LockFileEx,
+// failing at once if another process holds it
+func lockFile(f *os.File) error {
+	var ol syscall.Overlapped
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileFailImmediately|lockfileExclusiveLock, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// replaceFile renames src over dst with MoveFileEx, which only returns
+// once the rename is on disk. Directories cannot be synced on Windows, so
+// this is how a rename is made durable. dst must not be open.
+func replaceFile(src, dst string) error {
+	from, err := syscall.UTF16PtrFromString(src)
+	if err != nil {
+		return err
+	}
+	to, err := syscall.UTF16PtrFromString(dst)
+	if err != nil {
+		return err
+	}
+	r, _, err := procMoveFileExW.Call(uintptr(unsafe.Pointer(from)), uintptr(unsafe.Pointer(to)), movefileReplaceExisting|movefileWriteThrough)
+	if r == 0 {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrStoreFrozen is returned by commands a frozen store refuses
+var ErrStoreFrozen = errors.New("store is frozen")
+
+// A store is frozen while a backup, compaction or migration runs, so
+// nothing changes under it. Frozen for writes, it refuses the commands
+// that change nodes: insert, putblob, update, delete, import, merge into
+// it, geo and vector. Everything else still runs, so the store can be
+// cloned, migrated or archived meanwhile. Frozen for all access, every
+// command that looks the store up fails, until it is thawed. Freezes last
+// as long as the server.
+type freezeLevel int
+
+const (
+	notFrozen freezeLevel = iota
+	frozenWrites
+	frozenAll
+)
+
+func (l freezeLevel) String() string {
+	switch l {
+	case frozenWrites:
+		return "frozen for writes"
+	case frozenAll:
+		return "frozen"
+	}
+	return "not frozen"
+}
+
+// writable fails with ErrStoreFrozen if the store is frozen
+func writable(store *Store) error {
+	if store.frozen != notFrozen {
+		return fmt.Errorf("%w: store %s takes no writes until it is thawed", ErrStoreFrozen, store.name)
+	}
+	return nil
+}
+
+// accessible fails with ErrStoreFrozen if the store is frozen for all
+// access
+func accessible(store *Store) error {
+	if store.frozen == frozenAll {
+		return fmt.Errorf("%w: store %s cannot be used until it is thawed", ErrStoreFrozen, store.name)
+	}
+	return nil
+}
+
+func comFreeze(store *Store, what string) error {
+	// Freeze a store against writes or all access
+	switch what {
+	case "writes", "":
+		store.frozen = frozenWrites
+	case "all":
+		store.frozen = frozenAll
+	default:
+		return fmt.Errorf("unknown freeze %q, expected writes or all", what)
+	}
+	return nil
+}
+
+func comThaw(store *Store) error {
+	// Let a frozen store be used again
+	if store.frozen == notFrozen {
+		return fmt.Errorf("store %s is not frozen", store.name)
+	}
+	store.frozen = notFrozen
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// goTypes are the Go types of the schema property types. Optional
+// properties are pointers, so a missing value is told apart from a zero.
+var goTypes = map[string]string{
+	typeString: "string",
+	typeNumber: "float64",
+	typeBool:   "bool",
+}
+
+// goName turns a store or property name into an exported Go identifier:
+// "first_name" becomes FirstName and "2fa" X2fa
+func goName(s string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		r := []rune(part)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+	name := b.String()
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		name = "X" + name
+	}
+	return name
+}
+
+// genSchema writes the Go source of a struct for the documents of a store
+// with schema, and constants naming its properties for find filters. from
+// says where the schema came from, for the generated header.
+func genSchema(pkg, typename, from string, schema []internal.Property) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by peridot gen from %s. DO NOT EDIT.\n\n", from)
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+
+	fields := make(map[string]string)
+	fmt.Fprintf(&b, "// %s is a document of %s\n", typename, from)
+	fmt.Fprintf(&b, "type %s struct {\n", typename)
+	for _, prop := range schema {
+		field := goName(prop.Name)
+		if other, ok := fields[field]; ok {
+			return nil, fmt.Errorf("properties %s and %s are both named %s in Go", other, prop.Name, field)
+		}
+		fields[field] = prop.Name
+		if prop.Required {
+			fmt.Fprintf(&b, "\t%s %s `json:%q`\n", field, goTypes[prop.Type], prop.Name)
+		} else {
+			fmt.Fprintf(&b, "\t%s *%s `json:%q`\n", field, goTypes[prop.Type], prop.Name+",omitempty")
+		}
+	}
+	fmt.Fprintf(&b, "}\n\n")
+
+	fmt.Fprintf(&b, "// properties of %s, for find filters\n", typename)
+	fmt.Fprintf(&b, "const (\n")
+	for _, prop := range schema {
+		fmt.Fprintf(&b, "\t%s%s = %q\n", typename, goName(prop.Name), prop.Name)
+	}
+	fmt.Fprintf(&b, ")\n")
+	return format.Source(b.Bytes())
+}
+
+// runGen is the gen subcommand: peridot gen -store name | -schema spec
+// [-out dir] [-package name] [-type name], writing a Go file with a
+// struct for the documents the schema allows
+func runGen(args []string) error {
+	fs := flag.NewFlagSet("gen", flag.ExitOnError)
+	storename := fs.String("store", "", "store to read the schema of")
+	spec := fs.String("schema", "", "schema to generate from instead of a store, as name:type!,name:type")
+	out := fs.String("out", ".", "directory to write the Go file to")
+	pkg := fs.String("package", "", "package of the Go file, the name of the -out directory by default")
+	typename := fs.String("type", "", "name of the struct, from the store name by default")
+	fs.Parse(args)
+
+	var schema []internal.Property
+	var from string
+	switch {
+	case (*storename == "") == (*spec == ""):
+		return fmt.Errorf("give one of -store and -schema")
+	case *storename != "":
+		meta, err := readMeta(*storename)
+		if err != nil {
+			return err
+		}
+		if len(meta.Schema) == 0 {
+			return fmt.Errorf("store %s has no schema", *storename)
+		}
+		schema, from = meta.Schema, "store "+*storename
+		if *typename == "" {
+			*typename = goName(*storename)
+		}
+	default:
+		var err error
+		if schema, err = parseSchema(*spec); err != nil {
+			return err
+		}
+		from = "schema " + formatSchema(schema)
+		if *typename == "" {
+			return fmt.Errorf("no type name given, use -type")
+		}
+	}
+
+	dir, err := filepath.Abs(*out)
+	if err != nil {
+		return err
+	}
+	if *pkg == "" {
+		*pkg = strings.ToLower(goName(filepath.Base(dir)))
+	}
+	src, err := genSchema(*pkg, *typename, from, schema)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Fprintf(os.Stderr, "Would write %s to %s\n", *typename, dir)
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, strings.ToLower(*typename)+".go")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, src, 0644); err != nil {
+		return err
+	}
+	if err := replaceFile(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s to %s\n", *typename, path)
+	return nil
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nabeeladzan/peridot/internal/codec"
+)
+
+const geoSize = 24 // 1 (InUse) + 7 (Padding) + 8 (Lat) + 8 (Lon)
+
+const (
+	geohashBase32    = "0123456789bcdefghjkmnpqrstuvwxyz"
+	geohashPrecision = 12
+	earthRadiusKm    = 6371.0
+)
+
+// geoEntry is a node position in the geohash index
+type geoEntry struct {
+	hash string
+	id   uint32
+}
+
+// geoIndex keeps the node positions of a store sorted by geohash, so that
+// every node inside a geohash cell is a contiguous range
+type geoIndex struct {
+	entries []geoEntry
+	points  map[uint32][2]float64
+}
+
+// Nearby is a node returned by a radius query
+type Nearby struct {
+	ID uint32
+	Km float64
+}
+
+// geoFile returns the position store of the store, opening it on first use
+func geoFile(store *Store) (*os.File, error) {
+	if store.geostore != nil {
+		return store.geostore, nil
+	}
+	f, err := os.OpenFile(store.name+"_geo.db", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s_geo", store.name)
+	}
+	store.geostore = f
+	return f, nil
+}
+
+// writePoint stores the position of node id
+func writePoint(f *os.File, id uint32, lat, lon float64) error {
+	buf := make([]byte, geoSize)
+	buf[0] = 1
+	codec.Order.PutUint64(buf[8:], math.Float64bits(lat))
+	codec.Order.PutUint64(buf[16:], math.Float64bits(lon))
+	_, err := f.WriteAt(buf, int64(id)*geoSize)
+	return err
+}
+
+// clearPoint removes the position of node id, if it has one
+func clearPoint(f *os.File, id uint32) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset := int64(id) * geoSize
+	if offset >= fi.Size() {
+		return nil
+	}
+	_, err = f.WriteAt([]byte{0}, offset)
+	return err
+}
+
+// readPoints reads every stored position, keyed by node ID
+func readPoints(f *os.File) (map[uint32][2]float64, error) {
+	points := make(map[uint32][2]float64)
+	buf := make([]byte, geoSize)
+	for i := uint32(0); ; i++ {
+		_, err := f.ReadAt(buf, int64(i)*geoSize)
+		if err != nil {
+			break // EOF or error
+		}
+		if buf[0] == 1 {
+			points[i] = [2]float64{
+				math.Float64frombits(codec.Order.Uint64(buf[8:])),
+				math.Float64frombits(codec.Order.Uint64(buf[16:])),
+			}
+		}
+	}
+	return points, nil
+}
+
+// parsePoint parses a "lat,lon" pair
+func parsePoint(s string) (float64, float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid point %q, expected lat,lon", s)
+	}
+	lat, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil || lat < -90 || lat > 90 {
+		return 0, 0, fmt.Errorf("invalid latitude %q", parts[0])
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || lon < -180 || lon > 180 {
+		return 0, 0, fmt.Errorf("invalid longitude %q", parts[1])
+	}
+	return lat, lon, nil
+}
+
+// geohash encodes a position as a base32 geohash of the given length
+func geohash(lat, lon float64, precision int) string {
+	latRange := [2]float64{-90, 90}
+	lonRange := [2]float64{-180, 180}
+	hash := make([]byte, 0, precision)
+	even := true
+	bit, ch := 0, 0
+	for len(hash) < precision {
+		if even {
+			mid := (lonRange[0] + lonRange[1]) / 2
+			if lon >= mid {
+				ch |= 1 << (4 - bit)
+				lonRange[0] = mid
+			} else {
+				lonRange[1] = mid
+			}
+		} else {
+			mid := (latRange[0] + latRange[1]) / 2
+			if lat >= mid {
+				ch |= 1 << (4 - bit)
+				latRange[0] = mid
+			} else {
+				latRange[1] = mid
+			}
+		}
+		even = !even
+		if bit < 4 {
+			bit++
+		} else {
+			hash = append(hash, geohashBase32[ch])
+			bit, ch = 0, 0
+		}
+	}
+	return string(hash)
+}
+
+// geohashCell returns the height and width in degrees of a geohash cell
+func geohashCell(precision int) (float64, float64) {
+	bits := precision * 5
+	lonBits := (bits + 1) / 2
+	latBits := bits / 2
+	return 180 / math.Pow(2, float64(latBits)), 360 / math.Pow(2, float64(lonBits))
+}
+
+// geohashCellKm returns the height and width in km of a geohash cell,
+// taking the width at the latitude h away from lat towards the pole,
+// where the cells of the neighbouring row are narrowest
+func geohashCellKm(precision int, lat float64) (float64, float64) {
+	h, w := geohashCell(precision)
+	hkm := h * math.Pi / 180 * earthRadiusKm
+	wkm := w * math.Pi / 180 * earthRadiusKm * math.Cos(math.Min(90, math.Abs(lat)+h)*math.Pi/180)
+	return hkm, wkm
+}
+
+// haversine returns the great-circle distance between two points in km
+func haversine(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := math.Pi / 180
+	dlat := (lat2 - lat1) * rad
+	dlon := (lon2 - lon1) * rad
+	a := math.Sin(dlat/2)*math.Sin(dlat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dlon/2)*math.Sin(dlon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(math.Min(1, a)))
+}
+
+// loadGeoIndex returns the geohash index of the store, building it from
+// the position store on first use
+func loadGeoIndex(store *Store) (*geoIndex, error) {
+	if store.geoindex != nil {
+		return store.geoindex, nil
+	}
+	f, err := geoFile(store)
+	if err != nil {
+		return nil, err
+	}
+	points, err := readPoints(f)
+	if err != nil {
+		return nil, err
+	}
+	idx := &geoIndex{points: points}
+	for id, p := range points {
+		idx.entries = append(idx.entries, geoEntry{geohash(p[0], p[1], geohashPrecision), id})
+	}
+	sort.Slice(idx.entries, func(i, j int) bool { return idx.entries[i].less(idx.entries[j]) })
+	store.geoindex = idx
+	return idx, nil
+}
+
+func (e geoEntry) less(o geoEntry) bool {
+	if e.hash == o.hash {
+		return e.id < o.id
+	}
+	return e.hash < o.hash
+}
+
+func (idx *geoIndex) remove(id uint32) {
+	p, ok := idx.points[id]
+	if !ok {
+		return
+	}
+	e := geoEntry{geohash(p[0], p[1], geohashPrecision), id}
+	i := sort.Search(len(idx.entries), func(i int) bool { return !idx.entries[i].less(e) })
+	if i < len(idx.entries) && idx.entries[i] == e {
+		idx.entries = append(idx.entries[:i], idx.entries[i+1:]...)
+	}
+	delete(idx.points, id)
+}
+
+func (idx *geoIndex) add(id uint32, lat, lon float64) {
+	idx.remove(id)
+	e := geoEntry{geohash(lat, lon, geohashPrecision), id}
+	i := sort.Search(len(idx.entries), func(i int) bool { return !idx.entries[i].less(e) })
+	idx.entries = append(idx.entries, geoEntry{})
+	copy(idx.entries[i+1:], idx.entries[i:])
+	idx.entries[i] = e
+	idx.points[id] = [2]float64{lat, lon}
+}
+
+// prefix returns the IDs of the nodes whose geohash starts with prefix
+func (idx *geoIndex) prefix(prefix string) []uint32 {
+	i := sort.Search(len(idx.entries), func(i int) bool { return idx.entries[i].hash >= prefix })
+	var ids []uint32
+	for ; i < len(idx.entries) && strings.HasPrefix(idx.entries[i].hash, prefix); i++ {
+		ids = append(ids, idx.entries[i].id)
+	}
+	return ids
+}
+
+// hasPoints reports whether any node of the store was ever given a position
+func hasPoints(store *Store) bool {
+	if store.geostore != nil {
+		return true
+	}
+	_, err := os.Stat(store.name + "_geo.db")
+	return err == nil
+}
+
+// setPoint attaches a position to an in-use node
+func setPoint(store *Store, id uint32, lat, lon float64) error {
+	node, err := readNode(store.nodestore, id)
+	if err != nil || node.InUse != 1 {
+		return fmt.Errorf("node %d not found", id)
+	}
+	idx, err := loadGeoIndex(store)
+	if err != nil {
+		return err
+	}
+	if err := writePoint(store.geostore, id, lat, lon); err != nil {
+		return err
+	}
+	idx.add(id, lat, lon)
+	return nil
+}
+
+// removePoint drops the position of node id from the store and its index
+func removePoint(store *Store, id uint32) error {
+	idx, err := loadGeoIndex(store)
+	if err != nil {
+		return err
+	}
+	if _, ok := idx.points[id]; !ok {
+		return nil
+	}
+	if err := clearPoint(store.geostore, id); err != nil {
+		return err
+	}
+	idx.remove(id)
+	return nil
+}
+
+// near returns the nodes within km of a point, closest first. The search
+// looks at the geohash cell holding the point and its eight neighbours,
+// at the finest precision whose cells are still at least km across. When
+// even the coarsest cells are narrower than km, as near the poles, where
+// the circle may also reach across the pole, every point is checked.
+func near(store *Store, lat, lon, km float64) ([]Nearby, error) {
+	idx, err := loadGeoIndex(store)
+	if err != nil {
+		return nil, err
+	}
+
+	precision := 1
+	for precision < geohashPrecision {
+		hkm, wkm := geohashCellKm(precision+1, lat)
+		if hkm < km || wkm < km {
+			break
+		}
+		precision++
+	}
+
+	h, w := geohashCell(precision)
+	if hkm, wkm := geohashCellKm(precision, lat); precision == 1 && (hkm < km || wkm < km) {
+		// wider than the coarsest cells, check every point
+		var found []Nearby
+		for id, p := range idx.points {
+			if d := haversine(lat, lon, p[0], p[1]); d <= km {
+				found = append(found, Nearby{ID: id, Km: d})
+			}
+		}
+		sortNearby(found)
+		return found, nil
+	}
+
+	cells := make(map[string]bool)
+	for _, dlat := range []float64{-h, 0, h} {
+		for _, dlon := range []float64{-w, 0, w} {
+			clat := math.Max(-90, math.Min(90, lat+dlat))
+			clon := lon + dlon
+			if clon > 180 {
+				clon -= 360
+			}
+			if clon < -180 {
+				clon += 360
+			}
+			cells[geohash(clat, clon, precision)] = true
+		}
+	}
+
+	var found []Nearby
+	for cell := range cells {
+		for _, id := range idx.prefix(cell) {
+			p := idx.points[id]
+			if d := haversine(lat, lon, p[0], p[1]); d <= km {
+				found = append(found, Nearby{ID: id, Km: d})
+			}
+		}
+	}
+	sortNearby(found)
+	return found, nil
+}
+
+func sortNearby(found []Nearby) {
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Km == found[j].Km {
+			return found[i].ID < found[j].ID
+		}
+		return found[i].Km < found[j].Km
+	})
+}
+
+// clonePoints copies the positions of the given nodes of src into a new
+// position file at path
+func clonePoints(src *Store, path string, ids []uint32) error {
+	idx, err := loadGeoIndex(src)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s", path)
+	}
+	defer out.Close()
+
+	for _, id := range ids {
+		p, ok := idx.points[id]
+		if !ok {
+			continue
+		}
+		if err := writePoint(out, id, p[0], p[1]); err != nil {
+			return err
+		}
+	}
+	return out.Sync()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// ErrStaleReference is returned when a handle names a slot that has been
+// freed, and possibly reused, since the handle was handed out
+var ErrStaleReference = errors.New("stale reference")
+
+// Handle identifies a node across slot reuse: the slot ID plus the
+// generation the slot had when the node was written
+type Handle struct {
+	ID  uint32
+	Gen uint16
+}
+
+func (h Handle) String() string {
+	return fmt.Sprintf("%d:%d", h.ID, h.Gen)
+}
+
+// parseHandle parses "id:gen". A bare "id" is accepted too; the bool is
+// false then and the generation is not checked.
+func parseHandle(s string) (Handle, bool, error) {
+	idpart, genpart, checked := strings.Cut(s, ":")
+	id, err := strconv.ParseUint(idpart, 10, 32)
+	if err != nil {
+		return Handle{}, false, fmt.Errorf("invalid node handle %q", s)
+	}
+	h := Handle{ID: uint32(id)}
+	if checked {
+		gen, err := strconv.ParseUint(genpart, 10, 16)
+		if err != nil {
+			return Handle{}, false, fmt.Errorf("invalid node handle %q", s)
+		}
+		h.Gen = uint16(gen)
+	}
+	return h, checked, nil
+}
+
+// getNode reads the node a handle refers to
+func getNode(store *Store, h Handle, checked bool) (internal.Node, error) {
+	node, err := readNode(store.nodestore, h.ID)
+	if err != nil || (node.InUse != 1 && !checked) {
+		return internal.Node{}, fmt.Errorf("node %d not found", h.ID)
+	}
+	if checked && (node.InUse != 1 || node.Gen != h.Gen) {
+		return internal.Node{}, fmt.Errorf("node %s: %w", h, ErrStaleReference)
+	}
+	return node, nil
+}
+
+// updateNode replaces the value of the node a handle refers to, keeping
+// its ID, type and generation
+func updateNode(store *Store, h Handle, checked bool, value string) error {
+	node, err := getNode(store, h, checked)
+	if err != nil {
+		return err
+	}
+
+	data, err := valueData(store, value)
+	if err != nil {
+		return err
+	}
+	// the node is there already, only a long value takes more room
+	if err := checkBytes(store, false, overflowPayload(data)); err != nil {
+		return err
+	}
+	fixed, err := storeValue(store, data)
+	if err != nil {
+		return err
+	}
+
+	node.Value = fixed
+	f := store.nodestore
+	buf := make([]byte, f.codec.RecordSize())
+	if err := f.codec.EncodeNode(buf, node); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(buf, f.offset(node.ID)); err != nil {
+		return err
+	}
+	return reindexNode(store, node.ID)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+)
+
+// maxOpenStores is the most stores kept open between commands, 0 for no
+// limit. It is set by the -max-open-stores flag.
+var maxOpenStores = 128
+
+// storeUses orders stores by their last use, to close the least recently
+// used first
+var storeUses uint64
+
+// useStore opens the files of a store that was registered or left closed
+// and marks it as used just now
+func useStore(store *Store) error {
+	if store.closed {
+		if err := reopenFiles(store); err != nil {
+			return err
+		}
+		store.closed = false
+	}
+	storeUses++
+	store.used = storeUses
+	return nil
+}
+
+// closeIdleStores closes the least recently used stores beyond
+// maxOpenStores. It runs between commands, so a command never loses a
+// store it has looked up. Stores building an index are left open.
+func closeIdleStores(stores *registry) {
+	if maxOpenStores <= 0 {
+		return
+	}
+	var open []*Store
+	for _, store := range stores.List() {
+		if !store.cold && !store.closed {
+			open = append(open, store)
+		}
+	}
+	sort.Slice(open, func(i, j int) bool { return open[i].used < open[j].used })
+	for _, store := range open {
+		if len(open) <= maxOpenStores {
+			break
+		}
+		if building(store) {
+			continue
+		}
+		if err := comClose(store); err != nil {
+			fmt.Println("Error closing store:", err)
+		}
+		open = open[1:]
+	}
+}
+
+// attachStore registers a store whose files were put in the data or cold
+// directory after startup. It is opened on first use.
+func attachStore(stores *registry, name string) error {
+	if err := validateStoreName(name); err != nil {
+		return err
+	}
+	if _, ok := stores.Get(name); ok {
+		return fmt.Errorf("store %s is already attached", name)
+	}
+	findCache.forget(name)
+	if _, err := os.Stat(name + ".db"); err == nil {
+		return stores.Add(&Store{name: name, closed: true})
+	}
+	if coldDir != "" {
+		if _, err := os.Stat(filepath.Join(coldDir, name+".db")); err == nil {
+			return stores.Add(&Store{name: name, cold: true})
+		}
+	}
+	return fmt.Errorf("no files for store %s", name)
+}
+
+// detachStore closes a store and forgets it, leaving its files alone. A
+// store with writes queued in the open transaction or an index being built
+// stays attached.
+func detachStore(stores *registry, name string) error {
+	store, ok := stores.Get(name)
+	if !ok {
+		return fmt.Errorf("store %s not found", name)
+	}
+	if tx != nil {
+		for _, op := range tx.ops {
+			if op.store == name {
+				return fmt.Errorf("store %s has writes queued in the open transaction", name)
+			}
+		}
+	}
+	if building(store) {
+		return fmt.Errorf("store %s is building an index, try again when it is done", name)
+	}
+	if err := comClose(store); err != nil {
+		return err
+	}
+	if currentStore == name {
+		currentStore = ""
+	}
+	stores.Remove(name)
+	return nil
+}
+
+// refreshStores scans the data and cold directories again, attaching the
+// stores that appeared and detaching those whose files are gone. Stores
+// that cannot be detached yet are kept.
+func refreshStores(stores *registry) (attached, detached []string, err error) {
+	names, err := discoverStores(".")
+	if err != nil {
+		return nil, nil, err
+	}
+	if coldDir != "" {
+		cold, err := discoverStores(coldDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		names = append(names, cold...)
+	}
+	for _, name := range names {
+		if _, ok := stores.Get(name); ok || validateStoreName(name) != nil {
+			continue
+		}
+		if attachStore(stores, name) == nil {
+			attached = append(attached, name)
+		}
+	}
+	for _, store := range stores.List() {
+		if slices.Contains(names, store.name) {
+			continue
+		}
+		if detachStore(stores, store.name) == nil {
+			detached = append(detached, store.name)
+		}
+	}
+	return attached, detached, nil
+}
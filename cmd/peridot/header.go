@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/nabeeladzan/peridot/internal/codec"
+)
+
+// The nodestore starts with a header the size of one record, so records
+// stay slot aligned. Stores written before the header existed are format 0.
+//
+//	0  8 bytes  magic "PERIDOT\x00"
+//	8  2 bytes  format version
+//	10 2 bytes  flags, headerOpen while a server has the store open
+//	12 4 bytes  record size
+//	16 1 byte   codec ID
+const formatVersion = 1
+
+// minHeaderSize is how much of the header has to be read to find the
+// codec, and with it the real header size
+const minHeaderSize = codec.NodeSize
+
+var headerMagic = []byte("PERIDOT\x00")
+
+// headerOpen is set in the header flags when a store is opened and cleared
+// when it is closed. Finding it set on open means the last server to use
+// the store stopped without closing it.
+const headerOpen = 1
+
+// nodeFile is an open nodestore together with the codec its records are
+// written in, a cache of recently read nodes, nil when caching is off, and
+// the I/O counters of its store
+type nodeFile struct {
+	*os.File
+	codec codec.Codec
+	cache *nodeCache
+	io    *ioStats
+	// bumped by every write, to tell cached find results apart
+	epoch uint64
+	// the store was not closed cleanly the last time it was open
+	unclean bool
+}
+
+// offset returns where the record of node id starts in the nodestore
+func (f *nodeFile) offset(id uint32) int64 {
+	size := int64(f.codec.RecordSize())
+	return size + int64(id)*size
+}
+
+// slots returns how many record slots a nodestore of the given size holds
+func (f *nodeFile) slots(size int64) int64 {
+	record := int64(f.codec.RecordSize())
+	if size < record {
+		return 0
+	}
+	return (size - record) / record
+}
+
+// writeHeader writes a current-format header for codec c at the start of f
+func writeHeader(f *os.File, c codec.Codec) error {
+	buf := make([]byte, c.RecordSize())
+	copy(buf[0:], headerMagic)
+	codec.Order.PutUint16(buf[8:], formatVersion)
+	codec.Order.PutUint32(buf[12:], uint32(c.RecordSize()))
+	buf[16] = c.ID()
+	_, err := f.WriteAt(buf, 0)
+	return err
+}
+
+// readHeader returns the format version and codec of a nodestore. A file
+// without the magic predates the header: it is format 0 and binary.
+func readHeader(f *os.File) (uint16, codec.Codec, error) {
+	buf := make([]byte, minHeaderSize)
+	n, err := f.ReadAt(buf, 0)
+	if n < len(headerMagic) || !bytes.Equal(buf[:len(headerMagic)], headerMagic) {
+		return 0, codec.Binary{}, nil
+	}
+	if n < minHeaderSize {
+		return 0, nil, fmt.Errorf("store header is truncated: %v", err)
+	}
+	c, err := codec.ByID(buf[16])
+	if err != nil {
+		return 0, nil, err
+	}
+	if size := codec.Order.Uint32(buf[12:]); size != uint32(c.RecordSize()) {
+		return 0, nil, fmt.Errorf("store has %d byte records, %s codec expects %d", size, c.Name(), c.RecordSize())
+	}
+	return codec.Order.Uint16(buf[8:]), c, nil
+}
+
+// openNodeFile checks that the nodestore is in the current format and
+// pairs it with its codec
+func openNodeFile(name string, f *os.File) (*nodeFile, error) {
+	version, c, err := readHeader(f)
+	if err != nil {
+		return nil, fmt.Errorf("store %s: %v", name, err)
+	}
+	if version > formatVersion {
+		return nil, fmt.Errorf("store %s uses format %d, newer than this server supports (%d)", name, version, formatVersion)
+	}
+	if version < formatVersion {
+		return nil, fmt.Errorf("store %s uses format %d, run migrate to upgrade it to %d", name, version, formatVersion)
+	}
+	// a dry run writes nothing, not even the flag
+	unclean, err := setOpenFlag(f, true, !dryRun)
+	if err != nil {
+		return nil, fmt.Errorf("store %s: %v", name, err)
+	}
+	return &nodeFile{File: f, codec: c, cache: newNodeCache(nodeCacheSize), io: ioStatsFor(name), unclean: unclean}, nil
+}
+
+// setOpenFlag sets or clears headerOpen in the header of f, syncing it if
+// write is set, and returns whether it was set before
+func setOpenFlag(f *os.File, open bool, write bool) (bool, error) {
+	buf := make([]byte, 2)
+	if _, err := f.ReadAt(buf, 10); err != nil {
+		return false, err
+	}
+	flags := codec.Order.Uint16(buf)
+	was := flags&headerOpen != 0
+	if !write {
+		return was, nil
+	}
+	if open {
+		flags |= headerOpen
+	} else {
+		flags &^= headerOpen
+	}
+	codec.Order.PutUint16(buf, flags)
+	if _, err := f.WriteAt(buf, 10); err != nil {
+		return was, err
+	}
+	return was, f.Sync()
+}
@@ -0,0 +1,59 @@
+package main
+
+import "fmt"
+
+// idempotencyKeysKept is how many idempotency keys a store remembers; the
+// oldest is forgotten first
+const idempotencyKeysKept = 1024
+
+// idempotencyKeys remembers the writes recently made under idempotency
+// keys, so a write retried with the same key is answered with the node it
+// made the first time instead of being made again. Keys live as long as
+// the session.
+type idempotencyKeys struct {
+	done  map[string]keyedWrite
+	order []string // oldest first
+}
+
+// keyedWrite is a write made under a key: what was asked for and the node
+// it wrote
+type keyedWrite struct {
+	request string
+	node    Handle
+}
+
+// recall returns the node written under key, if any. Reusing a key for a
+// different request is an error.
+func (k *idempotencyKeys) recall(key, request string) (Handle, bool, error) {
+	if k == nil || key == "" {
+		return Handle{}, false, nil
+	}
+	w, ok := k.done[key]
+	if !ok {
+		return Handle{}, false, nil
+	}
+	if w.request != request {
+		return Handle{}, false, fmt.Errorf("idempotency key %q was already used for another write", key)
+	}
+	return w.node, true, nil
+}
+
+// remember records the node written under key
+func remember(store *Store, key, request string, node Handle) {
+	if key == "" {
+		return
+	}
+	k := store.idempotency
+	if k == nil {
+		k = &idempotencyKeys{done: make(map[string]keyedWrite)}
+		store.idempotency = k
+	}
+	if _, ok := k.done[key]; !ok {
+		k.order = append(k.order, key)
+	}
+	k.done[key] = keyedWrite{request, node}
+	if len(k.order) > idempotencyKeysKept {
+		delete(k.done, k.order[0])
+		k.order = k.order[1:]
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// importBatch is how many records are validated before any of them is
+// written, and appended to the nodestore in one write when no free slot
+// can be reused
+const importBatch = 256
+
+// ImportRecord is one line of a JSON Lines import. Kind is "node" or
+// empty; edge records are rejected, as there is no edge store yet. A
+// record with an ID fills that reserved slot.
+type ImportRecord struct {
+	Kind  string          `json:"kind,omitempty"`
+	ID    *uint32         `json:"id,omitempty"`
+	Type  byte            `json:"type,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+// importJSONL streams JSON Lines records from r into the store, a batch
+// at a time. With untilBlank it stops at the first blank line, for
+// records typed into the REPL, and on errors skips the records up to it.
+// Batches before a bad record stay written; the IDs returned are those of
+// the nodes imported, in record order.
+func importJSONL(store *Store, r *bufio.Reader, untilBlank bool) ([]uint32, error) {
+	var imported []uint32
+	line := 0
+	var batch []internal.Node
+	fail := func(err error) ([]uint32, error) {
+		if untilBlank {
+			skipRecords(r)
+		}
+		return imported, err
+	}
+	for {
+		text, err := r.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return imported, err
+		}
+		eof := err != nil
+		text = strings.TrimSpace(text)
+		if text == "" && (untilBlank || eof) {
+			break
+		}
+		line++
+		if text != "" {
+			node, fixed, err := importNode(store, []byte(text))
+			if err != nil {
+				return fail(fmt.Errorf("line %d: %v", line, err))
+			}
+			if !fixed {
+				batch = append(batch, node)
+			} else {
+				// the batch so far goes first, to keep the records in order
+				ids, err := insertBatch(store, batch)
+				imported = append(imported, ids...)
+				if err != nil {
+					return fail(err)
+				}
+				batch = batch[:0]
+				if err := fillReserved(store, node); err != nil {
+					return fail(fmt.Errorf("line %d: %v", line, err))
+				}
+				imported = append(imported, node.ID)
+			}
+		}
+		if len(batch) == importBatch {
+			ids, err := insertBatch(store, batch)
+			imported = append(imported, ids...)
+			if err != nil {
+				return fail(err)
+			}
+			batch = batch[:0]
+		}
+		if eof {
+			break
+		}
+	}
+	ids, err := insertBatch(store, batch)
+	return append(imported, ids...), err
+}
+
+// formatIDs lists node IDs compactly, runs of consecutive IDs as ranges:
+// "0-255, 300, 302-310"
+func formatIDs(ids []uint32) string {
+	var parts []string
+	for i := 0; i < len(ids); {
+		j := i
+		for j+1 < len(ids) && ids[j+1] == ids[j]+1 {
+			j++
+		}
+		if j == i {
+			parts = append(parts, fmt.Sprint(ids[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", ids[i], ids[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ", ")
+}
+
+// skipRecords reads past the records typed into the REPL, up to the
+// blank line that ends them
+func skipRecords(r *bufio.Reader) {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil || strings.TrimSpace(line) == "" {
+			return
+		}
+	}
+}
+
+// importNode parses and validates one record into the node to insert.
+// Values are taken the way the insert prompt takes them: strings by
+// their text, objects and arrays as documents. fixed is set for a record
+// with an ID, which is in the node returned.
+func importNode(store *Store, text []byte) (node internal.Node, fixed bool, err error) {
+	var rec ImportRecord
+	dec := json.NewDecoder(bytes.NewReader(text))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&rec); err != nil {
+		return internal.Node{}, false, fmt.Errorf("invalid record: %v", err)
+	}
+	switch rec.Kind {
+	case "", "node":
+	case "edge":
+		return internal.Node{}, false, fmt.Errorf("edge records are not supported")
+	default:
+		return internal.Node{}, false, fmt.Errorf("unknown record kind %q", rec.Kind)
+	}
+	if rec.Value == nil {
+		return internal.Node{}, false, fmt.Errorf("record has no value")
+	}
+	value := string(rec.Value)
+	var s string
+	if json.Unmarshal(rec.Value, &s) == nil {
+		value = s
+	}
+	encoded, err := encodeValue(store, value)
+	if err != nil {
+		return internal.Node{}, false, err
+	}
+	node = internal.Node{Type: rec.Type, InUse: 1, Value: encoded}
+	if rec.ID != nil {
+		node.ID = *rec.ID
+	}
+	return node, rec.ID != nil, nil
+}
+
+// insertBatch writes nodes into free slots first, then appends the rest
+// to the nodestore in a single write. Stores with a quota are filled one
+// node at a time, so the quota is checked for each. It returns the IDs of
+// the nodes written, also when it fails part way.
+func insertBatch(store *Store, nodes []internal.Node) ([]uint32, error) {
+	var ids []uint32
+	for len(nodes) > 0 {
+		head, err := getFree(store.freestore)
+		if err != nil {
+			return ids, err
+		}
+		quota := store.meta.MaxNodes != 0 || store.meta.MaxBytes != 0
+		if head == ^uint32(0) && !quota {
+			break
+		}
+		// long values went to the overflow store as the records were read
+		if err := checkQuota(store, 0); err != nil {
+			return ids, err
+		}
+		id, err := putNode(store.nodestore, store.freestore, nodes[0])
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+		nodes = nodes[1:]
+	}
+	if len(nodes) > 0 {
+		f := store.nodestore
+		fi, err := f.Stat()
+		if err != nil {
+			return ids, err
+		}
+		first := uint32(f.slots(fi.Size()))
+		size := f.codec.RecordSize()
+		buf := make([]byte, len(nodes)*size)
+		for i, node := range nodes {
+			node.ID = first + uint32(i)
+			if err := f.codec.EncodeNode(buf[i*size:(i+1)*size], node); err != nil {
+				return ids, err
+			}
+		}
+		if _, err := f.WriteAt(buf, f.offset(first)); err != nil {
+			return ids, err
+		}
+		for i := range nodes {
+			ids = append(ids, first+uint32(i))
+		}
+	}
+
+	for _, id := range ids {
+		if err := insertedNode(store, id); err != nil {
+			return ids, err
+		}
+	}
+	return ids, nil
+}
+
+// insertedNode indexes a node just written by import, gives it a UUID if
+// the store has them, and tells the webhooks
+func insertedNode(store *Store, id uint32) error {
+	if err := reindexNode(store, id); err != nil {
+		return err
+	}
+	if store.meta.UUIDs {
+		if _, err := assignUUID(store, id); err != nil {
+			return err
+		}
+	}
+	return notify(store, "insert", id)
+}
+
+// runImport is the import subcommand: peridot import [-format jsonl]
+// -store name [file|-], reading standard input for - or no file
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "jsonl", "format of the records, only jsonl")
+	storename := fs.String("store", "", "store to import into")
+	fs.Parse(args)
+	if *format != "jsonl" {
+		return fmt.Errorf("unknown import format %q", *format)
+	}
+	if *storename == "" {
+		return fmt.Errorf("no store given, use -store")
+	}
+	if dryRun {
+		return ErrDryRun
+	}
+
+	in := os.Stdin
+	if path := fs.Arg(0); path != "" && path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	store := &Store{name: *storename, closed: true}
+	if err := useStore(store); err != nil {
+		return err
+	}
+	defer comClose(store)
+	ids, err := importJSONL(store, bufio.NewReader(in), false)
+	if !flushWebhooks() {
+		fmt.Fprintln(os.Stderr, "Error delivering webhooks: gave up waiting, some events were not sent")
+	}
+	fmt.Fprintf(os.Stderr, "Imported %d nodes into %s\n", len(ids), *storename)
+	if len(ids) > 0 {
+		fmt.Fprintf(os.Stderr, "Node IDs: %s\n", formatIDs(ids))
+	}
+	return err
+}
@@ -0,0 +1,602 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// propIndex orders the document nodes of a store by the values of one or
+// more top-level properties in a B-tree. A missing property sorts as null.
+type propIndex struct {
+	props []string
+
+	// mu guards the index while a background build fills it in
+	mu      sync.Mutex
+	entries btree
+	keys    map[uint32][]any
+
+	// prefixes[i] counts the entries under each distinct prefix of i+1
+	// key values, for the planner to estimate how many entries an equality
+	// selects
+	prefixes []map[string]int
+
+	// a building index is not used by find until every slot that existed
+	// when the build started has been scanned
+	building    bool
+	done, total int64
+	err         error
+}
+
+type indexEntry struct {
+	key []any
+	id  uint32
+}
+
+// bound is one end of a range scan
+type bound struct {
+	value     any
+	inclusive bool
+}
+
+func (idx *propIndex) name() string {
+	return strings.Join(idx.props, ",")
+}
+
+// ready reports whether the index can be used to answer queries
+func (idx *propIndex) ready() bool {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return !idx.building && idx.err == nil
+}
+
+// status describes the index for the indexes command
+func (idx *propIndex) status() string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	switch {
+	case idx.err != nil:
+		return fmt.Sprintf("build failed: %v", idx.err)
+	case idx.building && idx.total > 0:
+		return fmt.Sprintf("building, %d%%", idx.done*100/idx.total)
+	case idx.building:
+		return "building"
+	}
+	return fmt.Sprintf("ready, %d keys, %d distinct", idx.entries.len, len(idx.prefixes[len(idx.props)-1]))
+}
+
+// prefixKey is the prefixes map key of the first n values of key
+func prefixKey(key []any, n int) string {
+	data, _ := json.Marshal(key[:n])
+	return string(data)
+}
+
+// typeRank orders values of different JSON types: null, bool, number,
+// string, then arrays and objects
+func typeRank(v any) int {
+	switch v.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case float64:
+		return 2
+	case string:
+		return 3
+	}
+	return 4
+}
+
+// compareValues orders two decoded JSON values
+func compareValues(a, b any) int {
+	if ra, rb := typeRank(a), typeRank(b); ra != rb {
+		return ra - rb
+	}
+	switch a := a.(type) {
+	case nil:
+		return 0
+	case bool:
+		switch {
+		case a == b.(bool):
+			return 0
+		case !a:
+			return -1
+		}
+		return 1
+	case float64:
+		switch b := b.(float64); {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+		return 0
+	case string:
+		return strings.Compare(a, b.(string))
+	}
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return strings.Compare(string(ja), string(jb))
+}
+
+// compareKeys orders keys by their first len(b) values
+func compareKeys(a, b []any) int {
+	for i := range b {
+		if c := compareValues(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func (e indexEntry) less(o indexEntry) bool {
+	if c := compareKeys(e.key, o.key); c != 0 {
+		return c < 0
+	}
+	return e.id < o.id
+}
+
+// indexKey returns the key of a document under props. Values that are not
+// JSON objects are not indexed.
+func indexKey(props []string, value []byte) ([]any, bool) {
+	var doc map[string]any
+	if err := json.Unmarshal(value, &doc); err != nil || doc == nil {
+		return nil, false
+	}
+	key := make([]any, len(props))
+	for i, prop := range props {
+		key[i] = doc[prop]
+	}
+	return key, true
+}
+
+func (idx *propIndex) remove(id uint32) {
+	key, ok := idx.keys[id]
+	if !ok {
+		return
+	}
+	idx.entries.delete(indexEntry{key, id})
+	delete(idx.keys, id)
+	for i, counts := range idx.prefixes {
+		p := prefixKey(key, i+1)
+		if counts[p]--; counts[p] == 0 {
+			delete(counts, p)
+		}
+	}
+}
+
+func (idx *propIndex) add(id uint32, key []any) {
+	idx.remove(id)
+	idx.entries.insert(indexEntry{key, id})
+	idx.keys[id] = key
+	for i, counts := range idx.prefixes {
+		counts[prefixKey(key, i+1)]++
+	}
+}
+
+// scan returns the IDs of the nodes whose key starts with eq and whose
+// next value lies between lo and hi, either of which may be nil
+func (idx *propIndex) scan(eq []any, lo, hi *bound) []uint32 {
+	start := eq
+	if lo != nil {
+		start = append(append([]any{}, eq...), lo.value)
+	}
+	from := func(e indexEntry) bool { return compareKeys(e.key, start) >= 0 }
+	var ids []uint32
+	idx.entries.ascend(from, func(e indexEntry) bool {
+		if compareKeys(e.key, eq) != 0 {
+			return false
+		}
+		if lo != nil && !lo.inclusive && compareValues(e.key[len(eq)], lo.value) == 0 {
+			return true
+		}
+		if hi != nil {
+			c := compareValues(e.key[len(eq)], hi.value)
+			if c > 0 || (c == 0 && !hi.inclusive) {
+				return false
+			}
+		}
+		ids = append(ids, e.id)
+		return true
+	})
+	return ids
+}
+
+// Costs of the planner, in units of one node read in a full scan. Reading
+// the nodes an index points at seeks around the nodestore, so each costs
+// more than a node read in order.
+const (
+	costScanRead  = 1.0
+	costIndexRead = 4.0
+	// fraction of the entries a range is assumed to select, with one or
+	// both ends bounded, when analyze has not been run
+	rangeSelectivity   = 1.0 / 3
+	betweenSelectivity = 1.0 / 9
+)
+
+// Plan is an access path for a find and its estimated cost
+type Plan struct {
+	Index *propIndex // nil for a full scan
+	Rows  float64    // estimated nodes read
+	Cost  float64
+
+	eq     []any
+	lo, hi *bound
+}
+
+func (p Plan) String() string {
+	if p.Index == nil {
+		return fmt.Sprintf("full scan, %.0f rows", p.Rows)
+	}
+	return fmt.Sprintf("index %s, ~%.0f rows", p.Index.name(), p.Rows)
+}
+
+// estimate returns the number of entries a scan of the index for eq, lo
+// and hi is expected to select. An equality on a prefix selects the
+// average number of entries per distinct prefix, and a range the share
+// of values the histogram of the property puts in it.
+func (idx *propIndex) estimate(eq []any, lo, hi *bound, stats *internal.Stats) float64 {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	rows := float64(idx.entries.len)
+	if len(eq) > 0 {
+		if n := len(idx.prefixes[len(eq)-1]); n > 0 {
+			rows /= float64(n)
+		}
+	}
+	if lo == nil && hi == nil {
+		return rows
+	}
+	if ps := propertyStats(stats, idx.props[len(eq)]); ps != nil && len(ps.Histogram) > 1 {
+		return rows * rangeFraction(ps.Histogram, lo, hi)
+	}
+	if lo != nil && hi != nil {
+		return rows * betweenSelectivity
+	}
+	return rows * rangeSelectivity
+}
+
+// planScan costs a full scan over slots nodes against every ready index
+// that a leading run of equalities, or a range on the property after
+// them, lets it narrow, and returns the cheapest. stats is nil for stores
+// that have not been analyzed.
+func planScan(idxs []*propIndex, preds []predicate, slots int64, stats *internal.Stats) Plan {
+	best := Plan{Rows: float64(slots), Cost: float64(slots) * costScanRead}
+	for _, idx := range idxs {
+		if !idx.ready() {
+			continue
+		}
+		var eq []any
+		for _, prop := range idx.props {
+			v, ok := equalityOn(preds, prop)
+			if !ok {
+				break
+			}
+			eq = append(eq, v)
+		}
+		var lo, hi *bound
+		if len(eq) < len(idx.props) {
+			lo, hi = rangeOn(preds, idx.props[len(eq)])
+		}
+		if len(eq) == 0 && lo == nil && hi == nil {
+			continue
+		}
+		rows := idx.estimate(eq, lo, hi, stats)
+		// walking down the tree costs about one read
+		if cost := costScanRead + rows*costIndexRead; cost < best.Cost {
+			best = Plan{Index: idx, Rows: rows, Cost: cost, eq: eq, lo: lo, hi: hi}
+		}
+	}
+	return best
+}
+
+// ids returns the IDs the plan looks at, in ID order. It is nil for a
+// full scan.
+func (p Plan) ids() []uint32 {
+	if p.Index == nil {
+		return nil
+	}
+	ids := p.Index.scan(p.eq, p.lo, p.hi)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// equalityOn returns the value an equality predicate fixes prop to
+func equalityOn(preds []predicate, prop string) (any, bool) {
+	for _, p := range preds {
+		if p.op == "=" && p.prop() == prop {
+			return p.operand, true
+		}
+	}
+	return nil, false
+}
+
+// rangeOn returns the tightest bounds the predicates put on prop
+func rangeOn(preds []predicate, prop string) (*bound, *bound) {
+	var lo, hi *bound
+	for _, p := range preds {
+		if p.prop() != prop {
+			continue
+		}
+		b := &bound{p.operand, p.op == ">=" || p.op == "<="}
+		switch p.op {
+		case ">", ">=":
+			if lo == nil || compareValues(b.value, lo.value) > 0 {
+				lo = b
+			}
+		case "<", "<=":
+			if hi == nil || compareValues(b.value, hi.value) < 0 {
+				hi = b
+			}
+		}
+	}
+	return lo, hi
+}
+
+func newPropIndex(props []string) *propIndex {
+	idx := &propIndex{props: props, keys: make(map[uint32][]any)}
+	for range props {
+		idx.prefixes = append(idx.prefixes, make(map[string]int))
+	}
+	return idx
+}
+
+// update indexes node, or drops it from the index if it is not an in-use
+// document. The caller holds idx.mu when the index may be building.
+func (idx *propIndex) update(store *Store, node internal.Node) error {
+	idx.remove(node.ID)
+	if node.InUse != 1 || isBlob(node.Value) {
+		return nil
+	}
+	value, err := nodeValue(store, node)
+	if err != nil {
+		return err
+	}
+	if key, ok := indexKey(idx.props, value); ok {
+		idx.add(node.ID, key)
+	}
+	return nil
+}
+
+// buildIndex indexes the in-use nodes of the store under props
+func buildIndex(store *Store, nodes []internal.Node, props []string) (*propIndex, error) {
+	idx := newPropIndex(props)
+	for _, node := range nodes {
+		if err := idx.update(store, node); err != nil {
+			return nil, err
+		}
+	}
+	return idx, nil
+}
+
+// startBuild fills in idx from the nodestore in the background. Each slot
+// is read and indexed under idx.mu, and writes made meanwhile go through
+// reindexNode under the same lock, so whichever runs last sees the slot as
+// it is on disk. Slots appended after the build started are indexed by
+// reindexNode alone.
+func startBuild(store *Store, idx *propIndex) error {
+	fi, err := store.nodestore.Stat()
+	if err != nil {
+		return err
+	}
+	// open the overflow store now, the build must not race the REPL to it
+	if _, err := overflowFile(store); err != nil {
+		return err
+	}
+	idx.building = true
+	idx.total = store.nodestore.slots(fi.Size())
+	go func() {
+		for id := int64(0); id < idx.total; id++ {
+			idx.mu.Lock()
+			node, err := readNode(store.nodestore, uint32(id))
+			if err == nil {
+				err = idx.update(store, node)
+			}
+			idx.done++
+			if err != nil {
+				idx.err = err
+				idx.building = false
+			}
+			idx.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+		idx.mu.Lock()
+		idx.building = false
+		idx.mu.Unlock()
+	}()
+	return nil
+}
+
+// building reports whether any index of the store is being built
+func building(store *Store) bool {
+	for _, idx := range store.indexes {
+		idx.mu.Lock()
+		b := idx.building
+		idx.mu.Unlock()
+		if b {
+			return true
+		}
+	}
+	return false
+}
+
+// loadIndexes returns the property indexes of the store, building them
+// from the nodestore on first use
+func loadIndexes(store *Store) ([]*propIndex, error) {
+	if store.indexes != nil {
+		return store.indexes, nil
+	}
+	idxs := []*propIndex{}
+	if len(store.meta.Indexes) > 0 {
+		nodes, err := readStore(store.nodestore)
+		if err != nil {
+			return nil, err
+		}
+		for _, def := range store.meta.Indexes {
+			idx, err := buildIndex(store, nodes, def.Properties)
+			if err != nil {
+				return nil, err
+			}
+			idxs = append(idxs, idx)
+		}
+	}
+	store.indexes = idxs
+	return idxs, nil
+}
+
+// reindexNode brings the loaded property, full-text and type indexes of the
+// store up to date with node id after it was written or deleted. Indexes
+// that are not loaded yet are built from the nodestore later and need
+// nothing. The keys of the node are added to the bloom filter either way.
+func reindexNode(store *Store, id uint32) error {
+	if len(store.indexes) == 0 && store.textindex == nil && store.typeindex == nil && len(store.meta.Indexes) == 0 {
+		return nil
+	}
+	node, err := readNode(store.nodestore, id)
+	if err != nil {
+		return err
+	}
+	if store.typeindex != nil {
+		store.typeindex.remove(id)
+		if node.InUse == 1 {
+			store.typeindex.add(id, node.Type)
+		}
+	}
+	var value []byte
+	if node.InUse == 1 && !isBlob(node.Value) {
+		if value, err = nodeValue(store, node); err != nil {
+			return err
+		}
+	}
+	for _, idx := range store.indexes {
+		idx.mu.Lock()
+		idx.remove(id)
+		if value != nil {
+			if key, ok := indexKey(idx.props, value); ok {
+				idx.add(id, key)
+			}
+		}
+		idx.mu.Unlock()
+	}
+	if store.textindex != nil {
+		store.textindex.remove(id)
+		if value != nil {
+			store.textindex.add(id, valueWords(value))
+		}
+	}
+	if value == nil || len(store.meta.Indexes) == 0 {
+		return nil
+	}
+	var keys [][]byte
+	for _, def := range store.meta.Indexes {
+		if key, ok := indexKey(def.Properties, value); ok {
+			keys = append(keys, indexedKey(def.Properties, key))
+		}
+	}
+	return bloomAdd(store, keys...)
+}
+
+// parseProps parses a comma separated list of property names
+func parseProps(s string) ([]string, error) {
+	var props []string
+	for _, prop := range strings.Split(s, ",") {
+		prop = strings.TrimSpace(prop)
+		if prop == "" {
+			return nil, fmt.Errorf("invalid property list %q", s)
+		}
+		props = append(props, prop)
+	}
+	return props, nil
+}
+
+// createIndex adds an index over props to the store and starts building
+// it in the background. find ignores the index until it is complete.
+func createIndex(store *Store, props []string) error {
+	name := strings.Join(props, ",")
+	for _, def := range store.meta.Indexes {
+		if strings.Join(def.Properties, ",") == name {
+			return fmt.Errorf("store %s already has an index on %s", store.name, name)
+		}
+	}
+	idxs, err := loadIndexes(store)
+	if err != nil {
+		return err
+	}
+	idx := newPropIndex(props)
+	if err := startBuild(store, idx); err != nil {
+		return err
+	}
+
+	meta := *store.meta
+	meta.Indexes = append(append([]internal.IndexDef{}, meta.Indexes...), internal.IndexDef{Properties: props})
+	if err := writeMeta(store.name, &meta); err != nil {
+		return err
+	}
+	*store.meta = meta
+	store.indexes = append(idxs, idx)
+	return nil
+}
+
+// dropIndex removes the index over props from the store
+func dropIndex(store *Store, props []string) error {
+	name := strings.Join(props, ",")
+	meta := *store.meta
+	meta.Indexes = nil
+	for _, def := range store.meta.Indexes {
+		if strings.Join(def.Properties, ",") != name {
+			meta.Indexes = append(meta.Indexes, def)
+		}
+	}
+	if len(meta.Indexes) == len(store.meta.Indexes) {
+		return fmt.Errorf("store %s has no index on %s", store.name, name)
+	}
+	if err := writeMeta(store.name, &meta); err != nil {
+		return err
+	}
+	*store.meta = meta
+	var idxs []*propIndex
+	for _, idx := range store.indexes {
+		if idx.name() != name {
+			idxs = append(idxs, idx)
+		}
+	}
+	store.indexes = idxs
+	return nil
+}
+
+// reindexStore throws away every index of the store and rebuilds them from
+// the data: property indexes in the background, the UUID, position,
+// full-text and type indexes on next use, and the bloom filter once the
+// property indexes are done
+func reindexStore(store *Store) error {
+	if building(store) {
+		return fmt.Errorf("store %s is already building an index", store.name)
+	}
+	store.uuidindex, store.geoindex, store.textindex, store.typeindex = nil, nil, nil, nil
+	if store.bloom != nil {
+		store.bloom.f.Close()
+		store.bloom = nil
+	}
+	if err := os.Remove(store.name + "_bloom.db"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	idxs := []*propIndex{}
+	for _, def := range store.meta.Indexes {
+		idx := newPropIndex(def.Properties)
+		if err := startBuild(store, idx); err != nil {
+			return err
+		}
+		idxs = append(idxs, idx)
+	}
+	store.indexes = idxs
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// ioStats counts the nodestore I/O of one store: ReadAt and WriteAt calls,
+// each a system call, the bytes they move, and how often the node cache
+// and the find cache answered. Background index builds read nodes too,
+// hence the atomics. A nil *ioStats counts nothing.
+type ioStats struct {
+	reads, writes           atomic.Int64
+	bytesRead, bytesWritten atomic.Int64
+	cacheHits, cacheMisses  atomic.Int64
+	findHits, findMisses    atomic.Int64
+}
+
+// ioCounts is a copy of the counters of an ioStats
+type ioCounts struct {
+	reads, writes           int64
+	bytesRead, bytesWritten int64
+	cacheHits, cacheMisses  int64
+	findHits, findMisses    int64
+}
+
+// storeIO holds the counters of every store by name, so they survive the
+// store being closed and reopened
+var storeIO = struct {
+	mu    sync.Mutex
+	stats map[string]*ioStats
+}{stats: make(map[string]*ioStats)}
+
+// ioStatsFor returns the counters of the named store
+func ioStatsFor(name string) *ioStats {
+	storeIO.mu.Lock()
+	defer storeIO.mu.Unlock()
+	s, ok := storeIO.stats[name]
+	if !ok {
+		s = &ioStats{}
+		storeIO.stats[name] = s
+	}
+	return s
+}
+
+func (s *ioStats) read(n int) {
+	if s != nil {
+		s.reads.Add(1)
+		s.bytesRead.Add(int64(n))
+	}
+}
+
+func (s *ioStats) wrote(n int) {
+	if s != nil {
+		s.writes.Add(1)
+		s.bytesWritten.Add(int64(n))
+	}
+}
+
+// cached counts a lookup in the node cache, or in the find cache if find
+// is set
+func (s *ioStats) cached(hit bool, find bool) {
+	if s == nil {
+		return
+	}
+	switch {
+	case find && hit:
+		s.findHits.Add(1)
+	case find:
+		s.findMisses.Add(1)
+	case hit:
+		s.cacheHits.Add(1)
+	default:
+		s.cacheMisses.Add(1)
+	}
+}
+
+func (s *ioStats) counts() ioCounts {
+	return ioCounts{
+		s.reads.Load(), s.writes.Load(),
+		s.bytesRead.Load(), s.bytesWritten.Load(),
+		s.cacheHits.Load(), s.cacheMisses.Load(),
+		s.findHits.Load(), s.findMisses.Load(),
+	}
+}
+
+func (c ioCounts) add(o ioCounts, sign int64) ioCounts {
+	return ioCounts{
+		c.reads + sign*o.reads, c.writes + sign*o.writes,
+		c.bytesRead + sign*o.bytesRead, c.bytesWritten + sign*o.bytesWritten,
+		c.cacheHits + sign*o.cacheHits, c.cacheMisses + sign*o.cacheMisses,
+		c.findHits + sign*o.findHits, c.findMisses + sign*o.findMisses,
+	}
+}
+
+// totalIO sums the counters of all stores
+func totalIO() ioCounts {
+	storeIO.mu.Lock()
+	defer storeIO.mu.Unlock()
+	var total ioCounts
+	for _, s := range storeIO.stats {
+		total = total.add(s.counts(), 1)
+	}
+	return total
+}
+
+// opIO sums the I/O of each REPL command over the session
+var opIO = make(map[string]ioCounts)
+
+// countOp adds the I/O done since before to command
+func countOp(command string, before ioCounts) {
+	opIO[command] = opIO[command].add(totalIO().add(before, -1), 1)
+}
+
+// ioColumns are the columns of the I/O results after the name column
+var ioColumns = []column{
+	{"Reads", "reads"}, {"Bytes Read", "bytes_read"},
+	{"Writes", "writes"}, {"Bytes Written", "bytes_written"},
+	{"Cache Hits", "cache_hits"}, {"Cache Misses", "cache_misses"},
+	{"Find Hits", "find_hits"}, {"Find Misses", "find_misses"},
+}
+
+func (c ioCounts) fields() []field {
+	return []field{
+		numField(c.reads), numField(c.bytesRead),
+		numField(c.writes), numField(c.bytesWritten),
+		numField(c.cacheHits), numField(c.cacheMisses),
+		numField(c.findHits), numField(c.findMisses),
+	}
+}
+
+// ioResults returns the I/O of the named store, or of every store and then
+// of every command run this session if name is empty
+func ioResults(name string) *results {
+	r := &results{columns: append([]column{{"Kind", "kind"}, {"Name", "name"}}, ioColumns...)}
+	storeIO.mu.Lock()
+	names := slices.Sorted(maps.Keys(storeIO.stats))
+	storeIO.mu.Unlock()
+	for _, n := range names {
+		if name == "" || n == name {
+			r.add(append([]field{textField("store"), textField(n)}, ioStatsFor(n).counts().fields()...)...)
+		}
+	}
+	if name != "" {
+		return r
+	}
+	for _, op := range slices.Sorted(maps.Keys(opIO)) {
+		r.add(append([]field{textField("command"), textField(op)}, opIO[op].fields()...)...)
+	}
+	return r
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxRows and maxScanTime are the ceilings on the rows a query returns and
+// the time it may scan for, 0 for none. Store defaults and per-query
+// limits above them are cut down to them. They are set by the -max-rows
+// and -max-scan-time flags.
+var (
+	maxRows     int
+	maxScanTime time.Duration
+)
+
+// ErrScanTimeout is returned by a query that scans for longer than its
+// time limit
+var ErrScanTimeout = errors.New("scan time limit exceeded")
+
+// queryLimits are the row and scan time limits of one query, 0 for none
+type queryLimits struct {
+	rows     int
+	scanTime time.Duration
+}
+
+// capLimit cuts v down to ceiling. No limit counts as above any ceiling.
+func capLimit[T int | time.Duration](v, ceiling T) T {
+	if ceiling > 0 && (v == 0 || v > ceiling) {
+		return ceiling
+	}
+	return v
+}
+
+// limitsFor returns the limits of a query on store: those given with the
+// query, else the defaults of the store, capped by the ceilings
+func limitsFor(store *Store, query queryLimits) queryLimits {
+	l := query
+	if l.rows == 0 {
+		l.rows = store.meta.MaxRows
+	}
+	if l.scanTime == 0 {
+		l.scanTime = time.Duration(store.meta.MaxScanMillis) * time.Millisecond
+	}
+	return queryLimits{capLimit(l.rows, maxRows), capLimit(l.scanTime, maxScanTime)}
+}
+
+// startScan gives the command a scan deadline d from now, 0 for none
+func (b *memBudget) startScan(d time.Duration) {
+	if b != nil && d > 0 {
+		b.deadline = time.Now().Add(d)
+	}
+}
+
+// cutLimits takes trailing LIMIT n and TIMEOUT d clauses off a query, in
+// either order
+func cutLimits(query string) (string, queryLimits, error) {
+	var l queryLimits
+	for {
+		rest := strings.TrimRight(query, " \t")
+		i := strings.LastIndexAny(rest, " \t")
+		if i < 0 {
+			return query, l, nil
+		}
+		arg := rest[i+1:]
+		head := strings.TrimRight(rest[:i], " \t")
+		j := strings.LastIndexAny(head, " \t")
+		switch strings.ToUpper(head[j+1:]) {
+		case "LIMIT":
+			n, err := strconv.Atoi(arg)
+			if err != nil || n <= 0 {
+				return "", l, fmt.Errorf("invalid LIMIT %q, expected a positive number", arg)
+			}
+			l.rows = n
+		case "TIMEOUT":
+			d, err := time.ParseDuration(arg)
+			if err != nil || d <= 0 {
+				return "", l, fmt.Errorf("invalid TIMEOUT %q, expected a duration such as 2s", arg)
+			}
+			l.scanTime = d
+		default:
+			return query, l, nil
+		}
+		query = head[:j+1]
+	}
+}
+
+// full reports whether n rows are as many as l lets a query return
+func (l queryLimits) full(n int) bool {
+	return l.rows > 0 && n >= l.rows
+}
+
+func comLimits(store *Store, rows int, scanTime time.Duration) error {
+	// Record the default query limits in the store metadata
+	if dryRun {
+		return ErrDryRun
+	}
+	if rows < 0 || scanTime < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+	meta := *store.meta
+	meta.MaxRows = rows
+	meta.MaxScanMillis = scanTime.Milliseconds()
+	if err := writeMeta(store.name, &meta); err != nil {
+		return err
+	}
+	*store.meta = meta
+	return nil
+}
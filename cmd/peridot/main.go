@@ -2,146 +2,127 @@
 package main
 
 import (
-	"encoding/binary"
-	"encoding/json"
+	"bufio"
+	"encoding/base64"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/nabeeladzan/peridot/internal"
+	"github.com/nabeeladzan/peridot/internal/alloc"
+	"github.com/nabeeladzan/peridot/internal/codec"
 )
 
-const nodeSize = 72 // 4 (ID) + 1 (InUse) + 1 (Padding) + 2 (Padding) + 64 (Value)
-
-// getFree reads the head of the free list from freestore
+// getFree reads the head of the free list from freestore, ^uint32(0) when
+// the list is empty. Stores are created with that head written out; an
+// empty freestore is from an older store and also means an empty list.
 func getFree(f *os.File) (uint32, error) {
-	buf := make([]byte, 4)
-	_, err := f.ReadAt(buf, 0)
-	if err != nil {
-		// If free list is empty, return ^uint32(0)
-		return ^uint32(0), nil
-	}
-	return binary.LittleEndian.Uint32(buf), nil
+	return alloc.ReadHead(f)
 }
 
 // setFree writes the head of the free list to freestore
 func setFree(f *os.File, id uint32) error {
-	buf := make([]byte, 4)
-	binary.LittleEndian.PutUint32(buf, id)
-	_, err := f.WriteAt(buf, 0)
-	return err
+	return alloc.WriteHead(f, id)
 }
 
-// writeNode writes a new node, reusing free slot if available
-func writeNode(nodestore, freestore *os.File, value string) error {
-	freeID, err := getFree(freestore)
-	if err != nil {
-		return err
-	}
-
-	node := internal.Node{InUse: 1}
-
-	// Encode value into fixed 64-byte field
-	jsonVal, _ := json.Marshal(value)
-	var fixed [64]byte
-	copy(fixed[:], jsonVal)
-	node.Value = fixed
+// nodeSlots is the allocator of the slots of a nodestore
+func nodeSlots(nodestore *nodeFile, freestore *os.File) *alloc.SlotAllocator {
+	size := int64(nodestore.codec.RecordSize())
+	return &alloc.SlotAllocator{Records: nodestore, Head: freestore, RecordSize: size, First: nodestore.offset(0)}
+}
 
-	var offset int64
-	if freeID != ^uint32(0) {
-		// Reuse free node
-		offset = int64(freeID) * nodeSize
-		node.ID = freeID
+// writeNode writes a new node, reusing free slot if available, and returns its ID
+func writeNode(nodestore *nodeFile, freestore *os.File, value [64]byte) (uint32, error) {
+	node := internal.Node{InUse: 1, Value: value}
+	return putNode(nodestore, freestore, node)
+}
 
-		// Read the reused node to get its next free ID
-		buf := make([]byte, nodeSize)
-		_, err := nodestore.ReadAt(buf, offset)
-		if err != nil {
-			return err
-		}
-		nextFreeID := binary.LittleEndian.Uint32(buf[8:12]) // first 4 bytes of Value
-		// Set new head of free list
-		err = setFree(freestore, nextFreeID)
+// putNode stores node in a free slot, or at the end of the nodestore if the
+// free list is empty, and returns the ID it was given
+func putNode(nodestore *nodeFile, freestore *os.File, node internal.Node) (uint32, error) {
+	node.Gen = 0
+	id, _, err := nodeSlots(nodestore, freestore).Alloc(func(id uint32) (uint32, error) {
+		// A free node links to the next free ID
+		free, err := readNode(nodestore, id)
 		if err != nil {
-			return err
+			return 0, err
 		}
-	} else {
-		// Append to end
-		fi, err := nodestore.Stat()
-		if err != nil {
-			return err
+		if free.InUse == 1 {
+			return 0, fmt.Errorf("%w: free list reaches node %d, which is in use", codec.ErrCorrupt, id)
 		}
-		offset = fi.Size()
-		node.ID = uint32(offset / nodeSize)
+		node.Gen = free.Gen // already bumped by deleteNode
+		return codec.Order.Uint32(free.Value[0:4]), nil
+	})
+	if err != nil {
+		return 0, err
 	}
+	node.ID = id
 
 	// Serialize node
-	buf := make([]byte, nodeSize)
-	binary.LittleEndian.PutUint32(buf[0:], node.ID)
-	buf[4] = node.InUse
-	copy(buf[8:], node.Value[:])
+	buf := make([]byte, nodestore.codec.RecordSize())
+	if err := nodestore.codec.EncodeNode(buf, node); err != nil {
+		return 0, err
+	}
 
-	_, err = nodestore.WriteAt(buf, offset)
-	return err
+	_, err = nodestore.WriteAt(buf, nodestore.offset(id))
+	if err != nil {
+		return 0, err
+	}
+	return node.ID, nil
 }
 
 // deleteNode marks a node as free and adds it to the free list
-func deleteNode(nodestore, freestore *os.File, id uint32) error {
-	offset := int64(id) * nodeSize
-
-	// Get current free list head
-	currentHead, err := getFree(freestore)
-	if err != nil {
-		return err
-	}
+func deleteNode(nodestore *nodeFile, freestore *os.File, id uint32) error {
+	return nodeSlots(nodestore, freestore).Free(id, func(next uint32) error {
+		// The generation outlives the node, so handles to it go stale
+		old, err := readNode(nodestore, id)
+		if err != nil || old.InUse != 1 {
+			return fmt.Errorf("node %d not found", id)
+		}
 
-	// Prepare a blank node with InUse=0 and value containing next free ID
-	var node internal.Node
-	node.ID = id
-	node.InUse = 0
-	binary.LittleEndian.PutUint32(node.Value[0:], currentHead) // link to next free
+		// Prepare a blank node with InUse=0 and value containing next free ID
+		var node internal.Node
+		node.ID = id
+		node.InUse = 0
+		node.Gen = old.Gen + 1
+		codec.Order.PutUint32(node.Value[0:], next) // link to next free
 
-	// Serialize
-	buf := make([]byte, nodeSize)
-	binary.LittleEndian.PutUint32(buf[0:], node.ID)
-	buf[4] = node.InUse
-	copy(buf[8:], node.Value[:])
+		// Serialize
+		buf := make([]byte, nodestore.codec.RecordSize())
+		if err := nodestore.codec.EncodeNode(buf, node); err != nil {
+			return err
+		}
 
-	// Write node
-	_, err = nodestore.WriteAt(buf, offset)
-	if err != nil {
+		// Write node
+		_, err = nodestore.WriteAt(buf, nodestore.offset(id))
 		return err
-	}
-
-	// Set new free list head
-	return setFree(freestore, id)
-}
-
-// readNode reads a node by its ID from the file
-func readNode(f *os.File, id uint32) (internal.Node, error) {
-	offset := int64(id) * nodeSize
-	buf := make([]byte, nodeSize)
-	_, err := f.ReadAt(buf, offset)
-	if err != nil {
-		return internal.Node{}, err
-	}
-
-	node := internal.Node{
-		ID:    binary.LittleEndian.Uint32(buf[0:4]),
-		InUse: buf[4],
-	}
-	copy(node.Value[:], buf[8:72])
-	return node, nil
+	})
 }
 
 // openStore opens a file with the given name
-func openStore(name string) (*os.File, *os.File, error) {
+func openStore(name string) (*nodeFile, *os.File, error) {
 	// if _free return
 	// return the file handles
-	nodestore, err := os.OpenFile(name+".db", os.O_RDWR, 0644)
+	if err := validateStoreName(name); err != nil {
+		return nil, nil, err
+	}
+	f, err := os.OpenFile(name+".db", os.O_RDWR, 0644)
 	if err != nil {
 		return nil, nil, fmt.Errorf("file %s does not exist", name)
 	}
+	nodestore, err := openNodeFile(name, f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
 
 	freestore, err := os.OpenFile(name+"_free.db", os.O_RDWR, 0644)
 	if err != nil {
@@ -151,285 +132,2823 @@ func openStore(name string) (*os.File, *os.File, error) {
 	return nodestore, freestore, nil
 }
 
-func createStore(name string) (*os.File, *os.File, error) {
-	// Create the file handles
-	nodestore, err := os.OpenFile(name+".db", os.O_CREATE|os.O_RDWR, 0644)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create file %s", name)
+func createStore(name string, c codec.Codec) (*nodeFile, *os.File, error) {
+	// Build the files under temporary names and rename them into place,
+	// the nodestore last, so a crash never leaves a half-made store behind
+	if err := makeStoreDir(name); err != nil {
+		return nil, nil, err
+	}
+	if _, err := os.Stat(name + ".db"); err == nil {
+		return nil, nil, fmt.Errorf("store %s already exists", name)
 	}
 
-	freestore, err := os.OpenFile(name+"_free.db", os.O_CREATE|os.O_RDWR, 0644)
+	tmp, err := os.Create(name + ".db.tmp")
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create file %s_free", name+"_free")
+		return nil, nil, fmt.Errorf("failed to create file %s", name+".db.tmp")
 	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	freetmp, err := os.Create(name + "_free.db.tmp")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create file %s", name+"_free.db.tmp")
+	}
+	defer os.Remove(freetmp.Name())
+	defer freetmp.Close()
 
-	return nodestore, freestore, nil
-}
-
-func readStore(f *os.File) ([]internal.Node, error) {
-	// Read all nodes from the file
-	var nodes []internal.Node
-	buf := make([]byte, nodeSize)
-	for i := 0; ; i++ {
-		_, err := f.ReadAt(buf, int64(i)*nodeSize)
-		if err != nil {
-			break // EOF or error
+	if err := writeHeader(tmp, c); err != nil {
+		return nil, nil, err
+	}
+	if err := setFree(freetmp, ^uint32(0)); err != nil {
+		return nil, nil, err
+	}
+	for _, f := range []*os.File{tmp, freetmp} {
+		if err := f.Sync(); err != nil {
+			return nil, nil, err
 		}
-		node := internal.Node{
-			ID:    binary.LittleEndian.Uint32(buf[0:4]),
-			InUse: buf[4],
+		if err := f.Close(); err != nil {
+			return nil, nil, err
 		}
-		copy(node.Value[:], buf[8:72])
-		nodes = append(nodes, node)
 	}
-	return nodes, nil
-}
-
-// command list
-func comCreate(storename string) (*os.File, *os.File, error) {
-	nodestore, freestore, err := createStore(storename)
-	if err != nil {
+	if err := replaceFile(freetmp.Name(), name+"_free.db"); err != nil {
 		return nil, nil, err
 	}
-	return nodestore, freestore, nil
+	if err := replaceFile(tmp.Name(), name+".db"); err != nil {
+		return nil, nil, err
+	}
+	return openStore(name)
 }
 
-func comOpen(storename string) (*os.File, *os.File, error) {
-	nodestore, freestore, err := openStore(storename)
+func readStore(f *nodeFile) ([]internal.Node, error) {
+	// Read all nodes from the file
+	return scanStore(f, nil)
+}
+
+// cloneStore copies the nodes of src that satisfy keep into a new store
+// named name. Node IDs are preserved; slots that are free or filtered out
+// are written as free slots and chained into the new free list. The files
+// are built under temporary names and renamed into place, so a failed
+// clone never leaves a half-written store behind. The metadata is copied
+// as well. The clone is written with codec c, which need not be the
+// codec of src.
+func cloneStore(src *Store, name string, c codec.Codec, keep func(internal.Node) bool) error {
+	if err := makeStoreDir(name); err != nil {
+		return err
+	}
+	if _, err := os.Stat(name + ".db"); err == nil {
+		return fmt.Errorf("store %s already exists", name)
+	}
+
+	nodes, err := readStore(src.nodestore)
 	if err != nil {
-		return nil, nil, err
+		return err
 	}
-	return nodestore, freestore, nil
-}
 
-func comClose(storename string) error {
-	nodestore, err := os.OpenFile(storename, os.O_RDWR, 0644)
+	tmp, err := os.Create(name + ".db.tmp")
 	if err != nil {
-		return fmt.Errorf("file %s does not exist", storename)
+		return fmt.Errorf("failed to create file %s", name+".db.tmp")
 	}
-	defer nodestore.Close()
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	nodetmp := &nodeFile{File: tmp, codec: c}
 
-	freestore, err := os.OpenFile(storename+"_free", os.O_RDWR, 0644)
+	freetmp, err := os.Create(name + "_free.db.tmp")
 	if err != nil {
-		return fmt.Errorf("file %s_free does not exist", storename+"_free")
+		return fmt.Errorf("failed to create file %s", name+"_free.db.tmp")
 	}
-	defer freestore.Close()
+	defer os.Remove(freetmp.Name())
+	defer freetmp.Close()
 
-	if err := nodestore.Close(); err != nil {
+	if err := writeHeader(tmp, c); err != nil {
 		return err
 	}
-	if err := freestore.Close(); err != nil {
+
+	// overflowed values are copied into a fresh overflow store, which
+	// drops the space of values that were since replaced or deleted
+	var ovftmp *os.File
+	head := ^uint32(0)
+	var kept []uint32
+	buf := make([]byte, c.RecordSize())
+	for i, node := range nodes {
+		id := uint32(i)
+		if node.InUse == 1 && keep(node) {
+			kept = append(kept, id)
+			if isOverflow(node.Value) {
+				if ovftmp == nil {
+					if ovftmp, err = os.Create(name + "_ovf.db.tmp"); err != nil {
+						return fmt.Errorf("failed to create file %s", name+"_ovf.db.tmp")
+					}
+					defer os.Remove(ovftmp.Name())
+					defer ovftmp.Close()
+				}
+				if node.Value, err = moveOverflow(src, ovftmp, node.Value); err != nil {
+					return err
+				}
+			}
+		} else {
+			// a filtered out node counts as deleted in the clone
+			free := internal.Node{ID: id, Gen: node.Gen}
+			if node.InUse == 1 {
+				free.Gen++
+			}
+			if node.InUse != 1 && reserved(src.meta, id) {
+				// still reserved, kept off the free list
+				codec.Order.PutUint32(free.Value[0:], ^uint32(0))
+			} else {
+				// link to next free
+				codec.Order.PutUint32(free.Value[0:], head)
+				head = id
+			}
+			node = free
+		}
+		if err := c.EncodeNode(buf, node); err != nil {
+			return err
+		}
+		if _, err := nodetmp.WriteAt(buf, nodetmp.offset(id)); err != nil {
+			return err
+		}
+	}
+	if err := setFree(freetmp, head); err != nil {
 		return err
 	}
-	return nil
-}
 
-func comInsert(store *Store, value string) error {
-	// Insert a new node into the store
-	err := writeNode(store.nodestore, store.freestore, value)
-	if err != nil {
+	if err := nodetmp.Sync(); err != nil {
 		return err
 	}
-	return nil
-}
-
-func comDelete(store *Store, id uint32) error {
-	// Delete a node from the store
-	err := deleteNode(store.nodestore, store.freestore, id)
-	if err != nil {
+	if err := freetmp.Sync(); err != nil {
 		return err
 	}
-	return nil
-}
+	if ovftmp != nil {
+		if err := ovftmp.Sync(); err != nil {
+			return err
+		}
+	}
+	// Windows cannot rename files that are open
+	for _, f := range []*os.File{tmp, freetmp, ovftmp} {
+		if f == nil {
+			continue
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	if src.meta.VectorDim != 0 {
+		defer os.Remove(name + "_vec.db.tmp")
+		if err := cloneVectors(src, name+"_vec.db.tmp", kept); err != nil {
+			return err
+		}
+	}
+	if src.meta.UUIDs {
+		defer os.Remove(name + "_uuid.db.tmp")
+		if err := cloneUUIDs(src, name+"_uuid.db.tmp", kept); err != nil {
+			return err
+		}
+	}
+	if hasPoints(src) {
+		defer os.Remove(name + "_geo.db.tmp")
+		if err := clonePoints(src, name+"_geo.db.tmp", kept); err != nil {
+			return err
+		}
+	}
 
-func comReadAll(store *Store) error {
-	// Read all nodes from the store
-	nodes, err := readStore(store.nodestore)
-	if err != nil {
+	// The nodestore is renamed last: a store only exists once its .db file does
+	if err := writeMeta(name, src.meta); err != nil {
 		return err
 	}
-	for _, node := range nodes {
-		if node.InUse == 1 {
-			fmt.Printf("Node ID: %d, Value: %s\n", node.ID, string(node.Value[:]))
+	if src.meta.VectorDim != 0 {
+		if err := replaceFile(name+"_vec.db.tmp", name+"_vec.db"); err != nil {
+			return err
 		}
 	}
-	return nil
-}
-
-type Store struct {
-	name string
-	// file pointer to the node store
-	nodestore *os.File
-	// file pointer to the free store
-	freestore *os.File
-}
-
-func findStore(stores []Store, name string) (*Store, error) {
-	for _, store := range stores {
-		if store.name == name {
-			return &store, nil
+	if hasPoints(src) {
+		if err := replaceFile(name+"_geo.db.tmp", name+"_geo.db"); err != nil {
+			return err
+		}
+	}
+	if src.meta.UUIDs {
+		if err := replaceFile(name+"_uuid.db.tmp", name+"_uuid.db"); err != nil {
+			return err
 		}
 	}
-	return nil, fmt.Errorf("store %s not found", name)
+	if ovftmp != nil {
+		if err := replaceFile(ovftmp.Name(), name+"_ovf.db"); err != nil {
+			return err
+		}
+	}
+	if err := replaceFile(freetmp.Name(), name+"_free.db"); err != nil {
+		return err
+	}
+	return replaceFile(nodetmp.Name(), name+".db")
 }
 
-func main() {
-	fmt.Println("Peridot GraphDB Server")
-
-	// array of store
-	var stores []Store
+// merge policies decide what happens to a source node whose value is
+// already present in the destination
+const (
+	mergeKeep = "keep" // insert it anyway
+	mergeSkip = "skip" // leave it out
+	mergeFail = "fail" // abort the merge before anything is written
+)
 
-	// detect .db files in the current directory
-	files, err := os.ReadDir(".")
-	if err != nil {
-		fmt.Println("Error reading directory:", err)
-		return
+// mergeStores inserts every in-use node of srcs into dst under newly
+// allocated IDs, along with their vectors and positions. A node keeps its
+// UUID if the destination assigns UUIDs and does not hold it already. It returns how many nodes were
+// merged and how many were skipped by the policy.
+func mergeStores(dst *Store, srcs []*Store, policy string) (int, int, error) {
+	if policy != mergeKeep && policy != mergeSkip && policy != mergeFail {
+		return 0, 0, fmt.Errorf("unknown merge policy %s", policy)
 	}
-
-	for _, file := range files {
-		if file.IsDir() {
+	dim := dst.meta.VectorDim
+	for _, src := range srcs {
+		if src.meta.VectorDim == 0 {
 			continue
 		}
-		if len(file.Name()) < 3 {
-			continue
+		if dim != 0 && src.meta.VectorDim != dim {
+			return 0, 0, fmt.Errorf("store %s holds vectors of dimension %d, %s of dimension %d", src.name, src.meta.VectorDim, dst.name, dim)
 		}
-		if strings.HasSuffix(file.Name(), "_free.db") {
+		dim = src.meta.VectorDim
+	}
+
+	// values already present in the destination
+	existing, err := readStore(dst.nodestore)
+	if err != nil {
+		return 0, 0, err
+	}
+	seen := make(map[string]bool)
+	for _, node := range existing {
+		if node.InUse != 1 {
 			continue
 		}
-
-		if file.Name()[len(file.Name())-3:] == ".db" {
-			// comOpen the store
-			// remove the .db extension
-			nodestore, freestore, err := comOpen(file.Name()[:len(file.Name())-3])
-			if err != nil {
-				fmt.Println("Error opening store:", err)
-				continue
-			}
-			// append to the stores array
-			stores = append(stores, Store{
-				name:      file.Name()[:len(file.Name())-3],
-				nodestore: nodestore,
-				freestore: freestore,
-			})
+		value, err := nodeValue(dst, node)
+		if err != nil {
+			return 0, 0, err
 		}
+		seen[string(value)] = true
 	}
 
-	// CLI for interacting with the database
-	for {
-		var command string
-		// Peridot> prompt
-		fmt.Print("Peridot> ")
-		fmt.Scanln(&command)
-		fmt.Println()
-		switch command {
-		case "list":
-			// list all stores
-			fmt.Println("Stores:")
-			for _, store := range stores {
-				fmt.Println(store.name)
-			}
-		case "create":
-			// create a new store
-			var storename string
-			fmt.Print("Enter store name: ")
-			fmt.Scanln(&storename)
-			nodestore, freestore, err := comCreate(storename)
-			if err != nil {
-				fmt.Println("Error creating store:", err)
+	type pendingNode struct {
+		src  *Store
+		node internal.Node
+	}
+	var pending []pendingNode
+	skipped := 0
+	for _, src := range srcs {
+		nodes, err := readStore(src.nodestore)
+		if err != nil {
+			return 0, 0, err
+		}
+		for _, node := range nodes {
+			if node.InUse != 1 {
 				continue
 			}
-			// append to the stores array
-			stores = append(stores, Store{
-				name:      storename,
-				nodestore: nodestore,
-				freestore: freestore,
-			})
-		case "insert":
-			// insert a new node into the store
-			var storename, value string
-			fmt.Print("Enter store name: ")
-			fmt.Scanln(&storename)
-			fmt.Print("Enter value: ")
-			fmt.Scanln(&value)
-			// find the store in the stores array
-			store, err := findStore(stores, storename)
+			value, err := nodeValue(src, node)
 			if err != nil {
-				fmt.Println("Error finding store:", err)
-				continue
+				return 0, 0, err
 			}
-			// insert the value into the store
-			err = comInsert(store, value)
-			if err != nil {
-				fmt.Println("Error inserting value:", err)
-				continue
+			if seen[string(value)] {
+				switch policy {
+				case mergeFail:
+					return 0, 0, fmt.Errorf("node %d of store %s conflicts with an existing value", node.ID, src.name)
+				case mergeSkip:
+					skipped++
+					continue
+				}
 			}
-			fmt.Println("Inserted value:", value)
-		case "delete":
-			// delete a node from the store
-			var storename string
-			var id uint32
-			fmt.Print("Enter store name: ")
-			fmt.Scanln(&storename)
-			fmt.Print("Enter node ID: ")
-			fmt.Scanln(&id)
-			// find the store in the stores array
-			store, err := findStore(stores, storename)
+			seen[string(value)] = true
+			pending = append(pending, pendingNode{src, node})
+		}
+	}
+
+	for i, p := range pending {
+		node := p.node
+		var payload int64
+		if isOverflow(node.Value) {
+			payload = 4 + blobSize(node.Value)
+		}
+		if err := checkQuota(dst, payload); err != nil {
+			return i, skipped, err
+		}
+		if isOverflow(node.Value) {
+			// the reference points into the overflow store of the source
+			f, err := overflowFile(dst)
 			if err != nil {
-				fmt.Println("Error finding store:", err)
-				continue
+				return i, skipped, err
 			}
-			// delete the node from the store
-			err = comDelete(store, id)
-			if err != nil {
-				fmt.Println("Error deleting node:", err)
-				continue
+			if node.Value, err = moveOverflow(p.src, f, node.Value); err != nil {
+				return i, skipped, err
 			}
-			fmt.Println("Deleted node ID:", id)
-		case "read":
-			// read all nodes from the store
-			var storename string
-			fmt.Print("Enter store name: ")
-			fmt.Scanln(&storename)
-			// find the store in the stores array
-			store, err := findStore(stores, storename)
-			if err != nil {
-				fmt.Println("Error finding store:", err)
-				continue
+		}
+		id, err := putNode(dst.nodestore, dst.freestore, node)
+		if err != nil {
+			return i, skipped, err
+		}
+		if err := reindexNode(dst, id); err != nil {
+			return i, skipped, err
+		}
+		if err := notify(dst, "insert", id); err != nil {
+			return i, skipped, err
+		}
+		if dst.meta.UUIDs {
+			if err := mergeUUID(dst, p.src, p.node.ID, id); err != nil {
+				return i, skipped, err
 			}
-			// read all nodes from the store
-			err = comReadAll(store)
+		}
+		if hasPoints(p.src) {
+			idx, err := loadGeoIndex(p.src)
 			if err != nil {
-				fmt.Println("Error reading nodes:", err)
-				continue
+				return i, skipped, err
 			}
-		case "version":
-			// print the version of the server
-			fmt.Println("\nPeridot GraphDB Server v0.1")
-			fmt.Println("Copyright (c) 2024 Muhammad Nabeel Adzan")
-			fmt.Println("All rights reserved.")
-			fmt.Println("This is free software; you are free to use it under the terms of the MIT License.")
-			fmt.Println("This software is provided 'as is' without warranty of any kind.")
-			fmt.Println("See the LICENSE file for more details.\n")
-		case "help":
-			// print the help message
-			fmt.Println("Commands:")
-			fmt.Println("list - list all stores")
-			fmt.Println("create - create a new store")
-			fmt.Println("insert - insert a new node into the store")
-			fmt.Println("delete - delete a node from the store")
-			fmt.Println("read - read all nodes from the store")
-			fmt.Println("version - print the version of the server")
-			fmt.Println("help - print this help message")
-			fmt.Println("exit - close all stores and exit")
-		case "exit":
-			// close all stores and exit
-			for _, store := range stores {
-				err := comClose(store.name)
-				if err != nil {
-					fmt.Println("Error closing store:", err)
-					continue
+			if pt, ok := idx.points[p.node.ID]; ok {
+				if err := setPoint(dst, id, pt[0], pt[1]); err != nil {
+					return i, skipped, err
 				}
 			}
+		}
+		if p.src.meta.VectorDim == 0 {
+			continue
+		}
+		f, err := vectorFile(p.src)
+		if err != nil {
+			return i, skipped, err
+		}
+		vec, ok, err := readVector(f, p.src.meta.VectorDim, p.node.ID)
+		if err != nil {
+			return i, skipped, err
+		}
+		if ok {
+			if err := setVector(dst, id, vec); err != nil {
+				return i, skipped, err
+			}
+		}
+	}
+	return len(pending), skipped, nil
+}
+
+// command list
+func comCreate(storename string, codecname string) (*nodeFile, *os.File, error) {
+	if dryRun {
+		return nil, nil, ErrDryRun
+	}
+	c, err := codec.ByName(codecname)
+	if err != nil {
+		return nil, nil, err
+	}
+	nodestore, freestore, err := createStore(storename, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nodestore, freestore, nil
+}
+
+func comOpen(storename string) (*nodeFile, *os.File, error) {
+	nodestore, freestore, err := openStore(storename)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nodestore, freestore, nil
+}
+
+func comClose(store *Store) error {
+	// Close the files of a store, marking it closed cleanly. It is opened
+	// again on next use.
+	if store.cold || store.closed {
+		return nil
+	}
+	err := closeFiles(store)
+	store.closed = true
+	return err
+}
+
+func comClone(store *Store, dstname string, codecname string, keep func(internal.Node) bool) (*nodeFile, *os.File, error) {
+	// Copy the store into a new one and open it
+	if dryRun {
+		return nil, nil, ErrDryRun
+	}
+	c := store.nodestore.codec
+	if codecname != "" {
+		var err error
+		if c, err = codec.ByName(codecname); err != nil {
+			return nil, nil, err
+		}
+	}
+	err := cloneStore(store, dstname, c, keep)
+	if err != nil {
+		return nil, nil, err
+	}
+	return openStore(dstname)
+}
+
+func comMerge(dst *Store, srcs []*Store, policy string) (int, int, error) {
+	// Import the nodes of every source store into the destination
+	if dryRun {
+		return 0, 0, ErrDryRun
+	}
+	if err := writable(dst); err != nil {
+		return 0, 0, err
+	}
+	for _, src := range srcs {
+		if src.name == dst.name {
+			return 0, 0, fmt.Errorf("cannot merge store %s into itself", dst.name)
+		}
+	}
+	return mergeStores(dst, srcs, policy)
+}
+
+func comArchive(store *Store) error {
+	// Move the store files to the cold directory
+	if dryRun {
+		return ErrDryRun
+	}
+	return archiveStore(store)
+}
+
+func comQuota(store *Store, maxNodes uint32, maxBytes int64) error {
+	// Record the new limits in the store metadata
+	if dryRun {
+		return ErrDryRun
+	}
+	meta := *store.meta
+	meta.MaxNodes = maxNodes
+	meta.MaxBytes = maxBytes
+	if err := writeMeta(store.name, &meta); err != nil {
+		return err
+	}
+	*store.meta = meta
+	return nil
+}
+
+func comInsert(store *Store, value string, key string) (mutation, error) {
+	// Insert a new node into the store, unless key was used to insert it already
+	if err := writable(store); err != nil {
+		return mutation{}, err
+	}
+	request := "insert " + value
+	if h, ok, err := store.idempotency.recall(key, request); ok || err != nil {
+		return mutation{kind: "insert", handle: h, key: key, replayed: ok}, err
+	}
+	data, err := valueData(store, value)
+	if err != nil {
+		return mutation{}, err
+	}
+	if err := checkQuota(store, overflowPayload(data)); err != nil {
+		return mutation{}, err
+	}
+	fixed, err := storeValue(store, data)
+	if err != nil {
+		return mutation{}, err
+	}
+	id, err := writeNode(store.nodestore, store.freestore, fixed)
+	if err != nil {
+		return mutation{}, err
+	}
+	if err := reindexNode(store, id); err != nil {
+		return mutation{}, err
+	}
+	m := mutation{kind: "insert"}
+	if store.meta.UUIDs {
+		u, err := assignUUID(store, id)
+		if err != nil {
+			return mutation{}, err
+		}
+		m.uuid = u.String()
+	}
+	node, err := readNode(store.nodestore, id)
+	if err != nil {
+		return mutation{}, err
+	}
+	m.handle = Handle{id, node.Gen}
+	if m.value, err = showValue(store, node); err != nil {
+		return mutation{}, err
+	}
+	remember(store, key, request, m.handle)
+	return m, notify(store, "insert", id)
+}
+
+func comGet(store *Store, handle string) error {
+	// Read one node, checking the generation if a full handle was given
+	h, checked, err := parseHandle(handle)
+	if err != nil {
+		return err
+	}
+	node, err := getNode(store, h, checked)
+	if err != nil {
+		return err
+	}
+	h.Gen = node.Gen
+	value, err := showValue(store, node)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Node ID: %d, Handle: %s, Value: %s\n", node.ID, h, value)
+	return nil
+}
+
+func comPutBlob(store *Store, blob string) (mutation, error) {
+	// Insert a node holding raw bytes, given as base64
+	if err := writable(store); err != nil {
+		return mutation{}, err
+	}
+	data, err := decodeBlob(blob)
+	if err != nil {
+		return mutation{}, err
+	}
+	if err := checkQuota(store, blobPayload(data)); err != nil {
+		return mutation{}, err
+	}
+	id, err := insertBlob(store, data)
+	if err != nil {
+		return mutation{}, err
+	}
+	if err := reindexNode(store, id); err != nil {
+		return mutation{}, err
+	}
+	m := mutation{kind: "insert"}
+	if store.meta.UUIDs {
+		u, err := assignUUID(store, id)
+		if err != nil {
+			return mutation{}, err
+		}
+		m.uuid = u.String()
+	}
+	node, err := readNode(store.nodestore, id)
+	if err != nil {
+		return mutation{}, err
+	}
+	m.handle = Handle{id, node.Gen}
+	if m.value, err = showValue(store, node); err != nil {
+		return mutation{}, err
+	}
+	return m, notify(store, "insert", id)
+}
+
+func comGetBlob(store *Store, handle string, path string) error {
+	// Stream the blob of a node to a file, or to stdout as base64
+	h, checked, err := parseHandle(handle)
+	if err != nil {
+		return err
+	}
+	node, err := getNode(store, h, checked)
+	if err != nil {
+		return err
+	}
+	r, err := blobReader(store, node)
+	if err != nil {
+		return err
+	}
+	if path == "" {
+		enc := base64.NewEncoder(base64.StdEncoding, os.Stdout)
+		if _, err := io.Copy(enc, r); err != nil {
+			return err
+		}
+		if err := enc.Close(); err != nil {
+			return err
+		}
+		fmt.Println()
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d bytes to %s\n", n, path)
+	return f.Sync()
+}
+
+func comUpdate(store *Store, handle string, value string, key string) (mutation, error) {
+	// Replace the value of one node, checking the generation if a full handle was given,
+	// unless key was used to update it already
+	if err := writable(store); err != nil {
+		return mutation{}, err
+	}
+	request := "update " + handle + " " + value
+	if h, ok, err := store.idempotency.recall(key, request); ok || err != nil {
+		return mutation{kind: "update", handle: h, key: key, replayed: ok}, err
+	}
+	h, checked, err := parseHandle(handle)
+	if err != nil {
+		return mutation{}, err
+	}
+	old, err := getNode(store, h, checked)
+	if err != nil {
+		return mutation{}, err
+	}
+	m := mutation{kind: "update"}
+	if m.previous, err = showValue(store, old); err != nil {
+		return mutation{}, err
+	}
+	if err := updateNode(store, h, checked, value); err != nil {
+		return mutation{}, err
+	}
+	node, err := readNode(store.nodestore, h.ID)
+	if err != nil {
+		return mutation{}, err
+	}
+	m.handle = Handle{h.ID, node.Gen}
+	if m.value, err = showValue(store, node); err != nil {
+		return mutation{}, err
+	}
+	remember(store, key, request, m.handle)
+	return m, notify(store, "update", h.ID)
+}
+
+func comSchema(store *Store, action string, spec string) error {
+	// Show, replace or drop the property schema of a store
+	switch action {
+	case "show":
+		if store.meta.Schema == nil {
+			fmt.Printf("Store %s is schemaless\n", store.name)
+			return nil
+		}
+		for _, prop := range store.meta.Schema {
+			required := ""
+			if prop.Required {
+				required = ", required"
+			}
+			fmt.Printf("%s: %s%s\n", prop.Name, prop.Type, required)
+		}
+		return nil
+	case "set":
+		if dryRun {
+			return ErrDryRun
+		}
+		schema, err := parseSchema(spec)
+		if err != nil {
+			return err
+		}
+		return setSchema(store, schema)
+	case "clear":
+		if dryRun {
+			return ErrDryRun
+		}
+		return setSchema(store, nil)
+	}
+	return fmt.Errorf("unknown schema action %q", action)
+}
+
+func comImport(store *Store, format string, path string) error {
+	// Insert the records of a file, or of the lines that follow, as nodes
+	var err error
+	if dryRun {
+		err = ErrDryRun
+	} else if format != "jsonl" {
+		err = fmt.Errorf("unknown import format %q", format)
+	} else {
+		err = writable(store)
+	}
+	if err != nil {
+		if path == "-" {
+			skipRecords(stdin)
+		}
+		return err
+	}
+	var ids []uint32
+	if path == "-" {
+		ids, err = importJSONL(store, stdin, true)
+	} else {
+		f, ferr := os.Open(path)
+		if ferr != nil {
+			return ferr
+		}
+		defer f.Close()
+		ids, err = importJSONL(store, bufio.NewReader(f), false)
+	}
+	fmt.Printf("Imported %d nodes into %s\n", len(ids), store.name)
+	if len(ids) > 0 {
+		fmt.Println("Node IDs:", formatIDs(ids))
+	}
+	return err
+}
+
+func comExport(store *Store, format string, columns string, header bool, typename string, filter string, path string) error {
+	// Write the nodes of a store, or those selected, to a file
+	if columns == "" {
+		columns = strings.Join(csvColumns, ",")
+	}
+	opts := exportOptions{format: format, columns: parseColumns(columns), header: header}
+	if err := opts.parseSelection(typename, filter); err != nil {
+		return err
+	}
+	n, err := exportFile(store, opts, path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Exported %d nodes from %s to %s\n", n, store.name, path)
+	return nil
+}
+
+func comWebhook(store *Store, action string, hook string) error {
+	// Add, remove or list the URLs told about writes to a store
+	switch action {
+	case "list":
+		if len(store.meta.Webhooks) == 0 {
+			fmt.Printf("Store %s has no webhooks\n", store.name)
+		}
+		for _, hook := range store.meta.Webhooks {
+			fmt.Println(hook)
+		}
+		return nil
+	case "add", "remove":
+		if dryRun {
+			return ErrDryRun
+		}
+		if action == "add" {
+			return addWebhook(store, hook)
+		}
+		return removeWebhook(store, hook)
+	}
+	return fmt.Errorf("unknown webhook action %q", action)
+}
+
+func comMigrate(storename string, dryRun bool) (bool, error) {
+	// Upgrade a store to the current on-disk format
+	if err := validateStoreName(storename); err != nil {
+		return false, err
+	}
+	version, plan, err := migrateStore(storename, dryRun)
+	if err != nil {
+		return false, err
+	}
+	if len(plan) == 0 {
+		fmt.Printf("Store %s is already at format %d\n", storename, version)
+		return false, nil
+	}
+	for _, m := range plan {
+		fmt.Printf("Format %d -> %d: %s\n", m.from, m.to, m.about)
+	}
+	if dryRun {
+		fmt.Println("Dry run, nothing was written")
+		return false, nil
+	}
+	fmt.Printf("Migrated %s to format %d\n", storename, formatVersion)
+	return true, nil
+}
+
+func comUUIDs(store *Store) error {
+	// Turn on UUIDs for the store, backfilling existing nodes
+	if dryRun {
+		return ErrDryRun
+	}
+	assigned, err := enableUUIDs(store)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Assigned %d UUIDs in %s\n", assigned, store.name)
+	return nil
+}
+
+func comLookup(store *Store, key string) error {
+	// Find a node by its UUID
+	u, err := parseUUID(key)
+	if err != nil {
+		return err
+	}
+	// a UUID the bloom filter has never seen is not in the store
+	if store.meta.UUIDs {
+		ok, err := bloomMayContain(store, uuidKey(u))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no node with UUID %s", u)
+		}
+	}
+	idx, err := loadUUIDIndex(store)
+	if err != nil {
+		return err
+	}
+	id, ok := idx.ids[u]
+	if !ok {
+		return fmt.Errorf("no node with UUID %s", u)
+	}
+	node, err := readNode(store.nodestore, id)
+	if err != nil {
+		return err
+	}
+	value, err := showValue(store, node)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Node ID: %d, UUID: %s, Value: %s\n", node.ID, u, value)
+	return nil
+}
+
+func comDelete(store *Store, id uint32) (mutation, error) {
+	// Delete a node from the store
+	if err := writable(store); err != nil {
+		return mutation{}, err
+	}
+	m := mutation{kind: "delete"}
+	if node, err := readNode(store.nodestore, id); err == nil && node.InUse == 1 {
+		m.handle = Handle{id, node.Gen}
+		if m.previous, err = showValue(store, node); err != nil {
+			return mutation{}, err
+		}
+	}
+	var err error
+	if reserved(store.meta, id) {
+		// filled by an import, the slot goes back to the reservation
+		err = emptyReserved(store, id)
+	} else {
+		err = deleteNode(store.nodestore, store.freestore, id)
+	}
+	if err != nil {
+		return mutation{}, err
+	}
+	if err := reindexNode(store, id); err != nil {
+		return mutation{}, err
+	}
+	if hasPoints(store) {
+		if err := removePoint(store, id); err != nil {
+			return mutation{}, err
+		}
+	}
+	if store.meta.UUIDs {
+		if err := removeUUID(store, id); err != nil {
+			return mutation{}, err
+		}
+	}
+	if store.meta.VectorDim != 0 {
+		f, err := vectorFile(store)
+		if err != nil {
+			return mutation{}, err
+		}
+		if err := clearVector(f, store.meta.VectorDim, id); err != nil {
+			return mutation{}, err
+		}
+	}
+	return m, notify(store, "delete", id)
+}
+
+// writeBatch is how many nodes update where and delete where write
+// between progress reports
+const writeBatch = 1000
+
+// matchingNodes returns the IDs of the nodes of store that match a find
+// filter
+func matchingNodes(store *Store, filter string) ([]uint32, error) {
+	preds, err := parseFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	found, _, err := findNodes(store, preds)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]uint32, len(found))
+	for i, node := range found {
+		ids[i] = node.ID
+	}
+	return ids, nil
+}
+
+func comDeleteAll(store *Store, ids []uint32) (int, error) {
+	// Delete many nodes, reporting progress after every batch
+	for i, id := range ids {
+		if _, err := comDelete(store, id); err != nil {
+			return i, fmt.Errorf("node %d: %v", id, err)
+		}
+		if n := i + 1; n%writeBatch == 0 && n < len(ids) {
+			fmt.Printf("Deleted %d of %d nodes\n", n, len(ids))
+		}
+	}
+	return len(ids), nil
+}
+
+func comUpdateAll(store *Store, ids []uint32, path []pathStep, value any) (int, error) {
+	// Set a value in many nodes, reporting progress after every batch
+	for i, id := range ids {
+		handle, data, err := assign(store, id, path, value)
+		if err == nil {
+			_, err = comUpdate(store, handle, data, "")
+		}
+		if err != nil {
+			return i, fmt.Errorf("node %d: %v", id, err)
+		}
+		if n := i + 1; n%writeBatch == 0 && n < len(ids) {
+			fmt.Printf("Updated %d of %d nodes\n", n, len(ids))
+		}
+	}
+	return len(ids), nil
+}
+
+func comGeo(store *Store, id uint32, point string) error {
+	// Attach a position to a node
+	if dryRun {
+		return ErrDryRun
+	}
+	if err := writable(store); err != nil {
+		return err
+	}
+	lat, lon, err := parsePoint(point)
+	if err != nil {
+		return err
+	}
+	return setPoint(store, id, lat, lon)
+}
+
+func comNear(store *Store, point string, km float64) error {
+	// Find the nodes within km of a point
+	lat, lon, err := parsePoint(point)
+	if err != nil {
+		return err
+	}
+	found, err := near(store, lat, lon, km)
+	if err != nil {
+		return err
+	}
+	for _, n := range found {
+		node, err := readNode(store.nodestore, n.ID)
+		if err != nil {
+			return err
+		}
+		value, err := showValue(store, node)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Node ID: %d, Distance: %.3f km, Value: %s\n", n.ID, n.Km, value)
+	}
+	return nil
+}
+
+func comVector(store *Store, id uint32, vector string) error {
+	// Attach a vector to a node
+	if dryRun {
+		return ErrDryRun
+	}
+	if err := writable(store); err != nil {
+		return err
+	}
+	vec, err := parseVector(vector)
+	if err != nil {
+		return err
+	}
+	return setVector(store, id, vec)
+}
+
+func comSearch(store *Store, query string) error {
+	// Find the nodes containing the words of a query, best first
+	matches, err := search(store, query)
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		node, err := readNode(store.nodestore, m.ID)
+		if err != nil {
+			return err
+		}
+		value, err := showValue(store, node)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Node ID: %d, Score: %.4f, Value: %s\n", m.ID, m.Score, value)
+	}
+	fmt.Printf("%d nodes found\n", len(matches))
+	return nil
+}
+
+func comTriples(store *Store, query string) error {
+	// Match triple patterns against the RDF view of a store
+	query, ql, err := cutLimits(query)
+	if err != nil {
+		return err
+	}
+	l := limitsFor(store, ql)
+	opMemory.startScan(l.scanTime)
+	q, err := parseTripleQuery(store, query)
+	if err != nil {
+		return err
+	}
+	var triples []triple
+	if typ := queryType(store, q); typ >= 0 {
+		// only nodes of the type can match, read them through the index
+		var nodes []internal.Node
+		var rows []exportRow
+		nodes, err = typedNodes(store, byte(typ))
+		if err == nil {
+			rows, err = nodeRows(store, nodes)
+		}
+		triples = rowTriples(store, rows)
+	} else {
+		triples, _, err = storeTriples(store)
+	}
+	if err != nil {
+		return err
+	}
+	solutions, err := q.match(triples, opMemory)
+	if err != nil {
+		return err
+	}
+	shown := solutions
+	if l.full(len(solutions)) {
+		shown = solutions[:l.rows]
+	}
+	for _, sol := range shown {
+		var cols []string
+		for _, v := range q.vars {
+			if value, ok := sol[v]; ok {
+				cols = append(cols, v+" = "+value)
+			}
+		}
+		fmt.Println(strings.Join(cols, ", "))
+	}
+	fmt.Printf("%d solutions found", len(solutions))
+	if len(shown) < len(solutions) {
+		fmt.Printf(", first %d shown", len(shown))
+	}
+	fmt.Println()
+	return nil
+}
+
+func comSimilar(store *Store, query string, k int, metric string) error {
+	// Search by the vector of a node, or by a literal vector
+	if k <= 0 {
+		return fmt.Errorf("invalid number of results %d, expected a positive number", k)
+	}
+	var vec []float32
+	exclude := ^uint32(0)
+	if id, err := strconv.ParseUint(query, 10, 32); err == nil {
+		if store.meta.VectorDim == 0 {
+			return fmt.Errorf("store %s has no vectors", store.name)
+		}
+		f, err := vectorFile(store)
+		if err != nil {
+			return err
+		}
+		v, ok, err := readVector(f, store.meta.VectorDim, uint32(id))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("node %d has no vector", id)
+		}
+		vec, exclude = v, uint32(id)
+	} else {
+		vec, err = parseVector(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	matches, err := similar(store, vec, k, metric, exclude)
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		node, err := readNode(store.nodestore, m.ID)
+		if err != nil {
+			return err
+		}
+		value, err := showValue(store, node)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Node ID: %d, Score: %.4f, Value: %s\n", m.ID, m.Score, value)
+	}
+	return nil
+}
+
+func comReadAll(store *Store, typ int) error {
+	// Read all nodes from the store, or those of one type
+	l := limitsFor(store, queryLimits{})
+	opMemory.startScan(l.scanTime)
+	var nodes []internal.Node
+	var err error
+	if typ >= 0 {
+		nodes, err = typedNodes(store, byte(typ))
+	} else {
+		nodes, err = scanStore(store.nodestore, opMemory)
+	}
+	if err != nil {
+		return err
+	}
+	var uuids *uuidIndex
+	if store.meta.UUIDs {
+		if uuids, err = loadUUIDIndex(store); err != nil {
+			return err
+		}
+	}
+	r := nodeResults(uuids)
+	for _, node := range nodes {
+		if node.InUse != 1 {
+			continue
+		}
+		if l.full(len(r.rows)) {
+			r.footer = fmt.Sprintf("first %d nodes shown", l.rows)
+			break
+		}
+		if err := r.addNode(store, node, uuids); err != nil {
+			return err
+		}
+	}
+	return r.print()
+}
+
+func comFind(store *Store, filter string) error {
+	// Filter the nodes of a store by values inside their JSON documents
+	filter, query, err := cutLimits(filter)
+	if err != nil {
+		return err
+	}
+	l := limitsFor(store, query)
+	opMemory.startScan(l.scanTime)
+	preds, err := parseFilter(filter)
+	if err != nil {
+		return err
+	}
+	found, plan, err := cachedFind(store, preds)
+	if err != nil {
+		return err
+	}
+	r := nodeResults(nil)
+	for _, node := range found {
+		if l.full(len(r.rows)) {
+			break
+		}
+		if err := r.addNode(store, node, nil); err != nil {
+			return err
+		}
+	}
+	r.footer = fmt.Sprintf("%d nodes found (%s)", len(found), plan)
+	if len(r.rows) < len(found) {
+		r.footer += fmt.Sprintf(", first %d shown", len(r.rows))
+	}
+	return r.print()
+}
+
+func comCreateIndex(store *Store, props string) error {
+	// Index the nodes of a store by one or more properties
+	if dryRun {
+		return ErrDryRun
+	}
+	list, err := parseProps(props)
+	if err != nil {
+		return err
+	}
+	return createIndex(store, list)
+}
+
+func comIndexes(store *Store) error {
+	// List the indexes of a store and how far their builds are
+	if _, err := loadIndexes(store); err != nil {
+		return err
+	}
+	if len(store.indexes) == 0 {
+		fmt.Printf("Store %s has no indexes\n", store.name)
+	}
+	for _, idx := range store.indexes {
+		fmt.Printf("%s: %s\n", idx.name(), idx.status())
+	}
+	return nil
+}
+
+func comAnalyze(store *Store) error {
+	// Sample the values of a store for the planner
+	if dryRun {
+		return ErrDryRun
+	}
+	stats, err := analyzeStore(store)
+	if err != nil {
+		return err
+	}
+	if outputFormat != "text" {
+		r := &results{columns: []column{{"Property", "property"}, {"Documents", "documents"}, {"Distinct", "distinct"}, {"Buckets", "buckets"}, {"Min", "min"}, {"Max", "max"}}}
+		for _, ps := range stats.Properties {
+			row := []field{textField(ps.Name), numField(ps.Present), numField(ps.Distinct), numField(0), nullField, nullField}
+			if n := len(ps.Histogram); n > 1 {
+				row[3] = numField(n - 1)
+				row[4], row[5] = valueField(ps.Histogram[0]), valueField(ps.Histogram[n-1])
+			}
+			r.add(row...)
+		}
+		r.footer = fmt.Sprintf("Store %s: %d documents, %d sampled", store.name, stats.Nodes, stats.Sampled)
+		return r.print()
+	}
+	fmt.Printf("Store %s: %d documents, %d sampled\n", store.name, stats.Nodes, stats.Sampled)
+	for _, ps := range stats.Properties {
+		fmt.Printf("%s: in ~%d documents, ~%d distinct values", ps.Name, ps.Present, ps.Distinct)
+		if len(ps.Histogram) > 1 {
+			fmt.Printf(", %d buckets from %v to %v", len(ps.Histogram)-1, ps.Histogram[0], ps.Histogram[len(ps.Histogram)-1])
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+func comReindex(store *Store) error {
+	// Rebuild every index of a store from its data
+	if dryRun {
+		return ErrDryRun
+	}
+	return reindexStore(store)
+}
+
+func comReload(stores *registry) {
+	// Apply the config file again without closing stores
+	pending, err := reloadConfig(stores)
+	if err != nil {
+		fmt.Println("Error reloading config:", err)
+		return
+	}
+	fmt.Println("Config reloaded")
+	for _, key := range pending {
+		fmt.Printf("%s changed, restart to apply it\n", key)
+	}
+}
+
+func comStats(stores *registry, storename string) error {
+	// Print the nodestore I/O counted for a store, or for all of them and
+	// for each command
+	if storename != "" {
+		if _, err := findStore(stores, storename); err != nil {
+			return err
+		}
+	}
+	return ioResults(storename).print()
+}
+
+func comMaintenance(stores *registry, action string, storename string) error {
+	// Show the maintenance schedule, or pause or resume it for a store
+	if action == "status" {
+		return maintenanceResults(stores).print()
+	}
+	if action != "pause" && action != "resume" {
+		return fmt.Errorf("unknown action %q, expected status, pause or resume", action)
+	}
+	// look the store up without opening it
+	if _, ok := stores.Get(storename); !ok {
+		return fmt.Errorf("store %s not found", storename)
+	}
+	maintenanceOf(storename).paused = action == "pause"
+	return nil
+}
+
+func comCheck(store *Store, repair bool) error {
+	// Cross-check the free list and the indexes of a store against its nodes
+	problems, err := checkStore(store)
+	if err != nil {
+		return err
+	}
+	repaired := 0
+	for _, p := range problems {
+		fmt.Println(p.What)
+		if !repair || p.repair == nil {
+			continue
+		}
+		if err := p.repair(); err != nil {
+			return fmt.Errorf("repairing %q: %v", p.What, err)
+		}
+		repaired++
+	}
+	fmt.Printf("Store %s: %d problems found, %d repaired\n", store.name, len(problems), repaired)
+	return nil
+}
+
+func comDropIndex(store *Store, props string) error {
+	// Remove an index from a store
+	if dryRun {
+		return ErrDryRun
+	}
+	list, err := parseProps(props)
+	if err != nil {
+		return err
+	}
+	return dropIndex(store, list)
+}
+
+type Store struct {
+	name string
+	// file pointer to the node store
+	nodestore *nodeFile
+	// file pointer to the free store
+	freestore *os.File
+	// settings loaded from the metadata file
+	meta *internal.StoreMeta
+	// file pointer to the vector store, opened on first use
+	vecstore *os.File
+	// file pointer to the position store and its geohash index, opened on first use
+	geostore *os.File
+	geoindex *geoIndex
+	// file pointer to the UUID store and its index, opened on first use
+	uuidstore *os.File
+	uuidindex *uuidIndex
+	// file pointer to the overflow store for values too big for a node, opened on first use
+	ovfstore *os.File
+	// property, full-text and type indexes, built from the nodestore on first use
+	indexes   []*propIndex
+	textindex *textIndex
+	typeindex *typeIndex
+	// bloom filter over the indexed keys, read on first use
+	bloom *bloomFilter
+	// archived in the cold directory, files closed
+	cold bool
+	// not opened yet or closed to free file handles, opened on next use
+	closed bool
+	// when the store was last used, for closing idle stores
+	used uint64
+	// writes made under idempotency keys this session
+	idempotency *idempotencyKeys
+	// refusing writes or all access until thawed
+	frozen freezeLevel
+}
+
+// findStore returns the named store, pulling it back from the cold
+// directory if it was archived
+func findStore(stores *registry, name string) (*Store, error) {
+	store, ok := stores.Get(name)
+	if !ok {
+		return nil, fmt.Errorf("store %s not found", name)
+	}
+	if err := accessible(store); err != nil {
+		return nil, err
+	}
+	if store.cold {
+		if err := unarchiveStore(store); err != nil {
+			return nil, err
+		}
+	}
+	if err := useStore(store); err != nil {
+		return nil, err
+	}
+	currentStore = name
+	return store, nil
+}
+
+// isSidecar reports whether file belongs to a store rather than being one
+func isSidecar(file string) bool {
+	for _, suffix := range sidecarSuffixes {
+		if strings.HasSuffix(file, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// discoverStores returns the names of the stores in dir and its
+// subdirectories. A store in a subdirectory is named by its path from dir
+// with / between the parts, as in tenants/acme/users. Hidden directories
+// and the cold directory are skipped.
+func discoverStores(dir string) ([]string, error) {
+	cold := ""
+	if coldDir != "" {
+		cold, _ = filepath.Abs(coldDir)
+	}
+
+	var names []string
+	err := filepath.WalkDir(dir, func(path string, file fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if file.IsDir() {
+			if path == dir {
+				return nil
+			}
+			if strings.HasPrefix(file.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if abs, _ := filepath.Abs(path); abs == cold {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if len(file.Name()) < 3 {
+			return nil
+		}
+		if isSidecar(file.Name()) {
+			return nil
+		}
+
+		if file.Name()[len(file.Name())-3:] == ".db" {
+			// remove the .db extension
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(rel[:len(rel)-3]))
+		}
+		return nil
+	})
+	return names, err
+}
+
+// lockFileName is the file locked in the data directory while a server or
+// subcommand uses it
+const lockFileName = ".peridot.lock"
+
+// lockDataDir locks the current directory against other processes, so two
+// servers never write the same stores. The lock is held until the
+// returned file is closed or the process exits.
+func lockDataDir() (*os.File, error) {
+	f, err := os.OpenFile(lockFileName, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("data directory is in use by another peridot process (%v)", err)
+	}
+	return f, nil
+}
+
+// makeStoreDir creates the directory a store named with a path goes in
+func makeStoreDir(name string) error {
+	if err := validateStoreName(name); err != nil {
+		return err
+	}
+	return os.MkdirAll(filepath.Dir(filepath.FromSlash(name)), 0755)
+}
+
+// stdin is shared by every prompt, so whole-line reads and fmt.Fscanln
+// never buffer input away from each other. On a terminal, lines are edited
+// by termReader, which completes words on Tab.
+var stdin = bufio.NewReader(&termReader{in: os.Stdin})
+
+// readLine reads the rest of the current input line
+func readLine() string {
+	line, _ := stdin.ReadString('\n')
+	return strings.TrimSpace(line)
+}
+
+func main() {
+	flag.StringVar(&dataDir, "data-dir", dataDir, "directory the stores are in")
+	flag.StringVar(&coldDir, "cold", "", "directory archived stores are moved to")
+	flag.IntVar(&nodeCacheSize, "node-cache", nodeCacheSize, "number of nodes to cache per store, 0 for none")
+	flag.IntVar(&queryCacheSize, "query-cache", 0, "number of find results to cache, 0 for none")
+	flag.Int64Var(&scanMemory, "scan-memory", 0, "bytes of nodes, results and solutions one command may hold, 0 for no limit")
+	flag.IntVar(&maxRows, "max-rows", 0, "most rows a query may return, whatever the store or query asks for, 0 for no ceiling")
+	flag.DurationVar(&maxScanTime, "max-scan-time", 0, "longest a query may scan, whatever the store or query asks for, 0 for no ceiling")
+	flag.IntVar(&maxOpenStores, "max-open-stores", maxOpenStores, "number of stores kept open between commands, 0 for no limit")
+	flag.DurationVar(&maintenanceInterval, "maintenance", 0, "how often to analyze and check each open store between commands, 0 for never")
+	flag.BoolVar(&quarantine, "quarantine", false, "set corrupt records aside in a _corrupt.db file and read on, instead of failing the scan")
+	flag.BoolVar(&dryRun, "dry-run", false, "check and report what writes would do without writing")
+	flag.Var(formatValue{}, "format", "how read, find and analyze print results: text, table, json or csv")
+	flag.BoolVar(&protect, "protect", false, "refuse commands that destroy data, such as drop-index")
+	flag.BoolVar(&force, "force", false, "run commands that destroy data without asking first")
+	flag.StringVar(&config.Prompt, "prompt", config.Prompt, "REPL prompt, with {store}, {pending} and {tx} filled in")
+	flag.StringVar(&configPath, "config", defaultConfig, "config file, settings in it are overridden by PERIDOT_* variables and flags")
+	flag.Parse()
+
+	if err := loadConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error reading config:", err)
+		os.Exit(1)
+	}
+	if flag.Arg(0) == "config" {
+		if flag.NArg() != 2 || flag.Arg(1) != "show" {
+			fmt.Fprintln(os.Stderr, "Usage: peridot config show")
+			os.Exit(2)
+		}
+		showConfig()
+		return
+	}
+	if err := os.Chdir(dataDir); err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening data directory:", err)
+		os.Exit(1)
+	}
+	lock, err := lockDataDir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error opening data directory:", err)
+		os.Exit(1)
+	}
+	defer lock.Close()
+
+	// subcommands run without the REPL, for use from scripts
+	switch flag.Arg(0) {
+	case "":
+	case "import":
+		if err := runImport(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, "Error importing:", err)
+			os.Exit(1)
+		}
+		return
+	case "export":
+		if err := runExport(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, "Error exporting:", err)
+			os.Exit(1)
+		}
+		return
+	case "dump":
+		if err := runDump(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, "Error dumping:", err)
+			os.Exit(1)
+		}
+		return
+	case "restore":
+		if err := runRestore(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, "Error restoring:", err)
+			os.Exit(1)
+		}
+		return
+	case "salvage":
+		if err := runSalvage(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, "Error salvaging:", err)
+			os.Exit(1)
+		}
+		return
+	case "gen":
+		if err := runGen(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, "Error generating code:", err)
+			os.Exit(1)
+		}
+		return
+	default:
+		fmt.Fprintln(os.Stderr, "Unknown command:", flag.Arg(0))
+		os.Exit(2)
+	}
+
+	fmt.Println("Peridot GraphDB Server")
+	if dryRun {
+		fmt.Println("Dry run, nothing will be written")
+	}
+
+	// registry of stores
+	stores := newRegistry()
+
+	// detect .db files in the current directory
+	names, err := discoverStores(".")
+	if err != nil {
+		fmt.Println("Error reading directory:", err)
+		return
+	}
+
+	// stores are registered closed and opened on first use, so a directory
+	// of thousands of stores does not run out of file handles
+	for _, name := range names {
+		if err := validateStoreName(name); err != nil {
+			fmt.Println("Error opening store:", err)
+			continue
+		}
+		stores.Add(&Store{name: name, closed: true})
+	}
+
+	// archived stores are registered closed and opened on first use
+	if coldDir != "" {
+		names, err := discoverStores(coldDir)
+		if err != nil {
+			fmt.Println("Error reading cold directory:", err)
+			return
+		}
+		for _, name := range names {
+			if err := stores.Add(&Store{name: name, cold: true}); err != nil {
+				fmt.Println("Error opening store:", err)
+			}
+		}
+	}
+
+	// store names are completed on Tab from the registry
+	storeNames = func() []string {
+		list := stores.List()
+		names := make([]string, len(list))
+		for i, store := range list {
+			names[i] = store.name
+		}
+		return names
+	}
+
+	// SIGHUP reloads the config between commands
+	watchReload()
+
+	// CLI for interacting with the database
+	var lastCommand string
+	var lastIO ioCounts
+	for {
+		closeIdleStores(stores)
+		if lastCommand != "" {
+			// commands end in continue as often as not, so the I/O of one
+			// is counted when the next is read
+			countOp(lastCommand, lastIO)
+		}
+		if reloadRequested.Swap(false) {
+			comReload(stores)
+		}
+		runMaintenance(stores)
+		var command string
+		// Peridot> prompt, with the pending writes of an open transaction
+		replPrompt = prompt()
+		ask(replPrompt)
+		fmt.Fscanln(stdin, &command)
+		command = resolveAlias(command)
+		lastCommand, lastIO = "", totalIO()
+		opMemory = newMemBudget()
+		if slices.Contains(replCommands, command) {
+			lastCommand = command
+		}
+		fmt.Println()
+		switch command {
+		case "list":
+			// list all stores
+			fmt.Println("Stores:")
+			for _, store := range stores.List() {
+				if store.cold {
+					fmt.Println(store.name, "(cold)")
+					continue
+				}
+				if store.frozen != notFrozen {
+					fmt.Printf("%s (%s)\n", store.name, store.frozen)
+					continue
+				}
+				fmt.Println(store.name)
+			}
+		case "create":
+			// create a new store
+			var storename, codecname string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter codec (binary/protobuf/json, default binary): ")
+			fmt.Fscanln(stdin, &codecname)
+			if codecname == "" {
+				codecname = "binary"
+			}
+			nodestore, freestore, err := comCreate(storename, codecname)
+			if err != nil {
+				fmt.Println("Error creating store:", err)
+				continue
+			}
+			meta, err := readMeta(storename)
+			if err != nil {
+				fmt.Println("Error creating store:", err)
+				continue
+			}
+			// add to the registry
+			stores.Add(&Store{
+				name:      storename,
+				nodestore: nodestore,
+				freestore: freestore,
+				meta:      meta,
+			})
+		case "clone":
+			// clone a store into a new store
+			var srcname, dstname, typename, codecname string
+			ask("Enter source store name: ")
+			fmt.Fscanln(stdin, &srcname)
+			ask("Enter destination store name: ")
+			fmt.Fscanln(stdin, &dstname)
+			ask("Enter node type (blank for all): ")
+			fmt.Fscanln(stdin, &typename)
+			ask("Enter codec (blank to keep the source codec): ")
+			fmt.Fscanln(stdin, &codecname)
+			// find the store in the stores array
+			store, err := findStore(stores, srcname)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			keep := func(internal.Node) bool { return true }
+			if typename != "" {
+				typ, err := strconv.ParseUint(typename, 10, 8)
+				if err != nil {
+					fmt.Println("Error parsing node type:", err)
+					continue
+				}
+				keep = func(node internal.Node) bool { return node.Type == byte(typ) }
+			}
+			nodestore, freestore, err := comClone(store, dstname, codecname, keep)
+			if err != nil {
+				fmt.Println("Error cloning store:", err)
+				continue
+			}
+			meta, err := readMeta(dstname)
+			if err != nil {
+				fmt.Println("Error cloning store:", err)
+				continue
+			}
+			// add to the registry
+			stores.Add(&Store{
+				name:      dstname,
+				nodestore: nodestore,
+				freestore: freestore,
+				meta:      meta,
+			})
+			fmt.Println("Cloned store", srcname, "to", dstname)
+		case "sample":
+			// copy a random sample of the nodes of a store into a new store
+			var srcname, dstname, seedtext string
+			var n int
+			ask("Enter source store name: ")
+			fmt.Fscanln(stdin, &srcname)
+			ask("Enter destination store name: ")
+			fmt.Fscanln(stdin, &dstname)
+			ask("Enter number of nodes: ")
+			fmt.Fscanln(stdin, &n)
+			ask("Enter seed (blank for random): ")
+			fmt.Fscanln(stdin, &seedtext)
+			seed := rand.Uint64()
+			if seedtext != "" {
+				var err error
+				if seed, err = strconv.ParseUint(seedtext, 10, 64); err != nil {
+					fmt.Println("Error parsing seed:", err)
+					continue
+				}
+			}
+			store, err := findStore(stores, srcname)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			nodestore, freestore, err := comSample(store, dstname, n, seed)
+			if err != nil {
+				fmt.Println("Error sampling store:", err)
+				continue
+			}
+			meta, err := readMeta(dstname)
+			if err != nil {
+				fmt.Println("Error sampling store:", err)
+				continue
+			}
+			// add to the registry
+			stores.Add(&Store{
+				name:      dstname,
+				nodestore: nodestore,
+				freestore: freestore,
+				meta:      meta,
+			})
+		case "merge":
+			// merge source stores into a destination store
+			var dstname, srcnames, policy string
+			ask("Enter destination store name: ")
+			fmt.Fscanln(stdin, &dstname)
+			ask("Enter source store names (comma separated): ")
+			fmt.Fscanln(stdin, &srcnames)
+			ask("Enter conflict policy (keep/skip/fail): ")
+			fmt.Fscanln(stdin, &policy)
+			// find the stores in the stores array
+			dst, err := findStore(stores, dstname)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			var srcs []*Store
+			for _, name := range strings.Split(srcnames, ",") {
+				src, err := findStore(stores, name)
+				if err != nil {
+					fmt.Println("Error finding store:", err)
+					srcs = nil
+					break
+				}
+				srcs = append(srcs, src)
+			}
+			if srcs == nil {
+				continue
+			}
+			merged, skipped, err := comMerge(dst, srcs, policy)
+			if err != nil {
+				fmt.Println("Error merging stores:", err)
+				continue
+			}
+			fmt.Printf("Merged %d nodes into %s (%d skipped)\n", merged, dstname, skipped)
+		case "insert":
+			// insert a new node into the store
+			var storename, value string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter value: ")
+			value = readLine()
+			ask("Enter idempotency key (blank for none): ")
+			key := readLine()
+			// find the store in the stores array
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			if tx != nil {
+				if err := tx.queue(store, txOp{kind: "insert", store: storename, value: value, key: key}); err != nil {
+					fmt.Println("Error inserting value:", err)
+				}
+				continue
+			}
+			if dryRun {
+				if err := dryInsert(store, value); err != nil {
+					fmt.Println("Error inserting value:", err)
+				}
+				continue
+			}
+			// insert the value into the store
+			m, err := comInsert(store, value, key)
+			if err == nil {
+				err = m.print()
+			}
+			if err != nil {
+				fmt.Println("Error inserting value:", err)
+				continue
+			}
+		case "attach":
+			// register a store whose files were added after startup
+			var storename string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			err := attachStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error attaching store:", err)
+				continue
+			}
+			fmt.Println("Attached store", storename)
+		case "detach":
+			// close a store and forget it, keeping its files
+			var storename string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			err := detachStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error detaching store:", err)
+				continue
+			}
+			fmt.Println("Detached store", storename)
+		case "refresh":
+			// scan the data directory for stores added or removed
+			attached, detached, err := refreshStores(stores)
+			if err != nil {
+				fmt.Println("Error refreshing stores:", err)
+				continue
+			}
+			for _, name := range attached {
+				fmt.Println("Attached store", name)
+			}
+			for _, name := range detached {
+				fmt.Println("Detached store", name)
+			}
+			fmt.Printf("%d stores attached, %d detached\n", len(attached), len(detached))
+		case "archive":
+			// move a store to the cold directory
+			var storename string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			// look the store up without pulling it back from the cold directory
+			store, ok := stores.Get(storename)
+			if !ok {
+				fmt.Println("Error finding store:", fmt.Errorf("store %s not found", storename))
+				continue
+			}
+			err := comArchive(store)
+			if err != nil {
+				fmt.Println("Error archiving store:", err)
+				continue
+			}
+			fmt.Println("Archived store", storename, "to", coldDir)
+		case "freeze":
+			// refuse writes to a store, or all access, until thawed
+			var storename, what string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Freeze what? (writes/all, default writes): ")
+			fmt.Fscanln(stdin, &what)
+			// a store frozen for all access is not found by findStore
+			store, ok := stores.Get(storename)
+			if !ok {
+				fmt.Println("Error finding store:", fmt.Errorf("store %s not found", storename))
+				continue
+			}
+			err := comFreeze(store, what)
+			if err != nil {
+				fmt.Println("Error freezing store:", err)
+				continue
+			}
+			fmt.Printf("Store %s is %s\n", storename, store.frozen)
+		case "thaw":
+			// let a frozen store be used again
+			var storename string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			store, ok := stores.Get(storename)
+			if !ok {
+				fmt.Println("Error finding store:", fmt.Errorf("store %s not found", storename))
+				continue
+			}
+			err := comThaw(store)
+			if err != nil {
+				fmt.Println("Error thawing store:", err)
+				continue
+			}
+			fmt.Println("Thawed store", storename)
+		case "quota":
+			// set the node and byte limits of a store
+			var storename string
+			var maxNodes uint32
+			var maxBytes int64
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter max nodes (0 for no limit): ")
+			fmt.Fscanln(stdin, &maxNodes)
+			ask("Enter max bytes (0 for no limit): ")
+			fmt.Fscanln(stdin, &maxBytes)
+			// find the store in the stores array
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comQuota(store, maxNodes, maxBytes)
+			if err != nil {
+				fmt.Println("Error setting quota:", err)
+				continue
+			}
+			fmt.Printf("Quota for %s: %d nodes, %d bytes\n", storename, maxNodes, maxBytes)
+		case "reserve":
+			// reserve a block of node IDs for a bulk load
+			var storename, action string
+			var n int
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter action (reserve/release/list): ")
+			fmt.Fscanln(stdin, &action)
+			if action == "reserve" {
+				ask("Enter number of IDs: ")
+				fmt.Fscanln(stdin, &n)
+			}
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comReserve(store, action, n)
+			if err != nil {
+				fmt.Println("Error reserving IDs:", err)
+				continue
+			}
+		case "limits":
+			// set the default row and scan time limits of queries on a store
+			var storename, scanTime string
+			var rows int
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter max rows (0 for no limit): ")
+			fmt.Fscanln(stdin, &rows)
+			ask("Enter max scan time (e.g. 2s, 0 for no limit): ")
+			fmt.Fscanln(stdin, &scanTime)
+			if scanTime == "" {
+				scanTime = "0"
+			}
+			d, err := time.ParseDuration(scanTime)
+			if err != nil {
+				fmt.Println("Error setting limits:", err)
+				continue
+			}
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comLimits(store, rows, d)
+			if err != nil {
+				fmt.Println("Error setting limits:", err)
+				continue
+			}
+			fmt.Printf("Query limits for %s: %d rows, %s scan time\n", storename, rows, d)
+		case "schema":
+			// show or change the property schema of a store
+			var action, storename, spec string
+			ask("Enter action (set/show/clear): ")
+			fmt.Fscanln(stdin, &action)
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			if action == "set" {
+				ask("Enter schema (name:type,... with type string/number/bool, ! for required): ")
+				fmt.Fscanln(stdin, &spec)
+			}
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comSchema(store, action, spec)
+			if err != nil {
+				fmt.Println("Error updating schema:", err)
+				continue
+			}
+			if action != "show" {
+				fmt.Println("Schema of", storename, "updated")
+			}
+		case "import":
+			// insert nodes from JSON Lines records
+			var storename, format, path string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter format (jsonl): ")
+			fmt.Fscanln(stdin, &format)
+			ask("Enter file (- to type records, ending with a blank line): ")
+			fmt.Fscanln(stdin, &path)
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				if path == "-" {
+					skipRecords(stdin)
+				}
+				continue
+			}
+			err = comImport(store, format, path)
+			if err != nil {
+				fmt.Println("Error importing:", err)
+				continue
+			}
+		case "export":
+			// write the nodes of a store to a file
+			var storename, format, columns, header, typename, path string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter format (parquet/ntriples/csv): ")
+			fmt.Fscanln(stdin, &format)
+			if format == "csv" {
+				ask("Enter columns (blank for id,type,value): ")
+				fmt.Fscanln(stdin, &columns)
+				ask("Write a header row? (y/n): ")
+				fmt.Fscanln(stdin, &header)
+			}
+			ask("Enter node type (blank for all): ")
+			fmt.Fscanln(stdin, &typename)
+			ask("Enter filter (blank for all nodes): ")
+			filter := readLine()
+			ask("Enter file: ")
+			fmt.Fscanln(stdin, &path)
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comExport(store, format, columns, header != "n", typename, filter, path)
+			if err != nil {
+				fmt.Println("Error exporting:", err)
+				continue
+			}
+		case "webhook":
+			// change or list the webhooks of a store
+			var action, storename, hook string
+			ask("Enter action (add/remove/list): ")
+			fmt.Fscanln(stdin, &action)
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			if action == "add" || action == "remove" {
+				ask("Enter URL: ")
+				fmt.Fscanln(stdin, &hook)
+			}
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comWebhook(store, action, hook)
+			if err != nil {
+				fmt.Println("Error updating webhooks:", err)
+				continue
+			}
+			if action != "list" {
+				fmt.Println("Webhooks of", storename, "updated")
+			}
+		case "migrate":
+			// upgrade a store to the current on-disk format
+			var storename, answer string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Dry run? (y/n): ")
+			fmt.Fscanln(stdin, &answer)
+			migrated, err := comMigrate(storename, dryRun || answer == "y")
+			if err != nil {
+				fmt.Println("Error migrating store:", err)
+				continue
+			}
+			if !migrated {
+				continue
+			}
+			// stores in an old format are not opened at startup, open it now
+			if _, err := findStore(stores, storename); err == nil {
+				continue
+			}
+			nodestore, freestore, err := comOpen(storename)
+			if err != nil {
+				fmt.Println("Error opening store:", err)
+				continue
+			}
+			meta, err := readMeta(storename)
+			if err != nil {
+				fmt.Println("Error opening store:", err)
+				continue
+			}
+			// add to the registry
+			stores.Add(&Store{
+				name:      storename,
+				nodestore: nodestore,
+				freestore: freestore,
+				meta:      meta,
+			})
+		case "uuids":
+			// give every node of a store a UUID
+			var storename string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			// find the store in the stores array
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comUUIDs(store)
+			if err != nil {
+				fmt.Println("Error assigning UUIDs:", err)
+				continue
+			}
+		case "lookup":
+			// find a node by its UUID
+			var storename, key string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter UUID: ")
+			fmt.Fscanln(stdin, &key)
+			// find the store in the stores array
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comLookup(store, key)
+			if err != nil {
+				fmt.Println("Error looking up node:", err)
+				continue
+			}
+		case "get":
+			// read one node from the store
+			var storename, handle string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter node ID or handle: ")
+			fmt.Fscanln(stdin, &handle)
+			// find the store in the stores array
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comGet(store, handle)
+			if err != nil {
+				fmt.Println("Error reading node:", err)
+				continue
+			}
+		case "random":
+			// read nodes picked at random from the store
+			var storename string
+			n := 1
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter number of nodes (default 1): ")
+			fmt.Fscanln(stdin, &n)
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comRandom(store, n)
+			if err != nil {
+				fmt.Println("Error reading random nodes:", err)
+				continue
+			}
+		case "putblob":
+			// insert a node holding raw bytes
+			var storename, blob string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter blob (base64): ")
+			fmt.Fscanln(stdin, &blob)
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			if tx != nil {
+				if err := tx.queue(store, txOp{kind: "putblob", store: storename, value: blob}); err != nil {
+					fmt.Println("Error inserting blob:", err)
+				}
+				continue
+			}
+			if dryRun {
+				if err := dryPutBlob(store, blob); err != nil {
+					fmt.Println("Error inserting blob:", err)
+				}
+				continue
+			}
+			m, err := comPutBlob(store, blob)
+			if err == nil {
+				err = m.print()
+			}
+			if err != nil {
+				fmt.Println("Error inserting blob:", err)
+				continue
+			}
+		case "getblob":
+			// read the blob of a node
+			var storename, handle, path string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter node ID or handle: ")
+			fmt.Fscanln(stdin, &handle)
+			ask("Enter output file (blank to print base64): ")
+			fmt.Fscanln(stdin, &path)
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comGetBlob(store, handle, path)
+			if err != nil {
+				fmt.Println("Error reading blob:", err)
+				continue
+			}
+		case "update":
+			// replace the value of a node, or set a value in every node
+			// matching a filter
+			var storename, value string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter node ID or handle, or where a find filter set path = value: ")
+			handle := readLine()
+			if len(handle) > 6 && strings.EqualFold(handle[:6], "where ") {
+				filter, assignment, ok := cutSet(handle[6:])
+				if !ok {
+					fmt.Println("Error updating nodes: expected where filter set path = value")
+					continue
+				}
+				path, value, err := parseAssignment(assignment)
+				if err != nil {
+					fmt.Println("Error updating nodes:", err)
+					continue
+				}
+				store, err := findStore(stores, storename)
+				if err != nil {
+					fmt.Println("Error finding store:", err)
+					continue
+				}
+				ids, err := matchingNodes(store, filter)
+				if err != nil {
+					fmt.Println("Error updating nodes:", err)
+					continue
+				}
+				if tx != nil {
+					// all of the writes are queued or none
+					queued := len(tx.ops)
+					for _, id := range ids {
+						var h, data string
+						if h, data, err = assign(store, id, path, value); err != nil {
+							err = fmt.Errorf("node %d: %v", id, err)
+							break
+						}
+						if err = tx.queue(store, txOp{kind: "update", store: storename, handle: h, value: data}); err != nil {
+							break
+						}
+					}
+					if err != nil {
+						tx.ops = tx.ops[:queued]
+						fmt.Println("Error updating nodes:", err)
+						continue
+					}
+					fmt.Printf("Queued %d updates\n", len(ids))
+					continue
+				}
+				if dryRun {
+					fmt.Printf("Dry run: would update %d nodes\n", len(ids))
+					continue
+				}
+				if err := confirm(fmt.Sprintf("update %d nodes of store %s", len(ids), storename)); err != nil {
+					fmt.Println("Error updating nodes:", err)
+					continue
+				}
+				n, err := comUpdateAll(store, ids, path, value)
+				if err != nil {
+					fmt.Printf("Error updating nodes: %v, %d of %d updated\n", err, n, len(ids))
+					continue
+				}
+				printAffected("update", n)
+				continue
+			}
+			ask("Enter value: ")
+			value = readLine()
+			ask("Enter idempotency key (blank for none): ")
+			key := readLine()
+			// find the store in the stores array
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			if tx != nil {
+				if err := tx.queue(store, txOp{kind: "update", store: storename, handle: handle, value: value, key: key}); err != nil {
+					fmt.Println("Error updating node:", err)
+				}
+				continue
+			}
+			if dryRun {
+				if err := dryUpdate(store, handle, value); err != nil {
+					fmt.Println("Error updating node:", err)
+				}
+				continue
+			}
+			m, err := comUpdate(store, handle, value, key)
+			if err == nil {
+				err = m.print()
+			}
+			if err != nil {
+				fmt.Println("Error updating node:", err)
+				continue
+			}
+		case "delete":
+			// delete a node from the store, or every node matching a filter
+			var storename string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter node ID, or where and a find filter: ")
+			target := readLine()
+			// find the store in the stores array
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			if len(target) > 6 && strings.EqualFold(target[:6], "where ") {
+				ids, err := matchingNodes(store, target[6:])
+				if err != nil {
+					fmt.Println("Error deleting nodes:", err)
+					continue
+				}
+				if tx != nil {
+					// all of the writes are queued or none
+					queued := len(tx.ops)
+					for _, id := range ids {
+						if err = tx.queue(store, txOp{kind: "delete", store: storename, id: id}); err != nil {
+							break
+						}
+					}
+					if err != nil {
+						tx.ops = tx.ops[:queued]
+						fmt.Println("Error deleting nodes:", err)
+						continue
+					}
+					fmt.Printf("Queued %d deletes\n", len(ids))
+					continue
+				}
+				if dryRun {
+					fmt.Printf("Dry run: would delete %d nodes\n", len(ids))
+					continue
+				}
+				if err := confirm(fmt.Sprintf("delete %d nodes of store %s", len(ids), storename)); err != nil {
+					fmt.Println("Error deleting nodes:", err)
+					continue
+				}
+				n, err := comDeleteAll(store, ids)
+				if err != nil {
+					fmt.Printf("Error deleting nodes: %v, %d of %d deleted\n", err, n, len(ids))
+					continue
+				}
+				printAffected("delete", n)
+				continue
+			}
+			id, err := strconv.ParseUint(target, 10, 32)
+			if err != nil {
+				fmt.Println("Error parsing node ID:", err)
+				continue
+			}
+			if tx != nil {
+				if err := tx.queue(store, txOp{kind: "delete", store: storename, id: uint32(id)}); err != nil {
+					fmt.Println("Error deleting node:", err)
+				}
+				continue
+			}
+			if dryRun {
+				if err := dryDelete(store, uint32(id)); err != nil {
+					fmt.Println("Error deleting node:", err)
+				}
+				continue
+			}
+			// delete the node from the store
+			m, err := comDelete(store, uint32(id))
+			if err == nil {
+				err = m.print()
+			}
+			if err != nil {
+				fmt.Println("Error deleting node:", err)
+				continue
+			}
+		case "begin":
+			// queue the following writes until commit or rollback
+			err := beginTx()
+			if err != nil {
+				fmt.Println("Error starting transaction:", err)
+				continue
+			}
+			fmt.Println("Transaction started")
+		case "commit":
+			// make the queued writes, all of them or none
+			if tx == nil {
+				fmt.Println("Error committing transaction: no transaction is open")
+				continue
+			}
+			t := tx
+			tx = nil
+			err := t.commit(stores)
+			if err != nil {
+				fmt.Println("Error committing transaction:", err)
+				continue
+			}
+			fmt.Printf("Committed %d writes\n", len(t.ops))
+		case "rollback":
+			// drop the queued writes
+			if tx == nil {
+				fmt.Println("Error rolling back transaction: no transaction is open")
+				continue
+			}
+			fmt.Printf("Rolled back %d writes\n", len(tx.ops))
+			tx = nil
+		case "vector":
+			// attach a vector to a node
+			var storename, vector string
+			var id uint32
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter node ID: ")
+			fmt.Fscanln(stdin, &id)
+			ask("Enter vector (comma separated): ")
+			fmt.Fscanln(stdin, &vector)
+			// find the store in the stores array
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comVector(store, id, vector)
+			if err != nil {
+				fmt.Println("Error setting vector:", err)
+				continue
+			}
+			fmt.Println("Set vector of node ID:", id)
+		case "similar":
+			// find the nodes with the closest vectors
+			var storename, query, metric string
+			k := 10
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter node ID or vector (comma separated): ")
+			fmt.Fscanln(stdin, &query)
+			ask("Enter number of results (default 10): ")
+			fmt.Fscanln(stdin, &k)
+			ask("Enter metric (cosine/l2, default cosine): ")
+			fmt.Fscanln(stdin, &metric)
+			if metric == "" {
+				metric = metricCosine
+			}
+			// find the store in the stores array
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comSimilar(store, query, k, metric)
+			if err != nil {
+				fmt.Println("Error searching vectors:", err)
+				continue
+			}
+		case "search":
+			// find nodes by the words in their values
+			var storename string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter terms (words, AND, OR): ")
+			query := readLine()
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comSearch(store, query)
+			if err != nil {
+				fmt.Println("Error searching nodes:", err)
+				continue
+			}
+		case "triples":
+			// match triple patterns against the RDF view of a store
+			var storename string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter patterns (e.g. ?n prop:city \"Oslo\" . ?n prop:name ?name): ")
+			query := readLine()
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comTriples(store, query)
+			if err != nil {
+				fmt.Println("Error matching triples:", err)
+				continue
+			}
+		case "geo":
+			// attach a position to a node
+			var storename, point string
+			var id uint32
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter node ID: ")
+			fmt.Fscanln(stdin, &id)
+			ask("Enter position (lat,lon): ")
+			fmt.Fscanln(stdin, &point)
+			// find the store in the stores array
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comGeo(store, id, point)
+			if err != nil {
+				fmt.Println("Error setting position:", err)
+				continue
+			}
+			fmt.Println("Set position of node ID:", id)
+		case "near":
+			// find the nodes within a radius of a point
+			var storename, point string
+			var km float64
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter position (lat,lon): ")
+			fmt.Fscanln(stdin, &point)
+			ask("Enter radius in km: ")
+			fmt.Fscanln(stdin, &km)
+			// find the store in the stores array
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comNear(store, point, km)
+			if err != nil {
+				fmt.Println("Error searching positions:", err)
+				continue
+			}
+		case "read":
+			// read all nodes from the store
+			var storename, typename string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter node type (blank for all): ")
+			fmt.Fscanln(stdin, &typename)
+			typ, err := parseNodeType(typename)
+			if err != nil {
+				fmt.Println("Error reading nodes:", err)
+				continue
+			}
+			// find the store in the stores array
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			// read all nodes from the store
+			err = comReadAll(store, typ)
+			if err != nil {
+				fmt.Println("Error reading nodes:", err)
+				continue
+			}
+		case "find":
+			// filter nodes by a path into their JSON values
+			var storename string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter filter (e.g. $.address.city = \"Oslo\" AND age >= 30): ")
+			filter := readLine()
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comFind(store, filter)
+			if err != nil {
+				fmt.Println("Error finding nodes:", err)
+				continue
+			}
+		case "create-index":
+			// index a store by one or more properties
+			var storename, props string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter properties (e.g. name,age): ")
+			fmt.Fscanln(stdin, &props)
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comCreateIndex(store, props)
+			if err != nil {
+				fmt.Println("Error creating index:", err)
+				continue
+			}
+			fmt.Println("Building index on", props, "in the background, see indexes for progress")
+		case "indexes":
+			// list the indexes of a store
+			var storename string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comIndexes(store)
+			if err != nil {
+				fmt.Println("Error listing indexes:", err)
+				continue
+			}
+		case "check":
+			// verify a store and its indexes
+			var storename, answer string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Repair what can be repaired? (y/n): ")
+			fmt.Fscanln(stdin, &answer)
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comCheck(store, answer == "y" && !dryRun)
+			if err != nil {
+				fmt.Println("Error checking store:", err)
+				continue
+			}
+		case "analyze":
+			// gather statistics on the values of a store
+			var storename string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comAnalyze(store)
+			if err != nil {
+				fmt.Println("Error analyzing store:", err)
+				continue
+			}
+		case "reindex":
+			// rebuild the indexes of a store from its data
+			var storename string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			err = comReindex(store)
+			if err != nil {
+				fmt.Println("Error rebuilding indexes:", err)
+				continue
+			}
+			fmt.Println("Rebuilding indexes of", storename, "in the background, see indexes for progress")
+		case "drop-index":
+			// remove an index from a store
+			var storename, props string
+			ask("Enter store name: ")
+			fmt.Fscanln(stdin, &storename)
+			ask("Enter properties of the index: ")
+			fmt.Fscanln(stdin, &props)
+			store, err := findStore(stores, storename)
+			if err != nil {
+				fmt.Println("Error finding store:", err)
+				continue
+			}
+			if !dryRun {
+				if err := confirm(fmt.Sprintf("drop the index on %s of store %s", props, storename)); err != nil {
+					fmt.Println("Error dropping index:", err)
+					continue
+				}
+			}
+			err = comDropIndex(store, props)
+			if err != nil {
+				fmt.Println("Error dropping index:", err)
+				continue
+			}
+			fmt.Println("Dropped index on", props)
+		case "format":
+			// choose how read, find and analyze print results
+			var format string
+			ask(fmt.Sprintf("Enter output format (%s, now %s): ", strings.Join(outputFormats, "/"), outputFormat))
+			fmt.Fscanln(stdin, &format)
+			if format == "" {
+				continue
+			}
+			err := setOutputFormat(format)
+			if err != nil {
+				fmt.Println("Error setting output format:", err)
+				continue
+			}
+			fmt.Println("Output format set to", format)
+		case "maintenance":
+			// show or pause the background maintenance of stores
+			var action, storename string
+			ask("Enter action (status/pause/resume): ")
+			fmt.Fscanln(stdin, &action)
+			if action == "pause" || action == "resume" {
+				ask("Enter store name: ")
+				fmt.Fscanln(stdin, &storename)
+			}
+			err := comMaintenance(stores, action, storename)
+			if err != nil {
+				fmt.Println("Error updating maintenance:", err)
+				continue
+			}
+			if action != "status" {
+				fmt.Printf("Maintenance of %s %sd\n", storename, action)
+			}
+		case "stats":
+			// print I/O counters by store and by command
+			var storename string
+			ask("Enter store name (blank for all): ")
+			fmt.Fscanln(stdin, &storename)
+			err := comStats(stores, storename)
+			if err != nil {
+				fmt.Println("Error printing stats:", err)
+				continue
+			}
+		case "reload":
+			// read the config file again
+			comReload(stores)
+		case "version":
+			// print the version of the server
+			fmt.Println("\nPeridot GraphDB Server v0.1")
+			fmt.Println("Copyright (c) 2024 Muhammad Nabeel Adzan")
+			fmt.Println("All rights reserved.")
+			fmt.Println("This is free software; you are free to use it under the terms of the MIT License.")
+			fmt.Println("This software is provided 'as is' without warranty of any kind.")
+			fmt.Println("See the LICENSE file for more details.")
+			fmt.Println()
+		case "help":
+			// print the help message
+			fmt.Println("Commands:")
+			fmt.Println("list - list all stores")
+			fmt.Println("create - create a new store, choosing its record codec")
+			fmt.Println("clone - copy a store into a new store, optionally by node type or to another codec")
+			fmt.Println("sample - copy a uniform random sample of the nodes of a store into a new store, keeping their IDs")
+			fmt.Println("merge - import the nodes of other stores into a store")
+			fmt.Println("export - write the nodes of a store, or those of one type or matching a filter, to a Parquet, N-Triples or CSV file")
+			fmt.Println("import - insert nodes from a JSON Lines file, one {\"value\": ...} record per line")
+			fmt.Println("reserve - reserve a block of node IDs for import records with an \"id\" to fill, release the unused ones or list them")
+			fmt.Println("insert - insert a new node into the store")
+			fmt.Println("get - read one node by ID or id:generation handle")
+			fmt.Println("random - read nodes picked uniformly at random from a store, skipping free slots")
+			fmt.Println("putblob - insert a node holding raw bytes, given as base64")
+			fmt.Println("getblob - write the blob of a node to a file, or print it as base64")
+			fmt.Println("update - replace the value of a node by ID or handle, or with where ... set path = value set it in every node matching a find filter")
+			fmt.Println("delete - delete a node from the store, or with where and a find filter every node matching it")
+			fmt.Println("begin - queue the following inserts, putblobs, updates and deletes until commit")
+			fmt.Println("commit - make the queued writes, or none of them if one fails")
+			fmt.Println("rollback - drop the queued writes")
+			fmt.Println("migrate - upgrade a store to the current on-disk format")
+			fmt.Println("uuids - give every node of a store a UUID, now and on insert")
+			fmt.Println("lookup - find a node by its UUID")
+			fmt.Println("schema - set, show or clear the property schema of a store")
+			fmt.Println("webhook - add, remove or list URLs that get a POST on every insert, update and delete")
+			fmt.Println("quota - limit the node count or byte size of a store")
+			fmt.Println("limits - set the default row and scan time limits of queries on a store, e.g. find ... LIMIT 10 TIMEOUT 2s to override")
+			fmt.Println("attach - register a store whose files were added to the data directory after startup")
+			fmt.Println("detach - close a store and forget it until attached again, keeping its files")
+			fmt.Println("refresh - scan the data directory again, attaching new stores and detaching removed ones")
+			fmt.Println("archive - move a store to the cold directory until it is next used")
+			fmt.Println("freeze - refuse writes to a store, or all access, while it is backed up, compacted or migrated")
+			fmt.Println("thaw - let a frozen store be used again")
+			fmt.Println("read - read all nodes from the store, or those of one type")
+			fmt.Println("find - find nodes by paths into their JSON values, e.g. $.address.city = Oslo AND age BETWEEN 20 AND 30")
+			fmt.Println("search - find nodes by the words in their values, best match first")
+			fmt.Println("triples - match SPARQL-style triple patterns against the RDF view of a store")
+			fmt.Println("create-index - index a store by one or more properties in the background, used by find")
+			fmt.Println("indexes - list the indexes of a store and their build progress")
+			fmt.Println("reindex - rebuild every index of a store from its data")
+			fmt.Println("analyze - sample the values of a store so find can plan better")
+			fmt.Println("check - verify the free list and indexes of a store against its nodes, optionally repairing them")
+			fmt.Println("drop-index - remove an index from a store")
+			fmt.Println("vector - attach a vector to a node")
+			fmt.Println("similar - find the nodes with the closest vectors")
+			fmt.Println("geo - attach a position to a node")
+			fmt.Println("near - find the nodes within a radius of a point")
+			fmt.Println("format - print the results of read, find and analyze as text, a table, JSON or CSV")
+			fmt.Println("maintenance - show the schedule of the periodic analyze and check of each store, or pause or resume it")
+			fmt.Println("stats - print the nodestore reads, writes and cache hits of each store and each command")
+			fmt.Println("reload - read the config file and PERIDOT_* variables again, also done on SIGHUP")
+			fmt.Println("version - print the version of the server")
+			fmt.Println("help - print this help message")
+			fmt.Println("exit - close all stores and exit")
+		case "exit":
+			// close all stores and exit
+			if tx != nil {
+				fmt.Printf("Rolled back %d writes\n", len(tx.ops))
+			}
+			for _, store := range stores.List() {
+				err := comClose(store)
+				if err != nil {
+					fmt.Println("Error closing store:", err)
+					continue
+				}
+			}
+			// give queued webhook events a chance to go out
+			if !flushWebhooks() {
+				fmt.Println("Error delivering webhooks: gave up waiting, some events were not sent")
+			}
 			fmt.Println("Exiting Peridot GraphDB Server")
 			return
 		default:
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"time"
+)
+
+// maintenanceInterval is how often each store is maintained, 0 to turn
+// maintenance off. It is set by the -maintenance flag.
+var maintenanceInterval time.Duration
+
+// maintenancePerRound is how many stores are maintained between two
+// commands, so the prompt never waits on more than that
+const maintenancePerRound = 1
+
+// maintenanceTask is one job run on a store. It returns a summary of what
+// it found.
+type maintenanceTask struct {
+	name string
+	run  func(store *Store) (string, error)
+}
+
+// maintenanceTasks are run on a store in this order every interval:
+// refreshing the statistics find plans with, then checking the free list
+// and indexes. Problems are reported, not repaired.
+var maintenanceTasks = []maintenanceTask{
+	{"analyze", func(store *Store) (string, error) {
+		stats, err := analyzeStore(store)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d documents", stats.Nodes), nil
+	}},
+	{"check", func(store *Store) (string, error) {
+		problems, err := checkStore(store)
+		if err != nil {
+			return "", err
+		}
+		if len(problems) > 0 {
+			fmt.Printf("Maintenance found %d problems in store %s, run check to see them\n", len(problems), store.name)
+		}
+		return fmt.Sprintf("%d problems", len(problems)), nil
+	}},
+}
+
+// maintenanceState is the schedule of one store, kept by name so it
+// survives the store being closed and reopened
+type maintenanceState struct {
+	paused bool
+	next   time.Time
+	last   time.Time
+	result string
+}
+
+var maintenance = make(map[string]*maintenanceState)
+
+func maintenanceOf(name string) *maintenanceState {
+	m, ok := maintenance[name]
+	if !ok {
+		m = &maintenanceState{next: time.Now().Add(jitter(maintenanceInterval))}
+		maintenance[name] = m
+	}
+	return m
+}
+
+// jitter returns d give or take a tenth, so stores that were opened
+// together are not all maintained together
+func jitter(d time.Duration) time.Duration {
+	if d < 10 {
+		return d
+	}
+	return d - d/10 + rand.N(d/5)
+}
+
+// runMaintenance runs the tasks of the stores that are due, most overdue
+// first. It runs between commands, as the stores are not safe to use from
+// two goroutines. Stores that are closed, archived, frozen, building an
+// index or paused wait, as does everything during a dry run or a
+// transaction.
+func runMaintenance(stores *registry) {
+	if maintenanceInterval <= 0 || dryRun || tx != nil {
+		return
+	}
+	now := time.Now()
+	var due []*Store
+	for _, store := range stores.List() {
+		if store.cold || store.closed || store.frozen != notFrozen {
+			continue
+		}
+		m := maintenanceOf(store.name)
+		if !m.paused && !now.Before(m.next) && !building(store) {
+			due = append(due, store)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return maintenanceOf(due[i].name).next.Before(maintenanceOf(due[j].name).next)
+	})
+	for _, store := range due[:min(len(due), maintenancePerRound)] {
+		m := maintenanceOf(store.name)
+		m.result = ""
+		for _, task := range maintenanceTasks {
+			summary, err := task.run(store)
+			if err != nil {
+				summary = "error: " + err.Error()
+			}
+			if m.result != "" {
+				m.result += ", "
+			}
+			m.result += task.name + " " + summary
+		}
+		m.last = now
+		m.next = now.Add(jitter(maintenanceInterval))
+	}
+}
+
+// maintenanceResults returns the schedule of every store
+func maintenanceResults(stores *registry) *results {
+	r := &results{columns: []column{{"Store", "store"}, {"State", "state"}, {"Next", "next"}, {"Last", "last"}, {"Result", "result"}}}
+	for _, store := range stores.List() {
+		m := maintenanceOf(store.name)
+		state, next, last := "active", nullField, nullField
+		switch {
+		case maintenanceInterval <= 0:
+			state = "off"
+		case m.paused:
+			state = "paused"
+		case store.cold || store.closed:
+			state = "waiting until opened"
+		}
+		if state == "active" || state == "waiting until opened" {
+			next = textField(m.next.Format(time.DateTime))
+		}
+		if !m.last.IsZero() {
+			last = textField(m.last.Format(time.DateTime))
+		}
+		r.add(textField(store.name), textField(state), next, last, textField(m.result))
+	}
+	return r
+}
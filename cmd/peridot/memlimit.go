@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+	"unsafe"
+
+	"github.com/nabeeladzan/peridot/internal"
+	"github.com/nabeeladzan/peridot/internal/codec"
+)
+
+// scanMemory is the most memory in bytes a REPL command may hold in nodes
+// read by a scan, rows of results and triple pattern solutions, 0 for no
+// limit. It is set by the -scan-memory flag.
+var scanMemory int64
+
+// ErrMemoryLimit is returned by a command that would hold more than
+// scanMemory bytes
+var ErrMemoryLimit = errors.New("memory limit exceeded")
+
+// memBudget adds up the memory one command holds, and stops a command
+// scanning past its deadline. A nil *memBudget counts nothing, so code
+// also run outside the REPL can charge it freely.
+type memBudget struct {
+	used     int64
+	limit    int64
+	deadline time.Time // zero for none
+}
+
+// opMemory is the budget of the REPL command running, nil outside the
+// REPL. Index builds run in the background and are not charged.
+var opMemory *memBudget
+
+func newMemBudget() *memBudget {
+	return &memBudget{limit: scanMemory}
+}
+
+// charge counts n more bytes, failing with ErrMemoryLimit once the limit
+// is passed, and with ErrScanTimeout once the deadline is
+func (b *memBudget) charge(n int) error {
+	if b == nil {
+		return nil
+	}
+	if !b.deadline.IsZero() && time.Now().After(b.deadline) {
+		return fmt.Errorf("%w: narrow the query or give it a longer TIMEOUT", ErrScanTimeout)
+	}
+	b.used += int64(n)
+	if b.limit > 0 && b.used > b.limit {
+		return fmt.Errorf("%w: more than %d bytes, raise -scan-memory or narrow the command", ErrMemoryLimit, b.limit)
+	}
+	return nil
+}
+
+// nodeSize is the memory a decoded node takes
+const nodeSize = int(unsafe.Sizeof(internal.Node{}))
+
+// scanStore is readStore charging the nodes it reads to b. With
+// -quarantine, a corrupt record is set aside and its slot read as free.
+func scanStore(f *nodeFile, b *memBudget) ([]internal.Node, error) {
+	var nodes []internal.Node
+	buf := make([]byte, f.codec.RecordSize())
+	for i := 0; ; i++ {
+		_, err := f.ReadAt(buf, f.offset(uint32(i)))
+		if errors.Is(err, io.EOF) {
+			// a record cut short by a crash mid-append is not read
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		node, err := decodeRecord(f, uint32(i), buf)
+		if errors.Is(err, codec.ErrCorrupt) && quarantine {
+			if err := quarantineRecord(f, uint32(i), buf); err != nil {
+				return nil, fmt.Errorf("node %d: quarantining corrupt record: %v", i, err)
+			}
+			node = internal.Node{ID: uint32(i)}
+		} else if err != nil {
+			return nil, fmt.Errorf("node %d: %w", i, err)
+		}
+		if err := b.charge(nodeSize); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
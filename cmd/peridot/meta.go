@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// ErrQuotaExceeded is returned when an insert would take a store past the
+// limits recorded in its metadata
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// readMeta loads the metadata of the named store. A store without a
+// metadata file has no settings yet and gets the zero value.
+func readMeta(name string) (*internal.StoreMeta, error) {
+	meta := &internal.StoreMeta{}
+	data, err := os.ReadFile(name + "_meta.json")
+	if errors.Is(err, os.ErrNotExist) {
+		return meta, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, fmt.Errorf("metadata of store %s is corrupt: %v", name, err)
+	}
+	return meta, nil
+}
+
+// writeMeta replaces the metadata file of the named store
+func writeMeta(name string, meta *internal.StoreMeta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := name + "_meta.json.tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return replaceFile(tmp, name+"_meta.json")
+}
+
+// checkQuota reports whether one more node fits in the store, with
+// payload bytes of its value going to the overflow store
+func checkQuota(store *Store, payload int64) error {
+	meta := store.meta
+	if meta.MaxNodes == 0 && meta.MaxBytes == 0 {
+		return nil
+	}
+
+	freeID, err := getFree(store.freestore)
+	if err != nil {
+		return err
+	}
+	// a new slot is only needed when nothing can be reused
+	if err := checkBytes(store, freeID == ^uint32(0), payload); err != nil {
+		return err
+	}
+
+	return checkNodeQuota(store)
+}
+
+// checkBytes reports whether the store stays within its byte limit after
+// a write that appends payload bytes to the overflow store, and a slot to
+// the nodestore if slot is set. Blobs and long values are in the overflow
+// store, so it counts towards the limit too.
+func checkBytes(store *Store, slot bool, payload int64) error {
+	meta := store.meta
+	if meta.MaxBytes == 0 {
+		return nil
+	}
+	fi, err := store.nodestore.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size() + payload
+	if slot {
+		size += int64(store.nodestore.codec.RecordSize())
+	}
+	ovf, err := overflowSize(store)
+	if err != nil {
+		return err
+	}
+	if size+ovf > meta.MaxBytes {
+		return fmt.Errorf("store %s is limited to %d bytes: %w", store.name, meta.MaxBytes, ErrQuotaExceeded)
+	}
+	return nil
+}
+
+// checkNodeQuota reports whether one more node fits in the node limit of
+// the store, for writes that need no new slot
+func checkNodeQuota(store *Store) error {
+	meta := store.meta
+	if meta.MaxNodes == 0 {
+		return nil
+	}
+	fi, err := store.nodestore.Stat()
+	if err != nil {
+		return err
+	}
+	// the slot count bounds the node count, so only scan when close to the limit
+	if store.nodestore.slots(fi.Size()) >= int64(meta.MaxNodes) {
+		nodes, err := readStore(store.nodestore)
+		if err != nil {
+			return err
+		}
+		var count uint32
+		for _, node := range nodes {
+			if node.InUse == 1 {
+				count++
+			}
+		}
+		if count >= meta.MaxNodes {
+			return fmt.Errorf("store %s is limited to %d nodes: %w", store.name, meta.MaxNodes, ErrQuotaExceeded)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nabeeladzan/peridot/internal/codec"
+)
+
+// A migration upgrades a closed store from one format version to the next
+type migration struct {
+	from  uint16
+	to    uint16
+	about string
+	apply func(name string) error
+}
+
+// migrations holds every upgrade step in order. A new format version
+// appends its step here and bumps formatVersion.
+var migrations = []migration{
+	{0, 1, "add a header to the nodestore", addHeader},
+}
+
+// storeVersion reads the format version of the named store's nodestore
+func storeVersion(name string) (uint16, error) {
+	f, err := os.Open(name + ".db")
+	if err != nil {
+		return 0, fmt.Errorf("file %s does not exist", name)
+	}
+	defer f.Close()
+	version, _, err := readHeader(f)
+	return version, err
+}
+
+// planMigration returns the steps that take a store from version to the
+// current format
+func planMigration(version uint16) ([]migration, error) {
+	var plan []migration
+	for _, m := range migrations {
+		if m.from == version {
+			plan = append(plan, m)
+			version = m.to
+		}
+	}
+	if version != formatVersion {
+		return nil, fmt.Errorf("no migration path from format %d to %d", version, formatVersion)
+	}
+	return plan, nil
+}
+
+// migrateStore upgrades the named store, which must not be open, to the
+// current format. With dryRun set it only works out the steps.
+func migrateStore(name string, dryRun bool) (uint16, []migration, error) {
+	version, err := storeVersion(name)
+	if err != nil {
+		return 0, nil, err
+	}
+	if version > formatVersion {
+		return version, nil, fmt.Errorf("store %s uses format %d, newer than this server supports (%d)", name, version, formatVersion)
+	}
+	plan, err := planMigration(version)
+	if err != nil || dryRun {
+		return version, plan, err
+	}
+	for _, m := range plan {
+		if err := m.apply(name); err != nil {
+			return version, plan, fmt.Errorf("migrating %s from format %d to %d: %v", name, m.from, m.to, err)
+		}
+	}
+	return version, plan, nil
+}
+
+// addHeader rewrites a format 0 nodestore with a header in front of its
+// records. It works on a copy that replaces the original once synced.
+func addHeader(name string) error {
+	src, err := os.Open(name + ".db")
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.Create(name + ".db.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create file %s", name+".db.tmp")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// format 0 stores are always binary
+	if err := writeHeader(tmp, codec.Binary{}); err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(codec.NodeSize, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, src); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	// Windows cannot rename over a file that is open, or rename one that is
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	src.Close()
+	return replaceFile(tmp.Name(), name+".db")
+}
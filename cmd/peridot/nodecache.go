@@ -0,0 +1,158 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// nodeCacheSize is the number of nodes each open nodestore keeps in
+// memory, 0 to turn the cache off. It is set by the -node-cache flag.
+var nodeCacheSize = 1024
+
+// nodeCache keeps recently read nodes of one nodestore, and the IDs
+// recently found past its end, least recently used first out. Every write
+// to the nodestore goes through nodeFile.WriteAt, which drops the slots
+// it touches. The background index builds read nodes too, hence the lock.
+// A node read while a write lands would be cached as it was before, so a
+// read is only cached if no slot was dropped since it started.
+type nodeCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[uint32]*list.Element
+	order   *list.List // front is most recently used
+	drops   uint64     // bumped by every drop
+}
+
+type cachedNode struct {
+	id   uint32
+	node internal.Node
+	err  error // io.EOF for a slot past the end of the nodestore
+}
+
+func newNodeCache(size int) *nodeCache {
+	if size <= 0 {
+		return nil
+	}
+	return &nodeCache{size: size, entries: make(map[uint32]*list.Element), order: list.New()}
+}
+
+func (c *nodeCache) get(id uint32) (*cachedNode, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*cachedNode), true
+}
+
+// version returns the drop count to pass to put for a read starting now
+func (c *nodeCache) version() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.drops
+}
+
+// put caches a node read since version, unless a write dropped a slot
+// meanwhile
+func (c *nodeCache) put(n *cachedNode, version uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.drops != version {
+		return
+	}
+	if e, ok := c.entries[n.id]; ok {
+		e.Value = n
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[n.id] = c.order.PushFront(n)
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(*cachedNode).id)
+	}
+}
+
+// resize changes how many nodes the cache keeps, evicting the least
+// recently used ones if it shrinks
+func (c *nodeCache) resize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = max(size, 0)
+	for c.order.Len() > c.size {
+		last := c.order.Back()
+		c.order.Remove(last)
+		delete(c.entries, last.Value.(*cachedNode).id)
+	}
+}
+
+func (c *nodeCache) drop(id uint32) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.drops++
+	if e, ok := c.entries[id]; ok {
+		c.order.Remove(e)
+		delete(c.entries, id)
+	}
+}
+
+// WriteAt writes to the nodestore and drops the slots the write touches
+// from its cache. They are dropped once the write has landed, so a read
+// racing it is not cached. The write epoch moves on, so cached find
+// results from before the write are not used again.
+func (f *nodeFile) WriteAt(b []byte, off int64) (int, error) {
+	f.epoch++
+	n, err := f.File.WriteAt(b, off)
+	f.io.wrote(n)
+	if f.cache != nil && len(b) > 0 {
+		size := int64(f.codec.RecordSize())
+		first := max(off/size-1, 0)
+		last := (off+int64(len(b))-1)/size - 1
+		for slot := first; slot <= last; slot++ {
+			f.cache.drop(uint32(slot))
+		}
+	}
+	return n, err
+}
+
+// ReadAt reads from the nodestore, counting the read
+func (f *nodeFile) ReadAt(b []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(b, off)
+	f.io.read(n)
+	return n, err
+}
+
+// readNode reads node id from the nodestore, or from its cache. A slot
+// past the end is remembered as missing until it is written.
+func readNode(f *nodeFile, id uint32) (internal.Node, error) {
+	if f.cache != nil {
+		n, ok := f.cache.get(id)
+		f.io.cached(ok, false)
+		if ok {
+			return n.node, n.err
+		}
+	}
+	var version uint64
+	if f.cache != nil {
+		version = f.cache.version()
+	}
+	buf := make([]byte, f.codec.RecordSize())
+	_, err := f.ReadAt(buf, f.offset(id))
+	if err != nil {
+		if f.cache != nil && errors.Is(err, io.EOF) {
+			f.cache.put(&cachedNode{id: id, err: err}, version)
+		}
+		return internal.Node{}, err
+	}
+	node, err := decodeRecord(f, id, buf)
+	if err == nil && f.cache != nil {
+		f.cache.put(&cachedNode{id: id, node: node}, version)
+	}
+	return node, err
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// outputFormat is how read, find and analyze print their results: text
+// lines, an aligned table, JSON or CSV. It is set by the -format flag and
+// the format command.
+var outputFormat = "text"
+
+var outputFormats = []string{"text", "table", "json", "csv"}
+
+// formatValue is the -format flag
+type formatValue struct{}
+
+func (formatValue) String() string     { return outputFormat }
+func (formatValue) Set(s string) error { return setOutputFormat(s) }
+
+// setOutputFormat changes outputFormat, checking the name
+func setOutputFormat(format string) error {
+	for _, f := range outputFormats {
+		if f == format {
+			outputFormat = format
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output format %q, expected one of %s", format, strings.Join(outputFormats, ", "))
+}
+
+// column is one column of a result: its title in text and tables, and
+// its key in JSON and CSV
+type column struct {
+	title string
+	key   string
+}
+
+// field is one value of a result row. Text is how it is shown; raw is the
+// JSON it stands for, or nil for plain text.
+type field struct {
+	text string
+	raw  json.RawMessage
+}
+
+func textField(s string) field {
+	return field{text: s}
+}
+
+func numField[T int | int64 | uint32](n T) field {
+	s := strconv.FormatInt(int64(n), 10)
+	return field{text: s, raw: json.RawMessage(s)}
+}
+
+func boolField(b bool) field {
+	s := strconv.FormatBool(b)
+	return field{text: s, raw: json.RawMessage(s)}
+}
+
+// nullField is a missing value: empty when shown, null in JSON
+var nullField = field{raw: json.RawMessage("null")}
+
+// valueField shows a decoded JSON value the way fmt does
+func valueField(v any) field {
+	raw, _ := json.Marshal(v)
+	return field{text: fmt.Sprint(v), raw: raw}
+}
+
+// results is what a read command prints, in the chosen output format. The
+// footer is a summary line shown after text and tables.
+type results struct {
+	columns []column
+	rows    [][]field
+	footer  string
+}
+
+func (r *results) add(row ...field) {
+	r.rows = append(r.rows, row)
+}
+
+func (r *results) print() error {
+	switch outputFormat {
+	case "table":
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		titles := make([]string, len(r.columns))
+		for i, c := range r.columns {
+			titles[i] = c.title
+		}
+		fmt.Fprintln(w, strings.Join(titles, "\t"))
+		for _, row := range r.rows {
+			cells := make([]string, len(row))
+			for i, f := range row {
+				cells[i] = strings.NewReplacer("\t", " ", "\n", " ").Replace(f.text)
+			}
+			fmt.Fprintln(w, strings.Join(cells, "\t"))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	case "json":
+		var b strings.Builder
+		b.WriteString("[")
+		for i, row := range r.rows {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString("\n  {")
+			for j, f := range row {
+				if j > 0 {
+					b.WriteString(", ")
+				}
+				b.WriteString(jsonString(r.columns[j].key) + ": ")
+				if f.raw != nil {
+					b.Write(f.raw)
+				} else {
+					b.WriteString(jsonString(f.text))
+				}
+			}
+			b.WriteString("}")
+		}
+		b.WriteString("\n]\n")
+		_, err := os.Stdout.WriteString(b.String())
+		return err
+	case "csv":
+		w := csv.NewWriter(os.Stdout)
+		keys := make([]string, len(r.columns))
+		for i, c := range r.columns {
+			keys[i] = c.key
+		}
+		w.Write(keys)
+		for _, row := range r.rows {
+			cells := make([]string, len(row))
+			for i, f := range row {
+				cells[i] = f.text
+				var s string
+				if f.raw != nil && json.Unmarshal(f.raw, &s) == nil {
+					cells[i] = s
+				} else if string(f.raw) == "null" {
+					cells[i] = ""
+				}
+			}
+			w.Write(cells)
+		}
+		w.Flush()
+		return w.Error()
+	default:
+		for _, row := range r.rows {
+			parts := make([]string, len(row))
+			for i, f := range row {
+				parts[i] = r.columns[i].title + ": " + f.text
+			}
+			fmt.Println(strings.Join(parts, ", "))
+		}
+	}
+	if r.footer != "" && (outputFormat == "text" || outputFormat == "table") {
+		fmt.Println(r.footer)
+	}
+	return nil
+}
+
+// jsonString quotes s as a JSON string, leaving <, > and & as they are
+func jsonString(s string) string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// nodeResults returns the results of a command listing nodes, with a UUID
+// column if uuids is not nil
+func nodeResults(uuids *uuidIndex) *results {
+	r := &results{columns: []column{{"Node ID", "id"}, {"Handle", "handle"}}}
+	if uuids != nil {
+		r.columns = append(r.columns, column{"UUID", "uuid"})
+	}
+	r.columns = append(r.columns, column{"Value", "value"})
+	return r
+}
+
+// addNode adds a row for node to the results of nodeResults
+func (r *results) addNode(store *Store, node internal.Node, uuids *uuidIndex) error {
+	shown, err := showValue(store, node)
+	if err != nil {
+		return err
+	}
+	if err := opMemory.charge(len(shown)); err != nil {
+		return err
+	}
+	value := textField(shown)
+	if !isBlob(node.Value) {
+		value.raw = asJSON([]byte(shown))
+	}
+	row := []field{numField(node.ID), textField(Handle{node.ID, node.Gen}.String())}
+	if uuids != nil {
+		row = append(row, textField(uuids.uuids[node.ID].String()))
+	}
+	r.add(append(row, value)...)
+	return nil
+}
+
+// mutation is the result of an insert, update or delete: the node written,
+// its handle after the write, and its value before and after as shown by
+// get. Writes answered from an idempotency key made none.
+type mutation struct {
+	kind     string // insert, update or delete
+	handle   Handle
+	previous string // blank for inserts
+	value    string // blank for deletes
+	uuid     string // assigned to an inserted node, if the store has UUIDs
+	// set when the write was made already under idempotency key
+	key      string
+	replayed bool
+}
+
+// print reports the write in the output format. Text keeps one line per
+// write, and a replayed write only says so; the other formats print a
+// row, with the affected node count.
+func (m mutation) print() error {
+	if outputFormat == "text" {
+		if m.replayed {
+			past := map[string]string{"insert": "inserted", "update": "updated"}[m.kind]
+			fmt.Printf("Already %s under key %s: node %s\n", past, m.key, m.handle)
+			return nil
+		}
+		if m.uuid != "" {
+			fmt.Println("Assigned UUID:", m.uuid)
+		}
+		switch m.kind {
+		case "insert":
+			fmt.Printf("Inserted node ID: %d, Handle: %s, Value: %s\n", m.handle.ID, m.handle, m.value)
+		case "update":
+			fmt.Printf("Updated node ID: %d, Handle: %s, Value: %s, Previous value: %s\n", m.handle.ID, m.handle, m.value, m.previous)
+		case "delete":
+			fmt.Printf("Deleted node ID: %d, Handle: %s, Previous value: %s\n", m.handle.ID, m.handle, m.previous)
+		}
+		return nil
+	}
+	shown := func(s string) field {
+		if s == "" {
+			return nullField
+		}
+		return field{text: s, raw: asJSON([]byte(s))}
+	}
+	affected := 1
+	if m.replayed {
+		affected = 0
+	}
+	r := &results{columns: []column{{"Write", "op"}, {"Node ID", "id"}, {"Handle", "handle"}, {"Value", "value"}, {"Previous value", "previous"}, {"UUID", "uuid"}, {"Replayed", "replayed"}, {"Affected", "affected"}}}
+	r.add(textField(m.kind), numField(m.handle.ID), textField(m.handle.String()), shown(m.value), shown(m.previous), shown(m.uuid), boolField(m.replayed), numField(affected))
+	return r.print()
+}
+
+// printAffected reports a write to many nodes, such as update where, in
+// the output format
+func printAffected(kind string, n int) error {
+	if outputFormat == "text" {
+		past := map[string]string{"update": "Updated", "delete": "Deleted"}[kind]
+		fmt.Printf("%s %d nodes\n", past, n)
+		return nil
+	}
+	r := &results{columns: []column{{"Write", "op"}, {"Affected", "affected"}}}
+	r.add(textField(kind), numField(n))
+	return r.print()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nabeeladzan/peridot/internal"
+	"github.com/nabeeladzan/peridot/internal/codec"
+)
+
+// Values that do not fit in a node are appended to the overflow store as a
+// 4-byte length followed by the data. The node then holds a reference:
+//
+//	0 1 byte   overflowTag
+//	1 8 bytes  offset in the overflow store
+//	9 4 bytes  length
+//
+// Blobs are kept in the overflow store too, under blobTag. A JSON value
+// never starts with either tag. Overflow space is not reused; cloning a
+// store compacts it.
+const (
+	overflowTag = 0x01
+	blobTag     = 0x02
+)
+
+// overflowFile returns the overflow store of the store, opening it on first use
+func overflowFile(store *Store) (*os.File, error) {
+	if store.ovfstore != nil {
+		return store.ovfstore, nil
+	}
+	f, err := os.OpenFile(store.name+"_ovf.db", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s_ovf", store.name)
+	}
+	store.ovfstore = f
+	return f, nil
+}
+
+// appendOverflow writes data at the end of f and returns a node payload
+// referring to it
+func appendOverflow(f *os.File, data []byte) ([64]byte, error) {
+	var ref [64]byte
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return ref, err
+	}
+	buf := make([]byte, 4+len(data))
+	codec.Order.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+	if _, err := f.WriteAt(buf, offset); err != nil {
+		return ref, err
+	}
+
+	ref[0] = overflowTag
+	codec.Order.PutUint64(ref[1:], uint64(offset))
+	codec.Order.PutUint32(ref[9:], uint32(len(data)))
+	return ref, nil
+}
+
+// readOverflow reads the data a node payload refers to. A reference past
+// the end of the overflow store is corrupt, and nothing is allocated for
+// it.
+func readOverflow(f *os.File, ref [64]byte) ([]byte, error) {
+	offset := int64(codec.Order.Uint64(ref[1:]))
+	length := codec.Order.Uint32(ref[9:])
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	// compared without adding to offset, which may be near the largest int64
+	if offset < 0 || offset > fi.Size()-4-int64(length) {
+		return nil, fmt.Errorf("%w: overflow value at %d of %d bytes runs past the end of the overflow store", codec.ErrCorrupt, offset, length)
+	}
+	buf := make([]byte, 4+int(length))
+	if _, err := f.ReadAt(buf, offset); err != nil {
+		return nil, fmt.Errorf("overflow value at %d: %v", offset, err)
+	}
+	if codec.Order.Uint32(buf) != length {
+		return nil, fmt.Errorf("%w: overflow value at %d", codec.ErrCorrupt, offset)
+	}
+	return buf[4:], nil
+}
+
+// isOverflow reports whether a node payload refers to the overflow store
+func isOverflow(value [64]byte) bool {
+	return value[0] == overflowTag || value[0] == blobTag
+}
+
+// moveOverflow copies the overflowed value of a node of src into f and
+// returns the payload referring to the copy
+func moveOverflow(src *Store, f *os.File, value [64]byte) ([64]byte, error) {
+	in, err := overflowFile(src)
+	if err != nil {
+		return value, err
+	}
+	data, err := readOverflow(in, value)
+	if err != nil {
+		return value, err
+	}
+	ref, err := appendOverflow(f, data)
+	if err != nil {
+		return value, err
+	}
+	ref[0] = value[0]
+	return ref, nil
+}
+
+// storeValue returns the node payload for data, moving it to the overflow
+// store if it does not fit in the node
+func storeValue(store *Store, data []byte) ([64]byte, error) {
+	var fixed [64]byte
+	if len(data) <= len(fixed) {
+		copy(fixed[:], data)
+		return fixed, nil
+	}
+	f, err := overflowFile(store)
+	if err != nil {
+		return fixed, err
+	}
+	return appendOverflow(f, data)
+}
+
+// overflowPayload returns how many bytes storing data adds to the overflow
+// store, none if it fits in the node
+func overflowPayload(data []byte) int64 {
+	if len(data) <= 64 {
+		return 0
+	}
+	return 4 + int64(len(data))
+}
+
+// overflowSize returns the size of the overflow store of the store, 0 if
+// it has none yet
+func overflowSize(store *Store) (int64, error) {
+	if store.ovfstore != nil {
+		fi, err := store.ovfstore.Stat()
+		if err != nil {
+			return 0, err
+		}
+		return fi.Size(), nil
+	}
+	fi, err := os.Stat(store.name + "_ovf.db")
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// nodeValue returns the full value of an in-use node of the store
+func nodeValue(store *Store, node internal.Node) ([]byte, error) {
+	if !isOverflow(node.Value) {
+		return bytes.TrimRight(node.Value[:], "\x00"), nil
+	}
+	f, err := overflowFile(store)
+	if err != nil {
+		return nil, err
+	}
+	return readOverflow(f, node.Value)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nabeeladzan/peridot/internal/codec"
+)
+
+// FuzzReadOverflow reads values from arbitrary overflow stores through
+// arbitrary references. A reference the store cannot back must fail with
+// an error, without a panic or allocating more than the store holds.
+func FuzzReadOverflow(f *testing.F) {
+	dir := f.TempDir()
+	seed, err := os.Create(filepath.Join(dir, "seed_ovf.db"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, data := range [][]byte{[]byte(`{"a":1}`), bytes.Repeat([]byte("x"), 100), {}} {
+		ref, err := appendOverflow(seed, data)
+		if err != nil {
+			f.Fatal(err)
+		}
+		contents, err := os.ReadFile(seed.Name())
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(contents, codec.Order.Uint64(ref[1:]), codec.Order.Uint32(ref[9:]))
+	}
+	seed.Close()
+	// an offset so large that adding the length wraps around
+	f.Add([]byte{}, uint64(1<<63-1), uint32(1<<32-1))
+
+	f.Fuzz(func(t *testing.T, contents []byte, offset uint64, length uint32) {
+		path := filepath.Join(t.TempDir(), "fuzz_ovf.db")
+		if err := os.WriteFile(path, contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		var ref [64]byte
+		ref[0] = overflowTag
+		codec.Order.PutUint64(ref[1:], offset)
+		codec.Order.PutUint32(ref[9:], length)
+		data, err := readOverflow(file, ref)
+		if err != nil {
+			return
+		}
+		if uint32(len(data)) != length {
+			t.Fatalf("read %d bytes for a value of %d", len(data), length)
+		}
+		if !bytes.Equal(data, contents[offset+4:offset+4+uint64(length)]) {
+			t.Fatalf("read %q, the store holds %q", data, contents[offset+4:offset+4+uint64(length)])
+		}
+	})
+}
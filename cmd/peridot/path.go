@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// pathStep is one step of a path into a JSON document: an object key, or
+// an array index when key is empty
+type pathStep struct {
+	key   string
+	index int
+}
+
+// parsePath parses paths like $.address.city or $.tags[0]. "$" alone is
+// the whole value; a path without the $ starts at a top-level property.
+func parsePath(s string) ([]pathStep, error) {
+	rest, ok := strings.CutPrefix(s, "$")
+	if !ok {
+		rest = "." + s
+	}
+	var path []pathStep
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key := rest[1 : end+1]
+			if key == "" {
+				return nil, fmt.Errorf("invalid path %q, empty key", s)
+			}
+			path = append(path, pathStep{key: key})
+			rest = rest[end+1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q, missing ]", s)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid path %q, bad index %q", s, rest[1:end])
+			}
+			path = append(path, pathStep{index: index})
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid path %q at %q", s, rest)
+		}
+	}
+	return path, nil
+}
+
+// lookupPath follows path into a decoded JSON value. The bool is false if
+// the path does not exist in v.
+func lookupPath(v any, path []pathStep) (any, bool) {
+	for _, step := range path {
+		if step.key != "" {
+			obj, ok := v.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if v, ok = obj[step.key]; !ok {
+				return nil, false
+			}
+			continue
+		}
+		arr, ok := v.([]any)
+		if !ok || step.index >= len(arr) {
+			return nil, false
+		}
+		v = arr[step.index]
+	}
+	return v, true
+}
+
+// setPath returns v with the value at path replaced by value. Missing keys
+// are added, as objects where the path goes on; a missing array index is
+// an error.
+func setPath(v any, path []pathStep, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	step := path[0]
+	if step.key != "" {
+		obj, ok := v.(map[string]any)
+		if v == nil {
+			obj, ok = make(map[string]any), true
+		}
+		if !ok {
+			return nil, fmt.Errorf("cannot set key %q of a non-object", step.key)
+		}
+		child, err := setPath(obj[step.key], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		obj[step.key] = child
+		return obj, nil
+	}
+	arr, ok := v.([]any)
+	if !ok || step.index >= len(arr) {
+		return nil, fmt.Errorf("no index %d to set", step.index)
+	}
+	child, err := setPath(arr[step.index], path[1:], value)
+	if err != nil {
+		return nil, err
+	}
+	arr[step.index] = child
+	return arr, nil
+}
+
+// cutSet splits the filter of update where ... set from its assignment,
+// at the last SET
+func cutSet(s string) (filter, assignment string, ok bool) {
+	for i := len(s) - 5; i >= 0; i-- {
+		if strings.EqualFold(s[i:i+5], " set ") {
+			return s[:i], s[i+5:], true
+		}
+	}
+	return s, "", false
+}
+
+// parseAssignment parses the path = value of update where ... set
+func parseAssignment(s string) ([]pathStep, any, error) {
+	lhs, rhs, ok := strings.Cut(s, "=")
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid assignment %q, expected path = value", s)
+	}
+	path, err := parsePath(strings.TrimSpace(lhs))
+	if err != nil {
+		return nil, nil, err
+	}
+	return path, parseOperand(strings.TrimSpace(rhs)), nil
+}
+
+// assign returns the handle of node id and its value with value set at
+// path. Numbers elsewhere in the document are kept as they were written.
+func assign(store *Store, id uint32, path []pathStep, value any) (string, string, error) {
+	node, err := readNode(store.nodestore, id)
+	if err != nil {
+		return "", "", err
+	}
+	old, err := nodeValue(store, node)
+	if err != nil {
+		return "", "", err
+	}
+	dec := json.NewDecoder(bytes.NewReader(old))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return "", "", fmt.Errorf("value is not JSON: %v", err)
+	}
+	if v, err = setPath(v, path, value); err != nil {
+		return "", "", err
+	}
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", "", err
+	}
+	return Handle{id, node.Gen}.String(), strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// parseOperand reads the right-hand side of a filter as JSON, falling back
+// to a plain string so Oslo and "Oslo" mean the same
+func parseOperand(s string) any {
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return s
+	}
+	return v
+}
+
+// matchOp applies a comparison operator. Ordering is only defined between
+// two numbers or two strings.
+func matchOp(v any, op string, operand any) (bool, error) {
+	switch op {
+	case "=":
+		return reflect.DeepEqual(v, operand), nil
+	case "!=":
+		return !reflect.DeepEqual(v, operand), nil
+	case "<", "<=", ">", ">=":
+	default:
+		return false, fmt.Errorf("unknown operator %q", op)
+	}
+
+	var cmp int
+	switch a := v.(type) {
+	case float64:
+		b, ok := operand.(float64)
+		if !ok {
+			return false, nil
+		}
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		}
+	case string:
+		b, ok := operand.(string)
+		if !ok {
+			return false, nil
+		}
+		cmp = strings.Compare(a, b)
+	default:
+		return false, nil
+	}
+	switch op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+// predicate is one comparison of a filter
+type predicate struct {
+	path    []pathStep
+	op      string
+	operand any
+}
+
+// prop returns the top-level property a predicate is on, or "" if its
+// path goes deeper
+func (p predicate) prop() string {
+	if len(p.path) != 1 {
+		return ""
+	}
+	return p.path[0].key
+}
+
+// matches reports whether a decoded document satisfies the predicate
+func (p predicate) matches(doc any) (bool, error) {
+	v, ok := lookupPath(doc, p.path)
+	if !ok {
+		return false, nil
+	}
+	return matchOp(v, p.op, p.operand)
+}
+
+// splitFilter splits a filter into words, keeping double quoted strings
+// together with their quotes
+func splitFilter(s string) ([]string, error) {
+	var words []string
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		end := strings.IndexAny(s, " \t")
+		if s[0] == '"' {
+			end = 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated string in %q", s)
+			}
+			end++
+		}
+		if end < 0 {
+			end = len(s)
+		}
+		words = append(words, s[:end])
+		s = s[end:]
+	}
+	return words, nil
+}
+
+// parseFilter parses predicates joined by AND, e.g.
+// $.address.city = "Oslo" AND age >= 30. A path without $ names a
+// top-level property. "path BETWEEN a AND b" is short for
+// "path >= a AND path <= b".
+func parseFilter(s string) ([]predicate, error) {
+	words, err := splitFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	var preds []predicate
+	for {
+		if len(words) < 3 {
+			return nil, fmt.Errorf("invalid filter %q, expected path operator value", s)
+		}
+		path, err := parsePath(words[0])
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(words[1], "BETWEEN") {
+			if len(words) < 5 || !strings.EqualFold(words[3], "AND") {
+				return nil, fmt.Errorf("invalid filter %q, expected path BETWEEN a AND b", s)
+			}
+			preds = append(preds,
+				predicate{path, ">=", parseOperand(words[2])},
+				predicate{path, "<=", parseOperand(words[4])})
+			words = words[5:]
+		} else {
+			switch words[1] {
+			case "=", "!=", "<", "<=", ">", ">=":
+			default:
+				return nil, fmt.Errorf("unknown operator %q", words[1])
+			}
+			preds = append(preds, predicate{path, words[1], parseOperand(words[2])})
+			words = words[3:]
+		}
+		if len(words) == 0 {
+			return preds, nil
+		}
+		if !strings.EqualFold(words[0], "AND") {
+			return nil, fmt.Errorf("invalid filter %q, expected AND at %q", s, words[0])
+		}
+		words = words[1:]
+	}
+}
+
+// findNodes returns the in-use nodes whose value satisfies every
+// predicate, and describes how they were found
+func findNodes(store *Store, preds []predicate) ([]internal.Node, string, error) {
+	// an equality on every property of an index is answered by the bloom
+	// filter when no node has that key
+	for _, def := range store.meta.Indexes {
+		key := make([]any, len(def.Properties))
+		covered := true
+		for i, prop := range def.Properties {
+			if key[i], covered = equalityOn(preds, prop); !covered {
+				break
+			}
+		}
+		if !covered {
+			continue
+		}
+		ok, err := bloomMayContain(store, indexedKey(def.Properties, key))
+		if err != nil {
+			return nil, "", err
+		}
+		if !ok {
+			return nil, "bloom filter", nil
+		}
+	}
+
+	idxs, err := loadIndexes(store)
+	if err != nil {
+		return nil, "", err
+	}
+	fi, err := store.nodestore.Stat()
+	if err != nil {
+		return nil, "", err
+	}
+	var nodes []internal.Node
+	plan := planScan(idxs, preds, store.nodestore.slots(fi.Size()), store.meta.Stats)
+	if plan.Index != nil {
+		for _, id := range plan.ids() {
+			node, err := readNode(store.nodestore, id)
+			if err != nil {
+				return nil, "", err
+			}
+			nodes = append(nodes, node)
+		}
+	} else if nodes, err = scanStore(store.nodestore, opMemory); err != nil {
+		return nil, "", err
+	}
+
+	var found []internal.Node
+	for _, node := range nodes {
+		if node.InUse != 1 || isBlob(node.Value) {
+			continue
+		}
+		value, err := nodeValue(store, node)
+		if err != nil {
+			return nil, "", err
+		}
+		var doc any
+		if err := json.Unmarshal(value, &doc); err != nil {
+			continue
+		}
+		match := true
+		for _, p := range preds {
+			if match, err = p.matches(doc); err != nil {
+				return nil, "", err
+			}
+			if !match {
+				break
+			}
+		}
+		if match {
+			found = append(found, node)
+		}
+	}
+	return found, plan.String(), nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nabeeladzan/peridot/internal"
+	"github.com/nabeeladzan/peridot/internal/codec"
+)
+
+// quarantine makes scans set aside records that cannot be read instead of
+// failing. It is set by the -quarantine flag.
+var quarantine bool
+
+// A quarantined record is appended to the corrupt-records file of its store
+// as the 4-byte slot ID followed by the record as it was on disk, so it can
+// be looked at or pieced back together by hand. The slot then reads as
+// free, and check finds it missing from the free list and can relink it.
+
+// corruptFile returns the path of the corrupt-records file of a nodestore
+func corruptFile(f *nodeFile) string {
+	return strings.TrimSuffix(f.Name(), ".db") + "_corrupt.db"
+}
+
+// decodeRecord decodes the record read from slot id. Besides what the
+// codec rejects, a record in use must carry the ID of its slot.
+func decodeRecord(f *nodeFile, id uint32, buf []byte) (internal.Node, error) {
+	node, err := f.codec.DecodeNode(buf)
+	if err != nil {
+		return node, err
+	}
+	if node.InUse == 1 && node.ID != id {
+		return node, fmt.Errorf("%w: record in slot %d is for node %d", codec.ErrCorrupt, id, node.ID)
+	}
+	return node, nil
+}
+
+// quarantineRecord copies the raw record of slot id to the corrupt-records
+// file, unless the same record is there already from an earlier scan
+func quarantineRecord(f *nodeFile, id uint32, buf []byte) error {
+	path := corruptFile(f)
+	entry := make([]byte, 4+len(buf))
+	codec.Order.PutUint32(entry, id)
+	copy(entry[4:], buf)
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for off := 0; off+len(entry) <= len(data); off += len(entry) {
+		if bytes.Equal(data[off:off+len(entry)], entry) {
+			return nil
+		}
+	}
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s", path)
+	}
+	if _, err := out.Write(entry); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Quarantined corrupt record in slot %d to %s\n", id, path)
+	return out.Close()
+}
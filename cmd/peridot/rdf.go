@@ -0,0 +1,509 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Vocabularies used in the RDF view of a store
+const (
+	rdfNS     = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xsdNS     = "http://www.w3.org/2001/XMLSchema#"
+	owlNS     = "http://www.w3.org/2002/07/owl#"
+	peridotNS = "urn:peridot:"
+)
+
+// triple is one RDF statement, each term in N-Triples syntax
+type triple struct {
+	s, p, o string
+}
+
+func (t triple) String() string {
+	return t.s + " " + t.p + " " + t.o + " ."
+}
+
+// storeNS is the namespace of the nodes, types and properties of a store:
+// node n of store s is urn:peridot:s:node:n, property name is
+// urn:peridot:s:prop:name and node type t is urn:peridot:s:type:t
+func storeNS(store *Store) string {
+	return peridotNS + url.PathEscape(store.name) + ":"
+}
+
+func iri(s string) string {
+	return "<" + s + ">"
+}
+
+// literal writes s as an N-Triples literal, with datatype dt unless it is
+// a plain string
+func literal(s string, dt string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	if dt != "" && dt != xsdNS+"string" {
+		b.WriteString("^^" + iri(dt))
+	}
+	return b.String()
+}
+
+// numberLiteral writes whole numbers as xsd:integer and the rest as
+// xsd:double in canonical form, so the same number always gives the
+// same term
+func numberLiteral(f float64) string {
+	if f == math.Trunc(f) && math.Abs(f) < 1e15 {
+		return literal(strconv.FormatInt(int64(f), 10), xsdNS+"integer")
+	}
+	s := strconv.FormatFloat(f, 'E', -1, 64)
+	mantissa, exp, _ := strings.Cut(s, "E")
+	if !strings.Contains(mantissa, ".") {
+		mantissa += ".0"
+	}
+	e, _ := strconv.Atoi(exp)
+	return literal(mantissa+"E"+strconv.Itoa(e), xsdNS+"double")
+}
+
+// valueTerm maps a JSON value to a literal. Objects, and arrays nested in
+// arrays, become rdf:JSON literals of their JSON text.
+func valueTerm(v any) string {
+	switch v := v.(type) {
+	case string:
+		return literal(v, "")
+	case float64:
+		return numberLiteral(v)
+	case bool:
+		return literal(strconv.FormatBool(v), xsdNS+"boolean")
+	}
+	data, _ := json.Marshal(v)
+	return literal(string(data), rdfNS+"JSON")
+}
+
+// storeTriples maps the in-use nodes of a store to triples. Each node has
+// an rdf:type of its node type and a peridot:gen; documents have a triple
+// per top-level property, or per element for arrays, and other values a
+// peridot:value. Blobs are xsd:base64Binary values, and UUIDs are linked
+// with owl:sameAs. Nulls are left out. It returns the triples and the
+// number of nodes.
+func storeTriples(store *Store) ([]triple, int, error) {
+	rows, err := exportRows(store)
+	if err != nil {
+		return nil, 0, err
+	}
+	return rowTriples(store, rows), len(rows), nil
+}
+
+// rowTriples maps rows to triples as storeTriples does
+func rowTriples(store *Store, rows []exportRow) []triple {
+	ns := storeNS(store)
+	var triples []triple
+	for _, row := range rows {
+		s := iri(ns + "node:" + strconv.FormatUint(uint64(row.node.ID), 10))
+		add := func(p, o string) {
+			triples = append(triples, triple{s, p, o})
+		}
+		add(iri(rdfNS+"type"), iri(ns+"type:"+strconv.Itoa(int(row.node.Type))))
+		add(iri(peridotNS+"gen"), numberLiteral(float64(row.node.Gen)))
+		if row.uuid != "" {
+			add(iri(owlNS+"sameAs"), iri("urn:uuid:"+row.uuid))
+		}
+		switch {
+		case row.blob != nil:
+			add(iri(peridotNS+"value"), literal(base64.StdEncoding.EncodeToString(row.blob), xsdNS+"base64Binary"))
+		case row.doc != nil:
+			names := make([]string, 0, len(row.doc))
+			for name := range row.doc {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				p := iri(ns + "prop:" + url.PathEscape(name))
+				switch v := row.doc[name].(type) {
+				case nil:
+				case []any:
+					for _, e := range v {
+						if e != nil {
+							add(p, valueTerm(e))
+						}
+					}
+				default:
+					add(p, valueTerm(v))
+				}
+			}
+		default:
+			var v any
+			if json.Unmarshal(row.value, &v) == nil && v != nil {
+				add(iri(peridotNS+"value"), valueTerm(v))
+			}
+		}
+	}
+	return triples
+}
+
+// exportNTriples writes the RDF view of the nodes opts selects to w as
+// N-Triples
+func exportNTriples(store *Store, opts exportOptions, w io.Writer) (int, error) {
+	rows, err := selectRows(store, opts)
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range rowTriples(store, rows) {
+		if _, err := fmt.Fprintln(w, t); err != nil {
+			return 0, err
+		}
+	}
+	return len(rows), nil
+}
+
+// A triple query is a SPARQL basic graph pattern: triple patterns joined
+// by ".", with ?variables, <IRIs>, prefixed names, "literals", bare
+// numbers and booleans, and "a" for rdf:type. It may be wrapped in
+// SELECT ?vars WHERE { ... } to choose the variables printed. The
+// prefixes rdf, xsd, owl and peridot are known, and node, type and prop
+// name the nodes, types and properties of the store being queried.
+type tripleQuery struct {
+	vars     []string
+	patterns []triple
+}
+
+// queryTokens splits a query into terms and the punctuation { } and .
+func queryTokens(query string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '{' || c == '}' || c == '.':
+			tokens = append(tokens, string(c))
+			i++
+		case c == '<':
+			end := strings.IndexByte(query[i:], '>')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated IRI %s", query[i:])
+			}
+			tokens = append(tokens, query[i:i+end+1])
+			i += end + 1
+		case c == '"':
+			j := i + 1
+			for ; j < len(query) && query[j] != '"'; j++ {
+				if query[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(query) {
+				return nil, fmt.Errorf("unterminated literal %s", query[i:])
+			}
+			j++
+			// a datatype or language tag belongs to the literal
+			if strings.HasPrefix(query[j:], "^^<") {
+				end := strings.IndexByte(query[j:], '>')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated IRI %s", query[j+2:])
+				}
+				j += end + 1
+			} else if strings.HasPrefix(query[j:], "^^") || strings.HasPrefix(query[j:], "@") {
+				for j < len(query) && !strings.ContainsRune(" \t\r\n{}", rune(query[j])) {
+					j++
+				}
+			}
+			tokens = append(tokens, strings.TrimSuffix(query[i:j], "."))
+			if strings.HasSuffix(query[i:j], ".") {
+				tokens = append(tokens, ".")
+			}
+			i = j
+		default:
+			j := i
+			for j < len(query) && !strings.ContainsRune(" \t\r\n{}", rune(query[j])) {
+				j++
+			}
+			// a dot ending a word ends the pattern
+			word := query[i:j]
+			if len(word) > 1 && strings.HasSuffix(word, ".") {
+				tokens = append(tokens, word[:len(word)-1], ".")
+			} else {
+				tokens = append(tokens, word)
+			}
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+// parseTripleQuery parses a query against the RDF view of store
+func parseTripleQuery(store *Store, query string) (*tripleQuery, error) {
+	tokens, err := queryTokens(query)
+	if err != nil {
+		return nil, err
+	}
+	q := &tripleQuery{}
+	if len(tokens) > 0 && strings.EqualFold(tokens[0], "SELECT") {
+		tokens = tokens[1:]
+		for len(tokens) > 0 && strings.HasPrefix(tokens[0], "?") {
+			q.vars = append(q.vars, tokens[0])
+			tokens = tokens[1:]
+		}
+		if len(tokens) > 0 && tokens[0] == "*" {
+			tokens = tokens[1:]
+		}
+		if len(tokens) < 3 || !strings.EqualFold(tokens[0], "WHERE") || tokens[1] != "{" || tokens[len(tokens)-1] != "}" {
+			return nil, fmt.Errorf("expected SELECT ?vars WHERE { patterns }")
+		}
+		tokens = tokens[2 : len(tokens)-1]
+	}
+
+	var terms []string
+	for i := 0; i <= len(tokens); i++ {
+		if i < len(tokens) && tokens[i] != "." {
+			term, err := queryTerm(store, tokens[i])
+			if err != nil {
+				return nil, err
+			}
+			terms = append(terms, term)
+			continue
+		}
+		if len(terms) == 0 {
+			continue
+		}
+		if len(terms) != 3 {
+			return nil, fmt.Errorf("a pattern needs a subject, predicate and object, got %s", strings.Join(terms, " "))
+		}
+		q.patterns = append(q.patterns, triple{terms[0], terms[1], terms[2]})
+		terms = nil
+	}
+	if len(q.patterns) == 0 {
+		return nil, fmt.Errorf("no triple patterns given")
+	}
+	if q.vars == nil {
+		seen := make(map[string]bool)
+		for _, p := range q.patterns {
+			for _, term := range []string{p.s, p.p, p.o} {
+				if isVar(term) && !seen[term] {
+					seen[term] = true
+					q.vars = append(q.vars, term)
+				}
+			}
+		}
+	}
+	return q, nil
+}
+
+func isVar(term string) bool {
+	return strings.HasPrefix(term, "?")
+}
+
+// queryTerm turns a token into a variable or a term in the form
+// storeTriples writes it, so terms compare as strings
+func queryTerm(store *Store, token string) (string, error) {
+	switch {
+	case isVar(token):
+		if len(token) == 1 {
+			return "", fmt.Errorf("variable has no name")
+		}
+		return token, nil
+	case token == "a":
+		return iri(rdfNS + "type"), nil
+	case token == "true" || token == "false":
+		return literal(token, xsdNS+"boolean"), nil
+	case strings.HasPrefix(token, "<"):
+		return token, nil
+	case strings.HasPrefix(token, `"`):
+		return queryLiteral(store, token)
+	}
+	if f, err := strconv.ParseFloat(token, 64); err == nil {
+		return numberLiteral(f), nil
+	}
+	expanded, err := expandName(store, token)
+	if err != nil {
+		return "", err
+	}
+	return iri(expanded), nil
+}
+
+// expandName expands a prefixed name such as prop:age or xsd:integer
+func expandName(store *Store, name string) (string, error) {
+	prefix, local, ok := strings.Cut(name, ":")
+	if !ok {
+		return "", fmt.Errorf("unknown term %s, expected ?var, <iri>, prefix:name or a literal", name)
+	}
+	switch prefix {
+	case "rdf":
+		return rdfNS + local, nil
+	case "xsd":
+		return xsdNS + local, nil
+	case "owl":
+		return owlNS + local, nil
+	case "peridot":
+		return peridotNS + local, nil
+	case "node", "type":
+		return storeNS(store) + prefix + ":" + local, nil
+	case "prop":
+		return storeNS(store) + "prop:" + url.PathEscape(local), nil
+	}
+	return "", fmt.Errorf("unknown prefix %s", prefix)
+}
+
+// queryLiteral parses a quoted literal with an optional datatype.
+// Numbers are rewritten in canonical form.
+func queryLiteral(store *Store, token string) (string, error) {
+	end := strings.LastIndexByte(token, '"')
+	text, err := unescapeLiteral(token[1:end])
+	if err != nil {
+		return "", err
+	}
+	suffix := token[end+1:]
+	switch {
+	case suffix == "":
+		return literal(text, ""), nil
+	case strings.HasPrefix(suffix, "@"):
+		return `"` + token[1:end] + `"` + suffix, nil
+	}
+	dt := strings.TrimPrefix(suffix, "^^")
+	if strings.HasPrefix(dt, "<") {
+		dt = strings.Trim(dt, "<>")
+	} else if dt, err = expandName(store, dt); err != nil {
+		return "", err
+	}
+	switch dt {
+	case xsdNS + "integer", xsdNS + "decimal", xsdNS + "double", xsdNS + "float":
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid number %q", text)
+		}
+		return numberLiteral(f), nil
+	}
+	return literal(text, dt), nil
+}
+
+// unescapeLiteral undoes the escapes of an N-Triples string
+func unescapeLiteral(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 >= len(s) {
+			return "", fmt.Errorf("literal ends in a backslash")
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case '"', '\\', '\'':
+			b.WriteByte(s[i])
+		case 'u', 'U':
+			n := 4
+			if s[i] == 'U' {
+				n = 8
+			}
+			if i+n >= len(s) {
+				return "", fmt.Errorf("short \\%c escape", s[i])
+			}
+			r, err := strconv.ParseUint(s[i+1:i+1+n], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(r)) {
+				return "", fmt.Errorf("invalid escape \\%s", s[i:i+1+n])
+			}
+			b.WriteRune(rune(r))
+			i += n
+		default:
+			return "", fmt.Errorf("invalid escape \\%c", s[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// match runs the query over triples: each pattern in turn extends the
+// solutions so far with the triples that agree with their bindings. The
+// solutions of every step are charged to mem, as a few patterns can join
+// into far more solutions than there are triples.
+func (q *tripleQuery) match(triples []triple, mem *memBudget) ([]map[string]string, error) {
+	solutions := []map[string]string{{}}
+	for _, p := range q.patterns {
+		var next []map[string]string
+		for _, sol := range solutions {
+			for _, t := range triples {
+				b := bind(sol, p, t)
+				if b == nil {
+					continue
+				}
+				size := 0
+				for v, value := range b {
+					size += len(v) + len(value)
+				}
+				if err := mem.charge(size); err != nil {
+					return nil, err
+				}
+				next = append(next, b)
+			}
+		}
+		solutions = next
+	}
+	return solutions, nil
+}
+
+// bind returns sol extended to match pattern p to t, or nil if it does
+// not match
+func bind(sol map[string]string, p triple, t triple) map[string]string {
+	var added map[string]string
+	for _, pair := range [3][2]string{{p.s, t.s}, {p.p, t.p}, {p.o, t.o}} {
+		term, value := pair[0], pair[1]
+		if !isVar(term) {
+			if term != value {
+				return nil
+			}
+			continue
+		}
+		bound, ok := sol[term]
+		if !ok {
+			bound, ok = added[term]
+		}
+		if ok {
+			if bound != value {
+				return nil
+			}
+			continue
+		}
+		if added == nil {
+			added = make(map[string]string)
+		}
+		added[term] = value
+	}
+	out := make(map[string]string, len(sol)+len(added))
+	for k, v := range sol {
+		out[k] = v
+	}
+	for k, v := range added {
+		out[k] = v
+	}
+	return out
+}
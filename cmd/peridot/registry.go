@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// registry holds the stores the server knows of, by name. Stores are kept
+// by pointer, so a *Store handed out stays the registered one however the
+// registry changes, and every method takes the lock, so a registry can be
+// shared between goroutines. The stores themselves are not locked.
+type registry struct {
+	mu     sync.RWMutex
+	stores map[string]*Store
+	order  []string // names in the order the stores were added
+}
+
+func newRegistry() *registry {
+	return &registry{stores: make(map[string]*Store)}
+}
+
+// Get returns the named store
+func (r *registry) Get(name string) (*Store, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	store, ok := r.stores[name]
+	return store, ok
+}
+
+// Add registers store, unless a store of that name already is
+func (r *registry) Add(store *Store) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.stores[store.name]; ok {
+		return fmt.Errorf("store %s is already registered", store.name)
+	}
+	r.stores[store.name] = store
+	r.order = append(r.order, store.name)
+	return nil
+}
+
+// Remove forgets the named store and returns it
+func (r *registry) Remove(name string) (*Store, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	store, ok := r.stores[name]
+	if ok {
+		delete(r.stores, name)
+		r.order = slices.DeleteFunc(r.order, func(n string) bool { return n == name })
+	}
+	return store, ok
+}
+
+// List returns the stores in the order they were added
+func (r *registry) List() []*Store {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	stores := make([]*Store, len(r.order))
+	for i, name := range r.order {
+		stores[i] = r.stores[name]
+	}
+	return stores
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/nabeeladzan/peridot/internal"
+	"github.com/nabeeladzan/peridot/internal/codec"
+)
+
+// A bulk loader can reserve a block of node IDs before it writes any
+// nodes, so it knows the IDs of the nodes it will write while it prepares
+// them. The reserved slots are appended to the nodestore as free slots
+// left off the free list, so nothing else is written to them, and the
+// block is recorded in the metadata. An import record with an id fills a
+// reserved slot, and deleting its node empties the slot for the
+// reservation again; release puts the slots still empty on the free list.
+
+// reserved reports whether slot id is in a reserved block
+func reserved(meta *internal.StoreMeta, id uint32) bool {
+	for _, r := range meta.Reserved {
+		if id >= r.First && id <= r.Last {
+			return true
+		}
+	}
+	return false
+}
+
+// reserveIDs appends n reserved slots to the nodestore and returns the
+// block they make up
+func reserveIDs(store *Store, n int) (internal.IDRange, error) {
+	if n <= 0 {
+		return internal.IDRange{}, fmt.Errorf("invalid number of IDs %d, expected a positive number", n)
+	}
+	f := store.nodestore
+	fi, err := f.Stat()
+	if err != nil {
+		return internal.IDRange{}, err
+	}
+	first := f.slots(fi.Size())
+	// ^uint32(0) ends the free list, so it is never a node ID
+	if first+int64(n) > int64(^uint32(0)) {
+		return internal.IDRange{}, fmt.Errorf("cannot reserve %d IDs, the store has room for %d more nodes", n, int64(^uint32(0))-first)
+	}
+	size := f.codec.RecordSize()
+	ovf, err := overflowSize(store)
+	if err != nil {
+		return internal.IDRange{}, err
+	}
+	if max := store.meta.MaxBytes; max != 0 && fi.Size()+ovf+int64(n)*int64(size) > max {
+		return internal.IDRange{}, fmt.Errorf("store %s is limited to %d bytes: %w", store.name, max, ErrQuotaExceeded)
+	}
+	block := internal.IDRange{First: uint32(first), Last: uint32(first + int64(n) - 1)}
+
+	// written a batch at a time, to bound the buffer
+	for start := int64(block.First); start <= int64(block.Last); start += importBatch {
+		count := min(importBatch, int64(block.Last)-start+1)
+		buf := make([]byte, int(count)*size)
+		for i := range int(count) {
+			node := internal.Node{ID: uint32(start) + uint32(i)}
+			codec.Order.PutUint32(node.Value[0:], ^uint32(0))
+			if err := f.codec.EncodeNode(buf[i*size:(i+1)*size], node); err != nil {
+				return internal.IDRange{}, err
+			}
+		}
+		if _, err := f.WriteAt(buf, f.offset(uint32(start))); err != nil {
+			return internal.IDRange{}, err
+		}
+	}
+
+	meta := *store.meta
+	meta.Reserved = append(slices.Clone(meta.Reserved), block)
+	if err := writeMeta(store.name, &meta); err != nil {
+		return internal.IDRange{}, err
+	}
+	*store.meta = meta
+	return block, nil
+}
+
+// freeSlots returns the slots on the free list of the store
+func freeSlots(store *Store) (map[uint32]bool, error) {
+	listed := make(map[uint32]bool)
+	id, err := getFree(store.freestore)
+	if err != nil {
+		return nil, err
+	}
+	for id != ^uint32(0) {
+		if listed[id] {
+			return nil, fmt.Errorf("free list loops back to slot %d, run check", id)
+		}
+		listed[id] = true
+		node, err := readNode(store.nodestore, id)
+		if err != nil {
+			return nil, fmt.Errorf("free list slot %d: %w", id, err)
+		}
+		id = codec.Order.Uint32(node.Value[0:4])
+	}
+	return listed, nil
+}
+
+// releaseIDs drops every reservation of the store, putting the reserved
+// slots that are empty and not on the free list yet on it, and returns
+// how many. The reservations are dropped first, so if it fails part way
+// the slots left are free slots off the list, which check relinks,
+// rather than slots on the list that still count as reserved.
+func releaseIDs(store *Store) (int, error) {
+	listed, err := freeSlots(store)
+	if err != nil {
+		return 0, err
+	}
+	blocks := store.meta.Reserved
+	meta := *store.meta
+	meta.Reserved = nil
+	if err := writeMeta(store.name, &meta); err != nil {
+		return 0, err
+	}
+	*store.meta = meta
+
+	released := 0
+	for _, r := range blocks {
+		for id := r.First; ; id++ {
+			node, err := readNode(store.nodestore, id)
+			if err != nil {
+				return released, err
+			}
+			if node.InUse != 1 && !listed[id] {
+				if err := relinkFree(store, id); err != nil {
+					return released, err
+				}
+				released++
+			}
+			if id == r.Last {
+				break
+			}
+		}
+	}
+	return released, nil
+}
+
+// emptyReserved deletes the node in reserved slot id, leaving the slot off
+// the free list as reserveIDs does, so that only the reservation holds it
+func emptyReserved(store *Store, id uint32) error {
+	old, err := readNode(store.nodestore, id)
+	if err != nil || old.InUse != 1 {
+		return fmt.Errorf("node %d not found", id)
+	}
+	// the generation outlives the node, so handles to it go stale
+	node := internal.Node{ID: id, Gen: old.Gen + 1}
+	codec.Order.PutUint32(node.Value[0:], ^uint32(0))
+	f := store.nodestore
+	buf := make([]byte, f.codec.RecordSize())
+	if err := f.codec.EncodeNode(buf, node); err != nil {
+		return err
+	}
+	_, err = f.WriteAt(buf, f.offset(id))
+	return err
+}
+
+// fillReserved writes node into its reserved slot, which must still be
+// empty
+func fillReserved(store *Store, node internal.Node) error {
+	if !reserved(store.meta, node.ID) {
+		return fmt.Errorf("node ID %d is not reserved", node.ID)
+	}
+	old, err := readNode(store.nodestore, node.ID)
+	if err != nil {
+		return err
+	}
+	if old.InUse == 1 {
+		return fmt.Errorf("reserved node ID %d is already in use", node.ID)
+	}
+	// the slot is there already, so only the node limit applies
+	if err := checkNodeQuota(store); err != nil {
+		return err
+	}
+	node.InUse, node.Gen = 1, old.Gen
+	f := store.nodestore
+	buf := make([]byte, f.codec.RecordSize())
+	if err := f.codec.EncodeNode(buf, node); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(buf, f.offset(node.ID)); err != nil {
+		return err
+	}
+	return insertedNode(store, node.ID)
+}
+
+func comReserve(store *Store, action string, n int) error {
+	// Reserve a block of node IDs, release the reservations or list them
+	switch action {
+	case "list":
+		if len(store.meta.Reserved) == 0 {
+			fmt.Printf("Store %s has no reserved IDs\n", store.name)
+		}
+		for _, r := range store.meta.Reserved {
+			fmt.Printf("%d-%d\n", r.First, r.Last)
+		}
+		return nil
+	case "reserve", "release":
+		if dryRun {
+			return ErrDryRun
+		}
+		if err := writable(store); err != nil {
+			return err
+		}
+		if action == "release" {
+			released, err := releaseIDs(store)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Released %d unused IDs of %s\n", released, store.name)
+			return nil
+		}
+		block, err := reserveIDs(store, n)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Reserved IDs %d-%d of %s\n", block.First, block.Last, store.name)
+		return nil
+	}
+	return fmt.Errorf("unknown reserve action %q", action)
+}
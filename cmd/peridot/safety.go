@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// protect refuses commands that destroy data, and force runs them without
+// asking first. They are set by the -protect and -force flags.
+var (
+	protect bool
+	force   bool
+)
+
+// ErrProtected is returned by destructive commands when -protect is set
+var ErrProtected = errors.New("destructive commands are disabled, the server was started with -protect")
+
+// errNotConfirmed is returned when the user answers no to confirm
+var errNotConfirmed = errors.New("not confirmed")
+
+// confirm guards a destructive command, described by what: it is refused
+// with -protect, runs with -force, and otherwise asks first
+func confirm(what string) error {
+	if protect {
+		return ErrProtected
+	}
+	if force {
+		return nil
+	}
+	var answer string
+	ask(fmt.Sprintf("This will %s and cannot be undone. Continue? (y/n): ", what))
+	fmt.Fscanln(stdin, &answer)
+	if answer != "y" {
+		return errNotConfirmed
+	}
+	return nil
+}
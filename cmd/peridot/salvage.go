@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nabeeladzan/peridot/internal"
+	"github.com/nabeeladzan/peridot/internal/codec"
+)
+
+// badRange is a run of slots of a damaged nodestore that could not be
+// salvaged, with why the first of them could not
+type badRange struct {
+	from, to uint32
+	why      string
+}
+
+func (r badRange) String() string {
+	if r.from == r.to {
+		return fmt.Sprintf("slot %d: %s", r.from, r.why)
+	}
+	return fmt.Sprintf("slots %d-%d: %s", r.from, r.to, r.why)
+}
+
+// salvageStore copies every in-use record of the nodestore of store name
+// that can still be read into a new store named into, trusting neither the
+// header nor the free list of the old one. If the header cannot be read,
+// records are decoded with c and laid out as if it were there; a format 0
+// store is to be migrated, not salvaged. Node IDs are kept. Slots that
+// cannot be read are left free, and their raw records are quarantined in
+// the new store. It returns the number of nodes salvaged and the slots
+// that were not.
+func salvageStore(name, into string, c codec.Codec) (_ int, bad []badRange, err error) {
+	src, err := os.Open(name + ".db")
+	if err != nil {
+		return 0, nil, fmt.Errorf("file %s does not exist", name)
+	}
+	defer src.Close()
+	if version, hc, herr := readHeader(src); herr == nil && version == formatVersion {
+		c = hc
+	} else {
+		fmt.Fprintf(os.Stderr, "Store %s has no readable header, reading it as %s\n", name, c.Name())
+	}
+	srcfile := &nodeFile{File: src, codec: c}
+	// the overflow store is read as it is, never created
+	ovf, err := os.Open(name + "_ovf.db")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, nil, err
+	}
+	if ovf != nil {
+		defer ovf.Close()
+	}
+	meta, err := readMeta(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v, the salvaged store has none\n", err)
+		meta = &internal.StoreMeta{}
+	}
+
+	if err := validateStoreName(into); err != nil {
+		return 0, nil, err
+	}
+	nodestore, freestore, err := createStore(into, c)
+	if err != nil {
+		return 0, nil, err
+	}
+	store := &Store{name: into, nodestore: nodestore, freestore: freestore, meta: &internal.StoreMeta{}}
+	defer func() {
+		if cerr := comClose(store); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			for _, file := range storeFiles(into) {
+				os.Remove(file)
+			}
+		}
+	}()
+	// vectors, points and UUIDs live in sidecars that are not salvaged, and
+	// every free slot goes on the new free list, reserved or not
+	salvaged := *meta
+	salvaged.VectorDim, salvaged.UUIDs, salvaged.Reserved = 0, false, nil
+	if err := writeMeta(into, &salvaged); err != nil {
+		return 0, nil, err
+	}
+	*store.meta = salvaged
+
+	fail := func(id uint32, why string) {
+		if n := len(bad); n > 0 && bad[n-1].to == id-1 {
+			bad[n-1].to = id
+			return
+		}
+		bad = append(bad, badRange{id, id, why})
+	}
+	n := 0
+	head := ^uint32(0)
+	buf := make([]byte, c.RecordSize())
+	out := make([]byte, c.RecordSize())
+	for id := uint32(0); ; id++ {
+		read, err := srcfile.ReadAt(buf, srcfile.offset(id))
+		if errors.Is(err, io.EOF) {
+			if read > 0 {
+				fail(id, fmt.Sprintf("record cut short after %d of %d bytes", read, len(buf)))
+			}
+			break
+		}
+		if err != nil {
+			return n, bad, err
+		}
+		node, err := decodeRecord(srcfile, id, buf)
+		if err == nil && node.InUse == 1 && isOverflow(node.Value) {
+			err = fmt.Errorf("overflow store is missing")
+			if ovf != nil {
+				var data []byte
+				if data, err = readOverflow(ovf, node.Value); err == nil {
+					var f *os.File
+					if f, err = overflowFile(store); err == nil {
+						tag := node.Value[0]
+						node.Value, err = appendOverflow(f, data)
+						node.Value[0] = tag
+					}
+				}
+			}
+		}
+		if err != nil {
+			fail(id, err.Error())
+			if qerr := quarantineRecord(nodestore, id, buf); qerr != nil {
+				return n, bad, qerr
+			}
+		}
+		if err != nil || node.InUse != 1 {
+			// link to next free
+			node = internal.Node{ID: id, Gen: node.Gen}
+			codec.Order.PutUint32(node.Value[0:], head)
+			head = id
+		} else {
+			n++
+		}
+		if err := c.EncodeNode(out, node); err != nil {
+			return n, bad, err
+		}
+		if _, err := nodestore.WriteAt(out, nodestore.offset(id)); err != nil {
+			return n, bad, err
+		}
+	}
+	return n, bad, setFree(freestore, head)
+}
+
+// runSalvage is the salvage subcommand: peridot salvage -store name
+// [-into name] [-codec name]
+func runSalvage(args []string) error {
+	fs := flag.NewFlagSet("salvage", flag.ExitOnError)
+	storename := fs.String("store", "", "damaged store to salvage")
+	into := fs.String("into", "", "store to create, the damaged store's name with _salvaged by default")
+	codecname := fs.String("codec", "binary", "codec to read the records with if the store header is unreadable")
+	fs.Parse(args)
+	if *storename == "" {
+		return fmt.Errorf("no store given, use -store")
+	}
+	if *into == "" {
+		*into = *storename + "_salvaged"
+	}
+	if dryRun {
+		return ErrDryRun
+	}
+	c, err := codec.ByName(*codecname)
+	if err != nil {
+		return err
+	}
+
+	n, bad, err := salvageStore(*storename, *into, c)
+	if err != nil {
+		return err
+	}
+	for _, r := range bad {
+		fmt.Fprintln(os.Stderr, "Unrecoverable", r)
+	}
+	fmt.Fprintf(os.Stderr, "Salvaged %d nodes from %s into %s, %d ranges unrecoverable\n", n, *storename, *into, len(bad))
+	if _, err := os.Stat(*into + "_corrupt.db"); err == nil {
+		fmt.Fprintf(os.Stderr, "The unrecoverable records are kept in %s_corrupt.db\n", *into)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// sampleNodes picks n of the in-use nodes of store uniformly at random, or
+// all of them if there are fewer, and returns their IDs in order along
+// with how many nodes there were to pick from
+func sampleNodes(store *Store, n int, rng *rand.Rand) ([]uint32, int, error) {
+	nodes, err := scanStore(store.nodestore, opMemory)
+	if err != nil {
+		return nil, 0, err
+	}
+	// reservoir sampling: the i-th node in use replaces a pick with
+	// probability n/i
+	var picked []uint32
+	seen := 0
+	for _, node := range nodes {
+		if node.InUse != 1 {
+			continue
+		}
+		seen++
+		if len(picked) < n {
+			picked = append(picked, node.ID)
+		} else if j := rng.IntN(seen); j < n {
+			picked[j] = node.ID
+		}
+	}
+	slices.Sort(picked)
+	return picked, seen, nil
+}
+
+func comSample(store *Store, dstname string, n int, seed uint64) (*nodeFile, *os.File, error) {
+	// Clone a uniform random sample of the nodes of a store into a new one
+	if n <= 0 {
+		return nil, nil, fmt.Errorf("invalid sample size %d, expected a positive number", n)
+	}
+	picked, total, err := sampleNodes(store, n, rand.New(rand.NewPCG(seed, seed)))
+	if err != nil {
+		return nil, nil, err
+	}
+	keep := make(map[uint32]bool, len(picked))
+	for _, id := range picked {
+		keep[id] = true
+	}
+	nodestore, freestore, err := comClone(store, dstname, "", func(node internal.Node) bool { return keep[node.ID] })
+	if err != nil {
+		return nil, nil, err
+	}
+	fmt.Printf("Sampled %d of %d nodes from %s into %s with seed %d\n", len(picked), total, store.name, dstname, seed)
+	return nodestore, freestore, nil
+}
+
+// randomTries is how many random slots randomNode reads before it gives up
+// on finding one in use that way and scans the store instead
+const randomTries = 32
+
+// randomNode returns an in-use node of store picked uniformly at random.
+// It reads random slots until one is in use, which in a store that is
+// mostly free slots can take long, so after randomTries it samples a
+// scan of the store.
+func randomNode(store *Store) (internal.Node, error) {
+	fi, err := store.nodestore.Stat()
+	if err != nil {
+		return internal.Node{}, err
+	}
+	slots := store.nodestore.slots(fi.Size())
+	if slots == 0 {
+		return internal.Node{}, fmt.Errorf("store %s has no nodes", store.name)
+	}
+	for range randomTries {
+		node, err := readNode(store.nodestore, uint32(rand.N(slots)))
+		if err != nil {
+			return internal.Node{}, err
+		}
+		if node.InUse == 1 {
+			return node, nil
+		}
+	}
+	picked, _, err := sampleNodes(store, 1, rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())))
+	if err != nil {
+		return internal.Node{}, err
+	}
+	if len(picked) == 0 {
+		return internal.Node{}, fmt.Errorf("store %s has no nodes", store.name)
+	}
+	return readNode(store.nodestore, picked[0])
+}
+
+func comRandom(store *Store, n int) error {
+	// Print n nodes of a store picked at random, with replacement
+	if n <= 0 {
+		return fmt.Errorf("invalid number of nodes %d, expected a positive number", n)
+	}
+	for range n {
+		node, err := randomNode(store)
+		if err != nil {
+			return err
+		}
+		value, err := showValue(store, node)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Node ID: %d, Handle: %s, Value: %s\n", node.ID, Handle{node.ID, node.Gen}, value)
+	}
+	return nil
+}
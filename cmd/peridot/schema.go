@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// property types a schema can require
+const (
+	typeString = "string"
+	typeNumber = "number"
+	typeBool   = "bool"
+)
+
+// parseSchema parses "name:type,..." where a trailing "!" marks a
+// required property, e.g. "name:string!,age:number"
+func parseSchema(s string) ([]internal.Property, error) {
+	var schema []internal.Property
+	seen := make(map[string]bool)
+	for _, field := range strings.Split(s, ",") {
+		name, typ, ok := strings.Cut(strings.TrimSpace(field), ":")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid property %q, expected name:type", field)
+		}
+		prop := internal.Property{Name: name, Type: strings.TrimSuffix(typ, "!")}
+		prop.Required = prop.Type != typ
+		switch prop.Type {
+		case typeString, typeNumber, typeBool:
+		default:
+			return nil, fmt.Errorf("property %s has unknown type %q", name, prop.Type)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("property %s is defined twice", name)
+		}
+		seen[name] = true
+		schema = append(schema, prop)
+	}
+	return schema, nil
+}
+
+// formatSchema is the inverse of parseSchema
+func formatSchema(schema []internal.Property) string {
+	fields := make([]string, len(schema))
+	for i, prop := range schema {
+		fields[i] = prop.Name + ":" + prop.Type
+		if prop.Required {
+			fields[i] += "!"
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// validate checks a JSON object against schema. Properties the schema
+// does not name are rejected.
+func validate(schema []internal.Property, data []byte) error {
+	var obj map[string]any
+	if err := json.Unmarshal(data, &obj); err != nil || obj == nil {
+		return fmt.Errorf("value is not a JSON object")
+	}
+	known := make(map[string]bool, len(schema))
+	for _, prop := range schema {
+		known[prop.Name] = true
+		v, ok := obj[prop.Name]
+		if !ok || v == nil {
+			if prop.Required {
+				return fmt.Errorf("property %s is required", prop.Name)
+			}
+			continue
+		}
+		var good bool
+		switch prop.Type {
+		case typeString:
+			_, good = v.(string)
+		case typeNumber:
+			_, good = v.(float64)
+		case typeBool:
+			_, good = v.(bool)
+		}
+		if !good {
+			return fmt.Errorf("property %s must be a %s", prop.Name, prop.Type)
+		}
+	}
+	for name := range obj {
+		if !known[name] {
+			return fmt.Errorf("property %s is not in the schema", name)
+		}
+	}
+	return nil
+}
+
+// encodeValue turns a value typed at the prompt into the node payload.
+// JSON objects and arrays are stored as documents, anything else as a JSON
+// string. Stores with a schema only take objects matching the schema.
+func encodeValue(store *Store, value string) ([64]byte, error) {
+	data, err := valueData(store, value)
+	if err != nil {
+		return [64]byte{}, err
+	}
+	return storeValue(store, data)
+}
+
+// valueData validates a value typed at the prompt and returns the bytes
+// encodeValue stores for it, without storing them
+func valueData(store *Store, value string) ([]byte, error) {
+	if store.meta.Schema != nil {
+		if err := validate(store.meta.Schema, []byte(value)); err != nil {
+			return nil, err
+		}
+	}
+	if !isDocument(value) {
+		data, _ := json.Marshal(value)
+		return data, nil
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(value)); err != nil {
+		return nil, err
+	}
+	return compact.Bytes(), nil
+}
+
+// asJSON returns a stored value as JSON. Values stored before values were
+// JSON encoded are raw text, and come back as a JSON string.
+func asJSON(value []byte) []byte {
+	if json.Valid(value) {
+		return value
+	}
+	data, _ := json.Marshal(string(value))
+	return data
+}
+
+// isDocument reports whether value is a JSON object or array
+func isDocument(value string) bool {
+	value = strings.TrimSpace(value)
+	return (strings.HasPrefix(value, "{") || strings.HasPrefix(value, "[")) && json.Valid([]byte(value))
+}
+
+// setSchema replaces the schema of a store. Every node in use has to match
+// the new schema; a nil schema makes the store schemaless again.
+func setSchema(store *Store, schema []internal.Property) error {
+	if schema != nil {
+		nodes, err := readStore(store.nodestore)
+		if err != nil {
+			return err
+		}
+		for _, node := range nodes {
+			if node.InUse != 1 {
+				continue
+			}
+			value, err := nodeValue(store, node)
+			if err != nil {
+				return err
+			}
+			if err := validate(schema, value); err != nil {
+				return fmt.Errorf("node %d does not match the schema: %v", node.ID, err)
+			}
+		}
+	}
+
+	meta := *store.meta
+	meta.Schema = schema
+	if err := writeMeta(store.name, &meta); err != nil {
+		return err
+	}
+	*store.meta = meta
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand/v2"
+	"sort"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// analyze samples up to statsSampleSize documents and keeps histograms of
+// up to statsBuckets buckets per property
+const (
+	statsSampleSize = 1000
+	statsBuckets    = 16
+)
+
+// analyzeStore samples the documents of the store and records statistics
+// on their top-level properties in its metadata. The stats are not kept up
+// to date by writes; analyze again when the data has changed.
+func analyzeStore(store *Store) (*internal.Stats, error) {
+	nodes, err := readStore(store.nodestore)
+	if err != nil {
+		return nil, err
+	}
+	// reservoir sample of the documents
+	var sample []map[string]any
+	var seen int64
+	for _, node := range nodes {
+		if node.InUse != 1 || isBlob(node.Value) {
+			continue
+		}
+		value, err := nodeValue(store, node)
+		if err != nil {
+			return nil, err
+		}
+		var doc map[string]any
+		if err := json.Unmarshal(value, &doc); err != nil || doc == nil {
+			continue
+		}
+		seen++
+		if len(sample) < statsSampleSize {
+			sample = append(sample, doc)
+		} else if i := rand.Int64N(seen); i < statsSampleSize {
+			sample[i] = doc
+		}
+	}
+
+	values := make(map[string][]any)
+	for _, doc := range sample {
+		for name, v := range doc {
+			values[name] = append(values[name], v)
+		}
+	}
+	stats := &internal.Stats{Nodes: seen, Sampled: len(sample)}
+	for name, vs := range values {
+		sort.Slice(vs, func(i, j int) bool { return compareValues(vs[i], vs[j]) < 0 })
+		stats.Properties = append(stats.Properties, internal.PropertyStats{
+			Name:      name,
+			Present:   int64(math.Round(float64(len(vs)) * float64(seen) / float64(len(sample)))),
+			Distinct:  estimateDistinct(vs, seen, len(sample)),
+			Histogram: histogram(vs),
+		})
+	}
+	sort.Slice(stats.Properties, func(i, j int) bool { return stats.Properties[i].Name < stats.Properties[j].Name })
+
+	meta := *store.meta
+	meta.Stats = stats
+	if err := writeMeta(store.name, &meta); err != nil {
+		return nil, err
+	}
+	*store.meta = meta
+	return stats, nil
+}
+
+// estimateDistinct estimates the distinct values of a property over all
+// nodes from its sorted sample values, by the GEE estimator: values seen
+// once in the sample stand for sqrt(nodes/sampled) values each.
+func estimateDistinct(vs []any, nodes int64, sampled int) int64 {
+	var once, more int
+	for i := 0; i < len(vs); {
+		j := i + 1
+		for j < len(vs) && compareValues(vs[i], vs[j]) == 0 {
+			j++
+		}
+		if j-i == 1 {
+			once++
+		} else {
+			more++
+		}
+		i = j
+	}
+	return int64(math.Round(math.Sqrt(float64(nodes)/float64(sampled))*float64(once))) + int64(more)
+}
+
+// histogram returns the bounds of equi-depth buckets over sorted values:
+// the smallest value, then the largest value of every bucket
+func histogram(vs []any) []any {
+	buckets := min(statsBuckets, len(vs))
+	bounds := []any{vs[0]}
+	for i := 1; i <= buckets; i++ {
+		bounds = append(bounds, vs[i*len(vs)/buckets-1])
+	}
+	return bounds
+}
+
+// propertyStats returns the stats of a property, or nil if it has none
+func propertyStats(stats *internal.Stats, name string) *internal.PropertyStats {
+	if stats == nil {
+		return nil
+	}
+	for i := range stats.Properties {
+		if stats.Properties[i].Name == name {
+			return &stats.Properties[i]
+		}
+	}
+	return nil
+}
+
+// below estimates the share of the values of a property that are less
+// than v. Within a bucket numbers are assumed to be spread evenly, other
+// values to lie halfway.
+func below(hist []any, v any) float64 {
+	buckets := len(hist) - 1
+	if buckets < 1 || compareValues(v, hist[0]) <= 0 {
+		return 0
+	}
+	if compareValues(v, hist[buckets]) > 0 {
+		return 1
+	}
+	i := sort.Search(buckets, func(i int) bool { return compareValues(v, hist[i+1]) <= 0 })
+	within := 0.5
+	lo, lok := hist[i].(float64)
+	hi, hok := hist[i+1].(float64)
+	if x, ok := v.(float64); ok && lok && hok && hi > lo {
+		within = (x - lo) / (hi - lo)
+	}
+	return (float64(i) + within) / float64(buckets)
+}
+
+// rangeFraction estimates the share of the values of a property between
+// lo and hi from its histogram, either end of which may be nil
+func rangeFraction(hist []any, lo, hi *bound) float64 {
+	from, to := 0.0, 1.0
+	if lo != nil {
+		from = below(hist, lo.value)
+	}
+	if hi != nil {
+		to = below(hist, hi.value)
+		if hi.inclusive && compareValues(hi.value, hist[len(hist)-1]) >= 0 {
+			to = 1
+		}
+	}
+	return max(to-from, 0)
+}
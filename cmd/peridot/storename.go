@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidStoreName is returned when a store name would not make a safe
+// file name
+var ErrInvalidStoreName = errors.New("invalid store name")
+
+// maxStoreName is the longest store name, directories included
+const maxStoreName = 128
+
+// validateStoreName checks that name can be used for the files of a store.
+// A name is one or more parts separated by /, for stores in
+// subdirectories; each part is letters, digits, _ and -, and starts with a
+// letter or digit. This keeps stores inside the data directory and their
+// files free of spaces and dots. A name may not end in the suffix of a
+// file kept next to a store, such as _free, or it would be taken for that
+// file.
+func validateStoreName(name string) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w %q: %s", ErrInvalidStoreName, name, reason)
+	}
+	if name == "" {
+		return invalid("empty")
+	}
+	if len(name) > maxStoreName {
+		return invalid(fmt.Sprintf("longer than %d bytes", maxStoreName))
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == "" {
+			return invalid("empty directory name")
+		}
+		for i, r := range part {
+			letter := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+			if !letter && (i == 0 || r != '_' && r != '-') {
+				return invalid(fmt.Sprintf("%q must be letters, digits, _ and -, starting with a letter or digit", part))
+			}
+		}
+	}
+	for _, suffix := range append(sidecarSuffixes, "_meta.db") {
+		if reserved := strings.TrimSuffix(suffix, ".db"); strings.HasSuffix(name, reserved) {
+			return invalid(fmt.Sprintf("names ending in %s are kept for the files of a store", reserved))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// replCommands are the commands completed at the REPL prompt
+var replCommands = []string{
+	"list", "create", "clone", "sample", "merge", "export", "import", "reserve", "insert", "get", "random", "putblob", "getblob",
+	"update", "delete", "begin", "commit", "rollback", "migrate", "uuids", "lookup", "schema",
+	"webhook", "quota", "limits", "attach", "detach", "refresh", "archive", "freeze", "thaw", "read", "find", "search", "triples", "create-index", "indexes",
+	"reindex", "analyze", "check", "drop-index", "vector", "similar", "geo", "near", "format",
+	"maintenance", "stats", "reload", "version", "help", "exit",
+}
+
+// storeNames lists the registered stores for completion. main sets it.
+var storeNames = func() []string { return nil }
+
+// replPrompt is the REPL prompt as last printed, where commands are
+// completed
+var replPrompt string
+
+// lastPrompt is the prompt the next line is read for. It decides what Tab
+// completes, and is printed again after listing the choices.
+var lastPrompt string
+
+// ask prints a prompt for the line read next
+func ask(prompt string) {
+	lastPrompt = prompt
+	fmt.Print(prompt)
+}
+
+// promptOptions matches the choices listed in a prompt, as in
+// "Enter action (add/remove/list): "
+var promptOptions = regexp.MustCompile(`\(([a-z0-9-]+(?:/[a-z0-9-]+)+)`)
+
+// completions returns what the last word of a line can be, given the
+// prompt: commands at the REPL prompt, store names and files where those
+// are asked for, and the choices a prompt lists
+func completions(prompt, word string) []string {
+	var choices []string
+	lower := strings.ToLower(prompt)
+	switch {
+	case prompt == replPrompt:
+		choices = replCommands
+		for alias := range config.Aliases {
+			choices = append(choices, alias)
+		}
+	case strings.Contains(lower, "store name"):
+		choices = storeNames()
+	case strings.Contains(lower, "file"):
+		paths, _ := filepath.Glob(word + "*")
+		for _, p := range paths {
+			if fi, err := os.Stat(p); err == nil && fi.IsDir() {
+				p += string(filepath.Separator)
+			}
+			choices = append(choices, p)
+		}
+	default:
+		if m := promptOptions.FindStringSubmatch(prompt); m != nil {
+			choices = strings.Split(m[1], "/")
+		}
+	}
+	var matches []string
+	for _, c := range choices {
+		if strings.HasPrefix(c, word) && !slices.Contains(matches, c) {
+			matches = append(matches, c)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// commonPrefix returns the longest prefix shared by all of words
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// termReader reads standard input a line at a time. On a terminal it
+// edits the line itself, to complete words on Tab; otherwise it reads
+// input as it comes.
+type termReader struct {
+	in      *os.File
+	pending []byte
+}
+
+func (t *termReader) Read(p []byte) (int, error) {
+	if len(t.pending) == 0 {
+		restore, err := makeRaw(t.in)
+		if err != nil {
+			// not a terminal
+			return t.in.Read(p)
+		}
+		line, err := t.editLine()
+		restore()
+		if err == errInterrupted {
+			// die of the signal as an interrupted read would have, now
+			// that the terminal is back as it was
+			interrupt()
+		}
+		if err != nil {
+			return 0, err
+		}
+		t.pending = append(line, '\n')
+	}
+	n := copy(p, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+// Control keys handled while editing a line
+const (
+	keyInterrupt = 0x03 // Ctrl-C
+	keyEOF       = 0x04 // Ctrl-D
+	keyBell      = 0x07
+	keyBackspace = 0x08
+	keyTab       = 0x09
+	keyKill      = 0x15 // Ctrl-U
+	keyEscape    = 0x1b
+	keyDelete    = 0x7f
+)
+
+// editLine reads one line from the terminal in raw mode, echoing it and
+// handling backspace, Ctrl-U, Ctrl-C, Ctrl-D and Tab
+func (t *termReader) editLine() ([]byte, error) {
+	var line []byte
+	var b [1]byte
+	tabs := 0
+	for {
+		if _, err := t.in.Read(b[:]); err != nil {
+			return nil, err
+		}
+		c := b[0]
+		if c == keyTab {
+			tabs++
+		} else {
+			tabs = 0
+		}
+		switch {
+		case c == '\r' || c == '\n':
+			fmt.Print("\r\n")
+			return line, nil
+		case c == keyInterrupt:
+			fmt.Print("^C\r\n")
+			return nil, errInterrupted
+		case c == keyEOF:
+			if len(line) == 0 {
+				fmt.Print("\r\n")
+				return nil, io.EOF
+			}
+		case c == keyBackspace || c == keyDelete:
+			if len(line) > 0 {
+				_, size := utf8.DecodeLastRune(line)
+				line = line[:len(line)-size]
+				fmt.Print("\b \b")
+			}
+		case c == keyKill:
+			fmt.Print(strings.Repeat("\b \b", utf8.RuneCount(line)))
+			line = line[:0]
+		case c == keyEscape:
+			// drop arrow keys and other escape sequences: ESC [ params final
+			if _, err := t.in.Read(b[:]); err != nil || b[0] != '[' {
+				continue
+			}
+			for {
+				if _, err := t.in.Read(b[:]); err != nil || (b[0] >= 0x40 && b[0] <= 0x7e) {
+					break
+				}
+			}
+		case c == keyTab:
+			line = t.complete(line, tabs > 1)
+		case c >= 0x20:
+			line = append(line, c)
+			os.Stdout.Write(b[:])
+		}
+	}
+}
+
+// complete extends the last word of line as far as the completions for
+// it agree. If that adds nothing, a second Tab lists the choices.
+func (t *termReader) complete(line []byte, list bool) []byte {
+	start := bytes.LastIndexAny(line, " ,") + 1
+	word := string(line[start:])
+	matches := completions(lastPrompt, word)
+	if len(matches) == 0 {
+		fmt.Print(string(rune(keyBell)))
+		return line
+	}
+	if rest := commonPrefix(matches)[len(word):]; rest != "" {
+		fmt.Print(rest)
+		return append(line, rest...)
+	}
+	if list && len(matches) > 1 {
+		fmt.Printf("\r\n%s\r\n%s%s", strings.Join(matches, "  "), lastPrompt, line)
+	}
+	return line
+}
+
+// errInterrupted is returned by editLine on Ctrl-C
+var errInterrupted = errors.New("interrupted")
+
+// interrupt sends SIGINT to the server itself
+func interrupt() {
+	if p, err := os.FindProcess(os.Getpid()); err == nil {
+		p.Signal(os.Interrupt)
+	}
+}
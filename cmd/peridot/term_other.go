@@ -0,0 +1,14 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// makeRaw is only implemented for Linux; elsewhere lines are read as the
+// terminal delivers them, without completion
+func makeRaw(f *os.File) (func(), error) {
+	return nil, errors.New("line editing is not supported on this platform")
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// textIndex is an inverted index from the words in node values to the
+// nodes holding them. For documents the words of every string inside are
+// indexed.
+type textIndex struct {
+	postings map[string]map[uint32]int // word -> node ID -> occurrences
+	words    map[uint32][]string       // node ID -> distinct words
+}
+
+// TextMatch is a search hit with its relevance score
+type TextMatch struct {
+	ID    uint32
+	Score float64
+}
+
+// tokenize splits text into lower case words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// valueWords returns the words of a node value: the strings of a JSON
+// value, or the raw text if it is not JSON
+func valueWords(value []byte) []string {
+	var v any
+	if err := json.Unmarshal(value, &v); err != nil {
+		return tokenize(string(value))
+	}
+	var words []string
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case string:
+			words = append(words, tokenize(v)...)
+		case []any:
+			for _, e := range v {
+				walk(e)
+			}
+		case map[string]any:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	walk(v)
+	return words
+}
+
+func (idx *textIndex) remove(id uint32) {
+	for _, word := range idx.words[id] {
+		delete(idx.postings[word], id)
+		if len(idx.postings[word]) == 0 {
+			delete(idx.postings, word)
+		}
+	}
+	delete(idx.words, id)
+}
+
+func (idx *textIndex) add(id uint32, words []string) {
+	idx.remove(id)
+	for _, word := range words {
+		ids := idx.postings[word]
+		if ids == nil {
+			ids = make(map[uint32]int)
+			idx.postings[word] = ids
+		}
+		if ids[id] == 0 {
+			idx.words[id] = append(idx.words[id], word)
+		}
+		ids[id]++
+	}
+}
+
+// loadTextIndex returns the full-text index of the store, building it from
+// the nodestore on first use
+func loadTextIndex(store *Store) (*textIndex, error) {
+	if store.textindex != nil {
+		return store.textindex, nil
+	}
+	nodes, err := readStore(store.nodestore)
+	if err != nil {
+		return nil, err
+	}
+	idx := &textIndex{postings: make(map[string]map[uint32]int), words: make(map[uint32][]string)}
+	for _, node := range nodes {
+		if node.InUse != 1 || isBlob(node.Value) {
+			continue
+		}
+		value, err := nodeValue(store, node)
+		if err != nil {
+			return nil, err
+		}
+		idx.add(node.ID, valueWords(value))
+	}
+	store.textindex = idx
+	return idx, nil
+}
+
+// parseTerms parses a query of words joined by AND and OR. AND binds
+// tighter than OR, and words next to each other are ANDed. The result is
+// a list of alternatives, each a list of words that must all appear.
+func parseTerms(query string) ([][]string, error) {
+	var alts [][]string
+	var cur []string
+	for _, field := range strings.Fields(query) {
+		switch strings.ToUpper(field) {
+		case "OR":
+			if len(cur) == 0 {
+				return nil, fmt.Errorf("invalid query %q, OR needs words on both sides", query)
+			}
+			alts = append(alts, cur)
+			cur = nil
+		case "AND":
+		default:
+			cur = append(cur, tokenize(field)...)
+		}
+	}
+	if len(cur) == 0 {
+		return nil, fmt.Errorf("invalid query %q, no words to search for", query)
+	}
+	return append(alts, cur), nil
+}
+
+// search returns the nodes matching the query, best first. Nodes are
+// scored by tf-idf over the query words.
+func search(store *Store, query string) ([]TextMatch, error) {
+	alts, err := parseTerms(query)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := loadTextIndex(store)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := make(map[uint32]bool)
+	for _, words := range alts {
+		// start from the rarest word
+		sort.Slice(words, func(i, j int) bool { return len(idx.postings[words[i]]) < len(idx.postings[words[j]]) })
+		for id := range idx.postings[words[0]] {
+			all := true
+			for _, word := range words[1:] {
+				if idx.postings[word][id] == 0 {
+					all = false
+					break
+				}
+			}
+			if all {
+				hits[id] = true
+			}
+		}
+	}
+
+	docs := float64(len(idx.words))
+	var matches []TextMatch
+	for id := range hits {
+		var score float64
+		for _, words := range alts {
+			for _, word := range words {
+				if tf := idx.postings[word][id]; tf > 0 {
+					idf := math.Log(1 + docs/float64(len(idx.postings[word])))
+					score += float64(tf) * idf / math.Sqrt(float64(len(idx.words[id])))
+				}
+			}
+		}
+		matches = append(matches, TextMatch{id, score})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score == matches[j].Score {
+			return matches[i].ID < matches[j].ID
+		}
+		return matches[i].Score > matches[j].Score
+	})
+	return matches, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// coldDir is the secondary directory archived stores are moved to. It is
+// empty when tiering is disabled.
+var coldDir string
+
+// sidecarSuffixes are the files kept next to a nodestore. They end in .db
+// too, so store discovery has to skip them.
+var sidecarSuffixes = []string{"_free.db", "_vec.db", "_geo.db", "_uuid.db", "_ovf.db", "_bloom.db", "_corrupt.db"}
+
+// storeFiles lists the files that make up the named store. The nodestore
+// comes first: a store exists as long as its .db file does.
+func storeFiles(name string) []string {
+	files := []string{name + ".db", name + "_meta.json"}
+	for _, suffix := range sidecarSuffixes {
+		files = append(files, name+suffix)
+	}
+	return files
+}
+
+// moveFile moves src to dst, falling back to copy and remove when the two
+// are on different filesystems. A missing src is not an error.
+func moveFile(src, dst string) error {
+	if _, err := os.Stat(src); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := replaceFile(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	// Windows cannot rename or remove files that are open
+	if err := out.Close(); err != nil {
+		return err
+	}
+	in.Close()
+	if err := replaceFile(out.Name(), dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// archiveStore closes the store and moves its files to the cold directory.
+// The store stays registered and is pulled back on next use.
+func archiveStore(store *Store) error {
+	if coldDir == "" {
+		return fmt.Errorf("no cold directory configured (start with -cold <dir>)")
+	}
+	if store.cold {
+		return fmt.Errorf("store %s is already archived", store.name)
+	}
+	if building(store) {
+		return fmt.Errorf("store %s is building an index, try again when it is done", store.name)
+	}
+
+	if !store.closed {
+		if err := closeFiles(store); err != nil {
+			return err
+		}
+	}
+	store.cold, store.closed = true, false
+
+	// move the nodestore first so an interrupted archive is found in the
+	// cold directory and pulled back whole
+	for _, file := range storeFiles(store.name) {
+		if err := moveFile(file, filepath.Join(coldDir, file)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeFiles syncs and closes every file of the store, clears the open
+// flag in its header, and drops what was read from them. Sidecars and
+// indexes are opened and built again on first use. The files are closed
+// even if syncing fails; the first error is returned.
+func closeFiles(store *Store) error {
+	files := []*os.File{store.freestore, store.vecstore, store.geostore, store.uuidstore, store.ovfstore}
+	if store.bloom != nil {
+		files = append(files, store.bloom.f)
+	}
+	var err error
+	for _, f := range files {
+		if f == nil {
+			continue
+		}
+		if serr := f.Sync(); serr != nil && err == nil {
+			err = serr
+		}
+		f.Close()
+	}
+	// the flag is cleared last, once everything else is on disk
+	if err == nil {
+		_, err = setOpenFlag(store.nodestore.File, false, !dryRun)
+	}
+	store.nodestore.Close()
+	store.nodestore, store.freestore, store.vecstore, store.geostore, store.uuidstore = nil, nil, nil, nil, nil
+	store.ovfstore, store.bloom = nil, nil
+	store.geoindex, store.uuidindex, store.indexes, store.textindex = nil, nil, nil, nil
+	store.typeindex = nil
+	findCache.forget(store.name)
+	return err
+}
+
+// reopenFiles opens the nodestore and free list of a store whose files
+// were closed, and reads its metadata again
+func reopenFiles(store *Store) error {
+	nodestore, freestore, err := openStore(store.name)
+	if err != nil {
+		return err
+	}
+	meta, err := readMeta(store.name)
+	if err != nil {
+		return err
+	}
+	store.meta = meta
+	store.nodestore = nodestore
+	store.freestore = freestore
+	if nodestore.unclean {
+		return recoverStore(store)
+	}
+	return nil
+}
+
+// unarchiveStore moves the files of a cold store back to the primary
+// directory and reopens it
+func unarchiveStore(store *Store) error {
+	files := storeFiles(store.name)
+	// move the nodestore last, the mirror image of archiveStore
+	for i := len(files) - 1; i >= 0; i-- {
+		if err := moveFile(filepath.Join(coldDir, files[i]), files[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := reopenFiles(store); err != nil {
+		return err
+	}
+	store.cold = false
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/nabeeladzan/peridot/internal/codec"
+)
+
+// tx is the transaction opened by begin in the REPL, nil when there is
+// none. While it is open, insert, putblob, update and delete are queued
+// instead of run; other commands run as usual.
+var tx *transaction
+
+// transaction is a list of writes made together on commit
+type transaction struct {
+	ops []txOp
+}
+
+// txOp is one queued write. The store is kept by name, as the REPL's
+// list of stores may grow while the transaction is open.
+type txOp struct {
+	kind   string // insert, putblob, update or delete
+	store  string
+	value  string // the value to insert or update to, or the base64 blob
+	handle string // the node to update
+	id     uint32 // the node to delete
+	key    string // idempotency key
+}
+
+func (op txOp) String() string {
+	switch op.kind {
+	case "update":
+		return fmt.Sprintf("update %s in %s", op.handle, op.store)
+	case "delete":
+		return fmt.Sprintf("delete %d from %s", op.id, op.store)
+	}
+	return fmt.Sprintf("%s into %s", op.kind, op.store)
+}
+
+func beginTx() error {
+	if dryRun {
+		return ErrDryRun
+	}
+	if tx != nil {
+		return fmt.Errorf("a transaction is already open with %d pending writes", len(tx.ops))
+	}
+	tx = &transaction{}
+	return nil
+}
+
+// queue checks what can be checked about op without running it, then adds
+// it to the transaction
+func (t *transaction) queue(store *Store, op txOp) error {
+	if err := writable(store); err != nil {
+		return err
+	}
+	var err error
+	switch op.kind {
+	case "insert":
+		_, err = valueData(store, op.value)
+	case "putblob":
+		_, err = decodeBlob(op.value)
+	case "update":
+		if _, _, err = parseHandle(op.handle); err == nil {
+			_, err = valueData(store, op.value)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	t.ops = append(t.ops, op)
+	return nil
+}
+
+// storeSnapshot is a copy of the files of a store taken before a commit
+// writes to it, to put back if the commit fails
+type storeSnapshot struct {
+	files       map[string][]byte // nil for files that did not exist
+	idempotency *idempotencyKeys
+}
+
+func snapshotStore(store *Store) (*storeSnapshot, error) {
+	if building(store) {
+		return nil, fmt.Errorf("store %s is building an index, try again when it is done", store.name)
+	}
+	snap := &storeSnapshot{files: make(map[string][]byte)}
+	for _, file := range storeFiles(store.name) {
+		data, err := os.ReadFile(file)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		snap.files[file] = data
+	}
+	if k := store.idempotency; k != nil {
+		snap.idempotency = &idempotencyKeys{done: maps.Clone(k.done), order: slices.Clone(k.order)}
+	}
+	return snap, nil
+}
+
+// restore puts the files of the store back the way they were and reopens
+// it. Indexes are rebuilt from the restored nodes on first use.
+func (snap *storeSnapshot) restore(store *Store) error {
+	closeFiles(store)
+	for file, data := range snap.files {
+		var err error
+		if file == store.name+".db" && data != nil {
+			// the copy was taken while the store was open
+			flags := codec.Order.Uint16(data[10:]) &^ headerOpen
+			codec.Order.PutUint16(data[10:], flags)
+		}
+		if data == nil {
+			err = os.Remove(file)
+			if errors.Is(err, os.ErrNotExist) {
+				err = nil
+			}
+		} else {
+			err = os.WriteFile(file, data, 0644)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	store.idempotency = snap.idempotency
+	return reopenFiles(store)
+}
+
+// commit runs the queued writes in order. If one fails, the stores written
+// so far are put back as they were before the commit, and no webhook
+// hears of any of the writes.
+func (t *transaction) commit(stores *registry) error {
+	snaps := make(map[string]*storeSnapshot)
+	holdWebhooks()
+	for i, op := range t.ops {
+		store, err := findStore(stores, op.store)
+		if err == nil && snaps[op.store] == nil {
+			snaps[op.store], err = snapshotStore(store)
+		}
+		if err == nil {
+			err = runTxOp(store, op)
+		}
+		if err != nil {
+			releaseWebhooks(false)
+			for name, snap := range snaps {
+				if store, ferr := findStore(stores, name); ferr == nil && snap != nil {
+					if rerr := snap.restore(store); rerr != nil {
+						return fmt.Errorf("write %d (%s): %v, and restoring store %s failed: %v", i+1, op, err, name, rerr)
+					}
+				}
+			}
+			return fmt.Errorf("write %d (%s): %v, nothing was written", i+1, op, err)
+		}
+	}
+	releaseWebhooks(true)
+	return nil
+}
+
+// runTxOp makes one queued write, printing what the REPL prints for it
+func runTxOp(store *Store, op txOp) error {
+	switch op.kind {
+	case "insert":
+		m, err := comInsert(store, op.value, op.key)
+		if err == nil {
+			err = m.print()
+		}
+		return err
+	case "putblob":
+		m, err := comPutBlob(store, op.value)
+		if err == nil {
+			err = m.print()
+		}
+		return err
+	case "update":
+		m, err := comUpdate(store, op.handle, op.value, op.key)
+		if err == nil {
+			err = m.print()
+		}
+		return err
+	case "delete":
+		m, err := comDelete(store, op.id)
+		if err == nil {
+			err = m.print()
+		}
+		return err
+	}
+	return fmt.Errorf("unknown write %q", op.kind)
+}
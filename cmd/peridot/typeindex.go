@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// typeIndex maps each node type to the in-use nodes of that type, so
+// reading the nodes of one type does not scan every record
+type typeIndex struct {
+	postings map[byte][]uint32 // type -> node IDs, in ID order
+	types    map[uint32]byte   // node ID -> type
+}
+
+func newTypeIndex() *typeIndex {
+	return &typeIndex{postings: make(map[byte][]uint32), types: make(map[uint32]byte)}
+}
+
+func (idx *typeIndex) remove(id uint32) {
+	typ, ok := idx.types[id]
+	if !ok {
+		return
+	}
+	ids := idx.postings[typ]
+	if i, found := slices.BinarySearch(ids, id); found {
+		ids = slices.Delete(ids, i, i+1)
+	}
+	if len(ids) == 0 {
+		delete(idx.postings, typ)
+	} else {
+		idx.postings[typ] = ids
+	}
+	delete(idx.types, id)
+}
+
+func (idx *typeIndex) add(id uint32, typ byte) {
+	idx.remove(id)
+	ids := idx.postings[typ]
+	i, _ := slices.BinarySearch(ids, id)
+	idx.postings[typ] = slices.Insert(ids, i, id)
+	idx.types[id] = typ
+}
+
+// ids returns the nodes of type typ in ID order
+func (idx *typeIndex) ids(typ byte) []uint32 {
+	return idx.postings[typ]
+}
+
+// loadTypeIndex returns the type index of the store, building it from the
+// nodestore on first use
+func loadTypeIndex(store *Store) (*typeIndex, error) {
+	if store.typeindex != nil {
+		return store.typeindex, nil
+	}
+	nodes, err := readStore(store.nodestore)
+	if err != nil {
+		return nil, err
+	}
+	idx := newTypeIndex()
+	for _, node := range nodes {
+		if node.InUse == 1 {
+			idx.add(node.ID, node.Type)
+		}
+	}
+	store.typeindex = idx
+	return idx, nil
+}
+
+// typedNodes reads the in-use nodes of type typ through the type index, in
+// ID order
+func typedNodes(store *Store, typ byte) ([]internal.Node, error) {
+	idx, err := loadTypeIndex(store)
+	if err != nil {
+		return nil, err
+	}
+	var nodes []internal.Node
+	for _, id := range idx.ids(typ) {
+		if err := opMemory.charge(nodeSize); err != nil {
+			return nil, err
+		}
+		node, err := readNode(store.nodestore, id)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// parseNodeType parses a node type, blank for none. It returns -1 for
+// blank.
+func parseNodeType(s string) (int, error) {
+	if s == "" {
+		return -1, nil
+	}
+	typ, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid node type %q", s)
+	}
+	return int(typ), nil
+}
+
+// queryType returns the node type a triple query is restricted to: when
+// every pattern has the same subject and one of them gives it an rdf:type
+// of a node type, only nodes of that type can match. It returns -1 when
+// the query is not restricted.
+func queryType(store *Store, q *tripleQuery) int {
+	prefix := strings.TrimSuffix(iri(storeNS(store)+"type:"), ">")
+	typ := -1
+	for _, p := range q.patterns {
+		if p.s != q.patterns[0].s {
+			return -1
+		}
+		if p.p != iri(rdfNS+"type") {
+			continue
+		}
+		n, ok := strings.CutPrefix(p.o, prefix)
+		if !ok {
+			continue
+		}
+		if t, err := parseNodeType(strings.TrimSuffix(n, ">")); err == nil && t >= 0 {
+			typ = t
+		}
+	}
+	return typ
+}
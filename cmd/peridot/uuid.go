@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const uuidSize = 20 // 1 (InUse) + 3 (Padding) + 16 (UUID)
+
+// UUID is the external key of a node. Unlike its ID it is never reused.
+type UUID [16]byte
+
+// newUUID returns a random (version 4) UUID
+func newUUID() (UUID, error) {
+	var u UUID
+	if _, err := rand.Read(u[:]); err != nil {
+		return u, err
+	}
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return u, nil
+}
+
+func (u UUID) String() string {
+	h := hex.EncodeToString(u[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
+
+// parseUUID parses the canonical 8-4-4-4-12 hex form
+func parseUUID(s string) (UUID, error) {
+	var u UUID
+	h := strings.ReplaceAll(s, "-", "")
+	if len(s) != 36 || len(h) != 32 {
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+	if _, err := hex.Decode(u[:], []byte(h)); err != nil {
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+	return u, nil
+}
+
+// uuidIndex maps UUIDs to node IDs and back
+type uuidIndex struct {
+	ids   map[UUID]uint32
+	uuids map[uint32]UUID
+}
+
+// uuidFile returns the UUID store of the store, opening it on first use
+func uuidFile(store *Store) (*os.File, error) {
+	if store.uuidstore != nil {
+		return store.uuidstore, nil
+	}
+	f, err := os.OpenFile(store.name+"_uuid.db", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s_uuid", store.name)
+	}
+	store.uuidstore = f
+	return f, nil
+}
+
+// writeUUID stores u as the UUID of node id
+func writeUUID(f *os.File, id uint32, u UUID) error {
+	buf := make([]byte, uuidSize)
+	buf[0] = 1
+	copy(buf[4:], u[:])
+	_, err := f.WriteAt(buf, int64(id)*uuidSize)
+	return err
+}
+
+// loadUUIDIndex returns the UUID index of the store, building it from the
+// UUID store on first use
+func loadUUIDIndex(store *Store) (*uuidIndex, error) {
+	if store.uuidindex != nil {
+		return store.uuidindex, nil
+	}
+	f, err := uuidFile(store)
+	if err != nil {
+		return nil, err
+	}
+	idx := &uuidIndex{ids: make(map[UUID]uint32), uuids: make(map[uint32]UUID)}
+	buf := make([]byte, uuidSize)
+	for i := uint32(0); ; i++ {
+		_, err := f.ReadAt(buf, int64(i)*uuidSize)
+		if err != nil {
+			break // EOF or error
+		}
+		if buf[0] == 1 {
+			var u UUID
+			copy(u[:], buf[4:])
+			idx.ids[u] = i
+			idx.uuids[i] = u
+		}
+	}
+	store.uuidindex = idx
+	return idx, nil
+}
+
+// setUUID records u as the UUID of node id
+func setUUID(store *Store, id uint32, u UUID) error {
+	idx, err := loadUUIDIndex(store)
+	if err != nil {
+		return err
+	}
+	if other, ok := idx.ids[u]; ok && other != id {
+		return fmt.Errorf("UUID %s already belongs to node %d", u, other)
+	}
+	if err := writeUUID(store.uuidstore, id, u); err != nil {
+		return err
+	}
+	if old, ok := idx.uuids[id]; ok {
+		delete(idx.ids, old)
+	}
+	idx.ids[u] = id
+	idx.uuids[id] = u
+	return bloomAdd(store, uuidKey(u))
+}
+
+// assignUUID gives node id a fresh UUID
+func assignUUID(store *Store, id uint32) (UUID, error) {
+	u, err := newUUID()
+	if err != nil {
+		return u, err
+	}
+	return u, setUUID(store, id, u)
+}
+
+// removeUUID drops the UUID of node id from the store and its index
+func removeUUID(store *Store, id uint32) error {
+	idx, err := loadUUIDIndex(store)
+	if err != nil {
+		return err
+	}
+	u, ok := idx.uuids[id]
+	if !ok {
+		return nil
+	}
+	if _, err := store.uuidstore.WriteAt([]byte{0}, int64(id)*uuidSize); err != nil {
+		return err
+	}
+	delete(idx.ids, u)
+	delete(idx.uuids, id)
+	return nil
+}
+
+// enableUUIDs turns on UUID assignment for the store and gives every
+// existing node one
+func enableUUIDs(store *Store) (int, error) {
+	nodes, err := readStore(store.nodestore)
+	if err != nil {
+		return 0, err
+	}
+	idx, err := loadUUIDIndex(store)
+	if err != nil {
+		return 0, err
+	}
+	assigned := 0
+	for _, node := range nodes {
+		if node.InUse != 1 {
+			continue
+		}
+		if _, ok := idx.uuids[node.ID]; ok {
+			continue
+		}
+		if _, err := assignUUID(store, node.ID); err != nil {
+			return assigned, err
+		}
+		assigned++
+	}
+
+	if !store.meta.UUIDs {
+		meta := *store.meta
+		meta.UUIDs = true
+		if err := writeMeta(store.name, &meta); err != nil {
+			return assigned, err
+		}
+		*store.meta = meta
+	}
+	return assigned, nil
+}
+
+// mergeUUID gives node dstID of dst the UUID node srcID has in src, or a
+// fresh one if src has none or dst already holds it
+func mergeUUID(dst, src *Store, srcID, dstID uint32) error {
+	if src.meta.UUIDs {
+		srcidx, err := loadUUIDIndex(src)
+		if err != nil {
+			return err
+		}
+		dstidx, err := loadUUIDIndex(dst)
+		if err != nil {
+			return err
+		}
+		if u, ok := srcidx.uuids[srcID]; ok {
+			if _, taken := dstidx.ids[u]; !taken {
+				return setUUID(dst, dstID, u)
+			}
+		}
+	}
+	_, err := assignUUID(dst, dstID)
+	return err
+}
+
+// cloneUUIDs copies the UUIDs of the given nodes of src into a new UUID
+// file at path
+func cloneUUIDs(src *Store, path string, ids []uint32) error {
+	idx, err := loadUUIDIndex(src)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s", path)
+	}
+	defer out.Close()
+
+	for _, id := range ids {
+		u, ok := idx.uuids[id]
+		if !ok {
+			continue
+		}
+		if err := writeUUID(out, id, u); err != nil {
+			return err
+		}
+	}
+	return out.Sync()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nabeeladzan/peridot/internal/codec"
+)
+
+const vecHeaderSize = 4 // 1 (InUse) + 3 (Padding), followed by dim float32s
+
+// similarity metrics
+const (
+	metricCosine = "cosine" // higher is closer
+	metricL2     = "l2"     // lower is closer
+)
+
+// Match is a node returned by a similarity search
+type Match struct {
+	ID    uint32
+	Score float64
+}
+
+func vecRecordSize(dim uint32) int64 {
+	return vecHeaderSize + int64(dim)*4
+}
+
+// vectorFile returns the vector store of the store, opening it on first use
+func vectorFile(store *Store) (*os.File, error) {
+	if store.vecstore != nil {
+		return store.vecstore, nil
+	}
+	f, err := os.OpenFile(store.name+"_vec.db", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s_vec", store.name)
+	}
+	store.vecstore = f
+	return f, nil
+}
+
+// writeVector stores vec as the vector of node id
+func writeVector(f *os.File, dim uint32, id uint32, vec []float32) error {
+	buf := make([]byte, vecRecordSize(dim))
+	buf[0] = 1
+	for i, v := range vec {
+		codec.Order.PutUint32(buf[vecHeaderSize+i*4:], math.Float32bits(v))
+	}
+	_, err := f.WriteAt(buf, int64(id)*vecRecordSize(dim))
+	return err
+}
+
+// clearVector removes the vector of node id, if it has one
+func clearVector(f *os.File, dim uint32, id uint32) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	offset := int64(id) * vecRecordSize(dim)
+	if offset >= fi.Size() {
+		return nil
+	}
+	_, err = f.WriteAt([]byte{0}, offset)
+	return err
+}
+
+// readVector reads the vector of node id. The bool is false when the node
+// has no vector.
+func readVector(f *os.File, dim uint32, id uint32) ([]float32, bool, error) {
+	buf := make([]byte, vecRecordSize(dim))
+	_, err := f.ReadAt(buf, int64(id)*vecRecordSize(dim))
+	if err != nil {
+		// past the end of the file, never written
+		return nil, false, nil
+	}
+	if buf[0] != 1 {
+		return nil, false, nil
+	}
+	return decodeVector(buf, dim), true, nil
+}
+
+// readVectors reads every stored vector, keyed by node ID
+func readVectors(f *os.File, dim uint32) (map[uint32][]float32, error) {
+	vecs := make(map[uint32][]float32)
+	buf := make([]byte, vecRecordSize(dim))
+	for i := uint32(0); ; i++ {
+		_, err := f.ReadAt(buf, int64(i)*vecRecordSize(dim))
+		if err != nil {
+			break // EOF or error
+		}
+		if buf[0] == 1 {
+			vecs[i] = decodeVector(buf, dim)
+		}
+	}
+	return vecs, nil
+}
+
+func decodeVector(buf []byte, dim uint32) []float32 {
+	vec := make([]float32, dim)
+	for i := range vec {
+		vec[i] = math.Float32frombits(codec.Order.Uint32(buf[vecHeaderSize+i*4:]))
+	}
+	return vec
+}
+
+// cloneVectors copies the vectors of the given nodes of src into a new
+// vector file at path
+func cloneVectors(src *Store, path string, ids []uint32) error {
+	dim := src.meta.VectorDim
+	f, err := vectorFile(src)
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s", path)
+	}
+	defer out.Close()
+
+	for _, id := range ids {
+		vec, ok, err := readVector(f, dim, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+		if err := writeVector(out, dim, id, vec); err != nil {
+			return err
+		}
+	}
+	return out.Sync()
+}
+
+// parseVector parses a comma separated list of numbers
+func parseVector(s string) ([]float32, error) {
+	var vec []float32
+	for _, field := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(field), 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vector component %q", field)
+		}
+		vec = append(vec, float32(v))
+	}
+	return vec, nil
+}
+
+// setVector attaches vec to an in-use node. The first vector stored in a
+// store fixes the dimension for all later ones.
+func setVector(store *Store, id uint32, vec []float32) error {
+	node, err := readNode(store.nodestore, id)
+	if err != nil || node.InUse != 1 {
+		return fmt.Errorf("node %d not found", id)
+	}
+
+	if store.meta.VectorDim == 0 {
+		meta := *store.meta
+		meta.VectorDim = uint32(len(vec))
+		if err := writeMeta(store.name, &meta); err != nil {
+			return err
+		}
+		*store.meta = meta
+	}
+	if uint32(len(vec)) != store.meta.VectorDim {
+		return fmt.Errorf("store %s holds vectors of dimension %d, got %d", store.name, store.meta.VectorDim, len(vec))
+	}
+
+	f, err := vectorFile(store)
+	if err != nil {
+		return err
+	}
+	return writeVector(f, store.meta.VectorDim, id, vec)
+}
+
+// similar ranks the nodes with a vector by their distance to query and
+// returns the k closest. exclude is left out of the results, so a node is
+// not reported as similar to itself.
+func similar(store *Store, query []float32, k int, metric string, exclude uint32) ([]Match, error) {
+	dim := store.meta.VectorDim
+	if dim == 0 {
+		return nil, fmt.Errorf("store %s has no vectors", store.name)
+	}
+	if uint32(len(query)) != dim {
+		return nil, fmt.Errorf("store %s holds vectors of dimension %d, got %d", store.name, dim, len(query))
+	}
+
+	var score func(a, b []float32) float64
+	switch metric {
+	case metricCosine:
+		score = cosine
+	case metricL2:
+		score = l2
+	default:
+		return nil, fmt.Errorf("unknown metric %s", metric)
+	}
+
+	f, err := vectorFile(store)
+	if err != nil {
+		return nil, err
+	}
+	vecs, err := readVectors(f, dim)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]Match, 0, len(vecs))
+	for id, vec := range vecs {
+		if id == exclude {
+			continue
+		}
+		matches = append(matches, Match{ID: id, Score: score(query, vec)})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score == matches[j].Score {
+			return matches[i].ID < matches[j].ID
+		}
+		if metric == metricL2 {
+			return matches[i].Score < matches[j].Score
+		}
+		return matches[i].Score > matches[j].Score
+	})
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+	return matches, nil
+}
+
+func cosine(a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+func l2(a, b []float32) float64 {
+	var sum float64
+	for i := range a {
+		d := float64(a[i]) - float64(b[i])
+		sum += d * d
+	}
+	return math.Sqrt(sum)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// Webhook deliveries are tried webhookAttempts times, waiting
+// webhookBackoff after the first failure and twice as long after each
+// one after that. Up to webhookQueue events wait per URL; events beyond
+// that are dropped. On exit the server waits up to webhookFlush for the
+// queues to drain.
+const (
+	webhookAttempts = 5
+	webhookBackoff  = time.Second
+	webhookTimeout  = 10 * time.Second
+	webhookQueue    = 1024
+	webhookFlush    = 30 * time.Second
+)
+
+// MutationEvent is the JSON payload POSTed to the webhooks of a store
+// after a node is inserted, updated or deleted
+type MutationEvent struct {
+	Store string          `json:"store"`
+	Op    string          `json:"op"` // insert, update or delete
+	ID    uint32          `json:"id"`
+	Gen   uint16          `json:"gen"`
+	Value json.RawMessage `json:"value,omitempty"` // not sent for deletes and blobs
+	Time  time.Time       `json:"time"`
+}
+
+// webhooks delivers events to each URL from its own goroutine, so events
+// reach a URL in order and a slow URL does not hold up the others
+var webhooks = struct {
+	mu      sync.Mutex
+	queues  map[string]chan []byte
+	pending sync.WaitGroup
+	client  *http.Client
+	// events held back while a transaction commits
+	holding bool
+	held    []heldEvent
+}{queues: make(map[string]chan []byte), client: &http.Client{Timeout: webhookTimeout}}
+
+// parseWebhook checks that s is an http or https URL
+func parseWebhook(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid webhook URL %q, expected http(s)://host/path", s)
+	}
+	return u.String(), nil
+}
+
+// addWebhook and removeWebhook change the webhooks of a store
+func addWebhook(store *Store, s string) error {
+	hook, err := parseWebhook(s)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(store.meta.Webhooks, hook) {
+		return fmt.Errorf("store %s already has webhook %s", store.name, hook)
+	}
+	meta := *store.meta
+	meta.Webhooks = append(slices.Clone(meta.Webhooks), hook)
+	return setMeta(store, &meta)
+}
+
+func removeWebhook(store *Store, hook string) error {
+	i := slices.Index(store.meta.Webhooks, hook)
+	if i < 0 {
+		return fmt.Errorf("store %s has no webhook %s", store.name, hook)
+	}
+	meta := *store.meta
+	meta.Webhooks = slices.Delete(slices.Clone(meta.Webhooks), i, i+1)
+	return setMeta(store, &meta)
+}
+
+func setMeta(store *Store, meta *internal.StoreMeta) error {
+	if err := writeMeta(store.name, meta); err != nil {
+		return err
+	}
+	*store.meta = *meta
+	return nil
+}
+
+// notify queues an event about node id for every webhook of the store. It
+// is called after the write, and the node is read back for the event.
+func notify(store *Store, op string, id uint32) error {
+	if len(store.meta.Webhooks) == 0 {
+		return nil
+	}
+	node, err := readNode(store.nodestore, id)
+	if err != nil {
+		return err
+	}
+	event := MutationEvent{Store: store.name, Op: op, ID: id, Gen: node.Gen, Time: time.Now().UTC()}
+	if op == "delete" {
+		// the delete bumped the generation, report the one deleted
+		event.Gen--
+	} else if !isBlob(node.Value) {
+		value, err := nodeValue(store, node)
+		if err != nil {
+			return err
+		}
+		event.Value = asJSON(value)
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	for _, hook := range store.meta.Webhooks {
+		enqueue(hook, payload)
+	}
+	return nil
+}
+
+// heldEvent is an event for hook that is not queued yet
+type heldEvent struct {
+	hook    string
+	payload []byte
+}
+
+// holdWebhooks keeps events back until releaseWebhooks, so the writes of
+// a transaction are only announced if all of them are made
+func holdWebhooks() {
+	webhooks.mu.Lock()
+	webhooks.holding = true
+	webhooks.mu.Unlock()
+}
+
+// releaseWebhooks queues the held events, or drops them if send is false
+func releaseWebhooks(send bool) {
+	webhooks.mu.Lock()
+	held := webhooks.held
+	webhooks.holding, webhooks.held = false, nil
+	webhooks.mu.Unlock()
+	if !send {
+		return
+	}
+	for _, e := range held {
+		enqueue(e.hook, e.payload)
+	}
+}
+
+func enqueue(hook string, payload []byte) {
+	webhooks.mu.Lock()
+	if webhooks.holding {
+		webhooks.held = append(webhooks.held, heldEvent{hook, payload})
+		webhooks.mu.Unlock()
+		return
+	}
+	q, ok := webhooks.queues[hook]
+	if !ok {
+		q = make(chan []byte, webhookQueue)
+		webhooks.queues[hook] = q
+		go deliverAll(hook, q)
+	}
+	webhooks.mu.Unlock()
+
+	webhooks.pending.Add(1)
+	select {
+	case q <- payload:
+	default:
+		webhooks.pending.Done()
+		fmt.Fprintf(os.Stderr, "Webhook %s: queue full, event dropped\n", hook)
+	}
+}
+
+func deliverAll(hook string, q chan []byte) {
+	for payload := range q {
+		if err := deliver(hook, payload); err != nil {
+			fmt.Fprintf(os.Stderr, "Webhook %s: giving up after %d attempts: %v\n", hook, webhookAttempts, err)
+		}
+		webhooks.pending.Done()
+	}
+}
+
+// deliver POSTs payload to hook until it answers with a 2xx status
+func deliver(hook string, payload []byte) error {
+	var err error
+	wait := webhookBackoff
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+		var resp *http.Response
+		resp, err = webhooks.client.Post(hook, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode/100 == 2 {
+			return nil
+		}
+		err = fmt.Errorf("status %s", resp.Status)
+	}
+	return err
+}
+
+// flushWebhooks waits up to webhookFlush for queued events to be
+// delivered
+func flushWebhooks() bool {
+	done := make(chan struct{})
+	go func() {
+		webhooks.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(webhookFlush):
+		return false
+	}
+}
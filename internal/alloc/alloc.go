@@ -0,0 +1,108 @@
+// Package alloc hands out the slots of a file of fixed-size records.
+// Freed slots are chained into a list: a small head file holds the first
+// free slot, and each free slot holds the next. How a slot stores its link
+// is up to the record format, so the caller reads and writes it.
+package alloc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// None is the head of an empty free list and the link of its last slot
+const None = ^uint32(0)
+
+// SlotAllocator allocates and frees the slots of Records
+type SlotAllocator struct {
+	// Records is the file of records, for its size
+	Records interface{ Stat() (os.FileInfo, error) }
+	// Head holds the first free slot, as a little-endian uint32
+	Head interface {
+		io.ReaderAt
+		io.WriterAt
+	}
+	// RecordSize is the size of one record, and First the offset of the
+	// first, after any header
+	RecordSize int64
+	First      int64
+}
+
+// ReadHead reads the first free slot from a head file, None when the list
+// is empty. A head file without a head yet also holds an empty list.
+func ReadHead(f io.ReaderAt) (uint32, error) {
+	buf := make([]byte, 4)
+	n, err := f.ReadAt(buf, 0)
+	if n == 0 && err == io.EOF {
+		return None, nil
+	}
+	if err == io.EOF {
+		return 0, fmt.Errorf("free list head is truncated to %d bytes", n)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("reading the free list: %v", err)
+	}
+	return binary.LittleEndian.Uint32(buf), nil
+}
+
+// WriteHead makes id the first free slot of a head file
+func WriteHead(f io.WriterAt, id uint32) error {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, id)
+	_, err := f.WriteAt(buf, 0)
+	return err
+}
+
+// Slots returns how many slots the record file holds
+func (a *SlotAllocator) Slots() (uint32, error) {
+	fi, err := a.Records.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if fi.Size() < a.First {
+		return 0, nil
+	}
+	return uint32((fi.Size() - a.First) / a.RecordSize), nil
+}
+
+// Alloc takes the first free slot off the list, reading the slot after it
+// with next, or returns the slot past the end of the file when the list
+// is empty. reused says which. The caller writes the record.
+func (a *SlotAllocator) Alloc(next func(id uint32) (uint32, error)) (id uint32, reused bool, err error) {
+	head, err := ReadHead(a.Head)
+	if err != nil {
+		return 0, false, err
+	}
+	slots, err := a.Slots()
+	if err != nil {
+		return 0, false, err
+	}
+	if head == None {
+		return slots, false, nil
+	}
+	if head >= slots {
+		return 0, false, fmt.Errorf("free list head %d is past the last slot, %d", head, slots)
+	}
+	after, err := next(head)
+	if err != nil {
+		return 0, false, err
+	}
+	if err := WriteHead(a.Head, after); err != nil {
+		return 0, false, err
+	}
+	return head, true, nil
+}
+
+// Free puts slot id at the front of the list. link writes the freed record
+// with the slot that was first until now, which comes next.
+func (a *SlotAllocator) Free(id uint32, link func(next uint32) error) error {
+	head, err := ReadHead(a.Head)
+	if err != nil {
+		return err
+	}
+	if err := link(head); err != nil {
+		return err
+	}
+	return WriteHead(a.Head, id)
+}
@@ -0,0 +1,169 @@
+package alloc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	recordSize = 16
+	headerSize = 16
+)
+
+// testAllocator returns an allocator over a record file of slots records
+// after a header, with an empty head file. links holds the link of each
+// free slot, as the record format would.
+func testAllocator(t *testing.T, slots int) (*SlotAllocator, map[uint32]uint32) {
+	t.Helper()
+	dir := t.TempDir()
+	records, err := os.Create(filepath.Join(dir, "records.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { records.Close() })
+	if err := records.Truncate(headerSize + int64(slots)*recordSize); err != nil {
+		t.Fatal(err)
+	}
+	head, err := os.Create(filepath.Join(dir, "head.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { head.Close() })
+	a := &SlotAllocator{Records: records, Head: head, RecordSize: recordSize, First: headerSize}
+	return a, make(map[uint32]uint32)
+}
+
+// grow appends the record of a newly allocated slot
+func grow(t *testing.T, a *SlotAllocator) {
+	t.Helper()
+	f := a.Records.(*os.File)
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(fi.Size() + recordSize); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadHeadEmpty(t *testing.T) {
+	a, _ := testAllocator(t, 0)
+	head, err := ReadHead(a.Head)
+	if err != nil || head != None {
+		t.Fatalf("ReadHead of a new head file = %d, %v, want None", head, err)
+	}
+	if err := WriteHead(a.Head, None); err != nil {
+		t.Fatal(err)
+	}
+	head, err = ReadHead(a.Head)
+	if err != nil || head != None {
+		t.Fatalf("ReadHead of an empty list = %d, %v, want None", head, err)
+	}
+}
+
+func TestAllocAppend(t *testing.T) {
+	a, links := testAllocator(t, 3)
+	if err := WriteHead(a.Head, None); err != nil {
+		t.Fatal(err)
+	}
+	next := func(id uint32) (uint32, error) {
+		t.Fatalf("read the link of slot %d of an empty list", id)
+		return 0, nil
+	}
+	for want := uint32(3); want < 5; want++ {
+		id, reused, err := a.Alloc(next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != want || reused {
+			t.Errorf("Alloc = %d, %v, want %d appended", id, reused, want)
+		}
+		grow(t, a)
+	}
+	if len(links) != 0 {
+		t.Errorf("links written: %v", links)
+	}
+}
+
+func TestAllocFromFreeList(t *testing.T) {
+	a, links := testAllocator(t, 4)
+	// 2 -> 0 -> end
+	links[2], links[0] = 0, None
+	if err := WriteHead(a.Head, 2); err != nil {
+		t.Fatal(err)
+	}
+	next := func(id uint32) (uint32, error) { return links[id], nil }
+	for _, want := range []uint32{2, 0} {
+		id, reused, err := a.Alloc(next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != want || !reused {
+			t.Errorf("Alloc = %d, %v, want %d reused", id, reused, want)
+		}
+	}
+	id, reused, err := a.Alloc(next)
+	if err != nil || id != 4 || reused {
+		t.Errorf("Alloc of an emptied list = %d, %v, %v, want 4 appended", id, reused, err)
+	}
+}
+
+func TestFreeThenReuse(t *testing.T) {
+	a, links := testAllocator(t, 3)
+	if err := WriteHead(a.Head, None); err != nil {
+		t.Fatal(err)
+	}
+	link := func(id uint32) func(uint32) error {
+		return func(next uint32) error {
+			links[id] = next
+			return nil
+		}
+	}
+	for _, id := range []uint32{1, 2} {
+		if err := a.Free(id, link(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if links[1] != None || links[2] != 1 {
+		t.Errorf("links after freeing 1 then 2 = %v, want 2 -> 1 -> end", links)
+	}
+	next := func(id uint32) (uint32, error) { return links[id], nil }
+	for _, want := range []uint32{2, 1, 3} {
+		id, _, err := a.Alloc(next)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != want {
+			t.Errorf("Alloc = %d, want %d", id, want)
+		}
+	}
+}
+
+func TestCorruptHead(t *testing.T) {
+	a, _ := testAllocator(t, 2)
+	next := func(id uint32) (uint32, error) { return None, nil }
+
+	// a head cut short
+	f := a.Head.(*os.File)
+	if _, err := f.WriteAt([]byte{1, 0}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadHead(f); err == nil {
+		t.Error("ReadHead of a truncated head succeeded")
+	}
+	if _, _, err := a.Alloc(next); err == nil {
+		t.Error("Alloc with a truncated head succeeded")
+	}
+	if err := a.Free(0, func(uint32) error { return nil }); err == nil {
+		t.Error("Free with a truncated head succeeded")
+	}
+
+	// a head past the last slot
+	if err := WriteHead(f, 7); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.Alloc(next); err == nil {
+		t.Error("Alloc with a head past the last slot succeeded")
+	}
+}
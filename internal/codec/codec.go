@@ -0,0 +1,127 @@
+// Package codec defines the on-disk layout of Peridot records.
+//
+// Every multi-byte field is stored little-endian regardless of the host
+// architecture, so store files can be copied between machines as-is.
+// Code outside this package should not slice records by hand.
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// Codec (de)serializes node records. Each store picks one at creation; its
+// ID is kept in the store header. Records stay fixed-size slots, so a codec
+// also decides how big a slot is.
+type Codec interface {
+	ID() byte
+	Name() string
+	RecordSize() int
+	EncodeNode(buf []byte, n internal.Node) error
+	DecodeNode(buf []byte) (internal.Node, error)
+}
+
+// ErrCorrupt is returned, wrapped, when a record cannot be decoded. A
+// decoder never panics and never drops part of a record it cannot hold.
+var ErrCorrupt = errors.New("corrupt record")
+
+// checkSize fails unless buf holds a whole record of size bytes
+func checkSize(buf []byte, size int) error {
+	if len(buf) < size {
+		return fmt.Errorf("%w: %d bytes, expected %d", ErrCorrupt, len(buf), size)
+	}
+	return nil
+}
+
+// Codecs lists every codec by ID. Binary is 0 so stores written before
+// codecs existed keep working.
+var Codecs = []Codec{Binary{}, Protobuf{}, JSON{}}
+
+// ByID returns the codec with the given header ID
+func ByID(id byte) (Codec, error) {
+	for _, c := range Codecs {
+		if c.ID() == id {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown codec %d", id)
+}
+
+// ByName returns the codec with the given name
+func ByName(name string) (Codec, error) {
+	for _, c := range Codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown codec %s", name)
+}
+
+// Binary is the fixed little-endian layout of EncodeNode and DecodeNode
+type Binary struct{}
+
+func (Binary) ID() byte        { return 0 }
+func (Binary) Name() string    { return "binary" }
+func (Binary) RecordSize() int { return NodeSize }
+
+func (Binary) EncodeNode(buf []byte, n internal.Node) error {
+	EncodeNode(buf, n)
+	return nil
+}
+
+func (Binary) DecodeNode(buf []byte) (internal.Node, error) {
+	if err := checkSize(buf, NodeSize); err != nil {
+		return internal.Node{}, err
+	}
+	return DecodeNode(buf), nil
+}
+
+// Order is the byte order of every on-disk integer
+var Order = binary.LittleEndian
+
+const NodeSize = 72 // 4 (ID) + 1 (InUse) + 1 (Type) + 2 (Generation) + 64 (Value)
+
+const EdgeSize = 16 // 4 (ID) + 1 (InUse) + 3 (Padding) + 4 (FromID) + 4 (ToID)
+
+// EncodeNode writes n into buf, which must be at least NodeSize long
+func EncodeNode(buf []byte, n internal.Node) {
+	Order.PutUint32(buf[0:], n.ID)
+	buf[4] = n.InUse
+	buf[5] = n.Type
+	Order.PutUint16(buf[6:], n.Gen)
+	copy(buf[8:NodeSize], n.Value[:])
+}
+
+// DecodeNode reads a node from buf, which must be at least NodeSize long
+func DecodeNode(buf []byte) internal.Node {
+	n := internal.Node{
+		ID:    Order.Uint32(buf[0:4]),
+		InUse: buf[4],
+		Type:  buf[5],
+		Gen:   Order.Uint16(buf[6:8]),
+	}
+	copy(n.Value[:], buf[8:NodeSize])
+	return n
+}
+
+// EncodeEdge writes e into buf, which must be at least EdgeSize long
+func EncodeEdge(buf []byte, e internal.Edge) {
+	Order.PutUint32(buf[0:], e.ID)
+	buf[4] = e.InUse
+	buf[5], buf[6], buf[7] = 0, 0, 0
+	Order.PutUint32(buf[8:], e.FromID)
+	Order.PutUint32(buf[12:], e.ToID)
+}
+
+// DecodeEdge reads an edge from buf, which must be at least EdgeSize long
+func DecodeEdge(buf []byte) internal.Edge {
+	return internal.Edge{
+		ID:     Order.Uint32(buf[0:4]),
+		InUse:  buf[4],
+		FromID: Order.Uint32(buf[8:12]),
+		ToID:   Order.Uint32(buf[12:16]),
+	}
+}
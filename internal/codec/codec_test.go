@@ -0,0 +1,105 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// testNodes are records every codec must round-trip: an empty slot, a node
+// in use, and a free slot whose value starts with a next-free link
+func testNodes() []internal.Node {
+	inUse := internal.Node{ID: 0x01020304, InUse: 1, Type: 7, Gen: 0x0506}
+	copy(inUse.Value[:], `"hello"`)
+	full := internal.Node{ID: 42, InUse: 1, Gen: 1}
+	for i := range full.Value {
+		full.Value[i] = 'x'
+	}
+	free := internal.Node{ID: 9, Gen: 3}
+	Order.PutUint32(free.Value[0:], 0xfffffffe)
+	return []internal.Node{{}, inUse, full, free}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, c := range Codecs {
+		for _, n := range testNodes() {
+			buf := make([]byte, c.RecordSize())
+			if err := c.EncodeNode(buf, n); err != nil {
+				t.Fatalf("%s: encoding node %d: %v", c.Name(), n.ID, err)
+			}
+			got, err := c.DecodeNode(buf)
+			if err != nil {
+				t.Fatalf("%s: decoding node %d: %v", c.Name(), n.ID, err)
+			}
+			if got != n {
+				t.Errorf("%s: decoded %+v, want %+v", c.Name(), got, n)
+			}
+		}
+	}
+}
+
+func TestShortBuffer(t *testing.T) {
+	for _, c := range Codecs {
+		_, err := c.DecodeNode(make([]byte, c.RecordSize()-1))
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: decoding a short record gave %v, want ErrCorrupt", c.Name(), err)
+		}
+	}
+}
+
+func TestCodecByID(t *testing.T) {
+	for _, c := range Codecs {
+		byID, err := ByID(c.ID())
+		if err != nil || byID != c {
+			t.Errorf("ByID(%d) = %v, %v, want %s", c.ID(), byID, err, c.Name())
+		}
+		byName, err := ByName(c.Name())
+		if err != nil || byName != c {
+			t.Errorf("ByName(%s) = %v, %v, want %s", c.Name(), byName, err, c.Name())
+		}
+	}
+}
+
+func TestBinaryByteOrder(t *testing.T) {
+	n := internal.Node{ID: 0x01020304, InUse: 1, Type: 2, Gen: 0x0506}
+	copy(n.Value[:], "ab")
+	buf := make([]byte, NodeSize)
+	EncodeNode(buf, n)
+	want := []byte{0x04, 0x03, 0x02, 0x01, 1, 2, 0x06, 0x05, 'a', 'b', 0}
+	if !bytes.Equal(buf[:len(want)], want) {
+		t.Errorf("encoded % x, want % x", buf[:len(want)], want)
+	}
+}
+
+func TestProtobufByteOrder(t *testing.T) {
+	n := internal.Node{ID: 1, InUse: 1}
+	for i := range n.Value {
+		n.Value[i] = 'x'
+	}
+	buf := make([]byte, protobufSize)
+	if err := (Protobuf{}).EncodeNode(buf, n); err != nil {
+		t.Fatal(err)
+	}
+	// 4 varint fields of 2 bytes each, then a key, a length and the value
+	if buf[0] != 74 || buf[1] != 0 {
+		t.Errorf("length prefix is % x, want 4a 00", buf[:2])
+	}
+}
+
+func TestEdgeRoundTrip(t *testing.T) {
+	e := internal.Edge{ID: 0x01020304, InUse: 1, FromID: 0x05060708, ToID: 0x090a0b0c}
+	buf := make([]byte, EdgeSize)
+	for i := range buf {
+		buf[i] = 0xff
+	}
+	EncodeEdge(buf, e)
+	want := []byte{0x04, 0x03, 0x02, 0x01, 1, 0, 0, 0, 0x08, 0x07, 0x06, 0x05, 0x0c, 0x0b, 0x0a, 0x09}
+	if !bytes.Equal(buf, want) {
+		t.Errorf("encoded % x, want % x", buf, want)
+	}
+	if got := DecodeEdge(buf); got != e {
+		t.Errorf("decoded %+v, want %+v", got, e)
+	}
+}
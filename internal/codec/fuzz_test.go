@@ -0,0 +1,38 @@
+package codec
+
+import (
+	"testing"
+)
+
+// FuzzDecodeNode feeds every codec arbitrary records. A decoder must
+// reject what it cannot read with an error rather than panic, and a node
+// it does read must encode and decode back to itself.
+func FuzzDecodeNode(f *testing.F) {
+	for _, c := range Codecs {
+		for _, n := range testNodes() {
+			buf := make([]byte, c.RecordSize())
+			if err := c.EncodeNode(buf, n); err != nil {
+				f.Fatal(err)
+			}
+			f.Add(c.ID(), buf)
+		}
+	}
+	f.Fuzz(func(t *testing.T, id byte, data []byte) {
+		c := Codecs[int(id)%len(Codecs)]
+		n, err := c.DecodeNode(data)
+		if err != nil {
+			return
+		}
+		buf := make([]byte, c.RecordSize())
+		if err := c.EncodeNode(buf, n); err != nil {
+			t.Fatalf("%s: encoding decoded node %+v: %v", c.Name(), n, err)
+		}
+		again, err := c.DecodeNode(buf)
+		if err != nil {
+			t.Fatalf("%s: decoding re-encoded node %+v: %v", c.Name(), n, err)
+		}
+		if again != n {
+			t.Fatalf("%s: node %+v decoded back as %+v", c.Name(), n, again)
+		}
+	})
+}
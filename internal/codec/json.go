@@ -0,0 +1,84 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// JSON stores a node as a JSON object padded with spaces to the slot size
+// and ending in a newline, so a nodestore can be read with a pager. A
+// value that is not valid UTF-8, such as a free slot's next-free link, is
+// written in hex instead.
+type JSON struct{}
+
+const jsonSize = 512
+
+type jsonNode struct {
+	ID       uint32 `json:"id"`
+	InUse    byte   `json:"in_use"`
+	Type     byte   `json:"type"`
+	Gen      uint16 `json:"gen"`
+	Value    string `json:"value,omitempty"`
+	ValueHex string `json:"value_hex,omitempty"`
+}
+
+func (JSON) ID() byte        { return 2 }
+func (JSON) Name() string    { return "json" }
+func (JSON) RecordSize() int { return jsonSize }
+
+func (JSON) EncodeNode(buf []byte, n internal.Node) error {
+	rec := jsonNode{ID: n.ID, InUse: n.InUse, Type: n.Type, Gen: n.Gen}
+	value := trimZeros(n.Value[:])
+	if utf8.Valid(value) {
+		rec.Value = string(value)
+	} else {
+		rec.ValueHex = hex.EncodeToString(value)
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if len(data)+1 > jsonSize {
+		return fmt.Errorf("json record of %d bytes does not fit a %d byte slot", len(data), jsonSize)
+	}
+	copy(buf, data)
+	for i := len(data); i < jsonSize-1; i++ {
+		buf[i] = ' '
+	}
+	buf[jsonSize-1] = '\n'
+	return nil
+}
+
+func (JSON) DecodeNode(buf []byte) (internal.Node, error) {
+	var n internal.Node
+	if err := checkSize(buf, jsonSize); err != nil {
+		return n, err
+	}
+	data := bytes.Trim(buf[:jsonSize], " \n\x00")
+	if len(data) == 0 {
+		// a slot that was never written
+		return n, nil
+	}
+	var rec jsonNode
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return n, fmt.Errorf("%w: malformed json record: %v", ErrCorrupt, err)
+	}
+	n.ID, n.InUse, n.Type, n.Gen = rec.ID, rec.InUse, rec.Type, rec.Gen
+	value := []byte(rec.Value)
+	if rec.ValueHex != "" {
+		var err error
+		if value, err = hex.DecodeString(rec.ValueHex); err != nil {
+			return n, fmt.Errorf("%w: malformed json record: %v", ErrCorrupt, err)
+		}
+	}
+	if len(value) > len(n.Value) {
+		return n, fmt.Errorf("%w: json record value of %d bytes", ErrCorrupt, len(value))
+	}
+	copy(n.Value[:], value)
+	return n, nil
+}
@@ -0,0 +1,131 @@
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/nabeeladzan/peridot/internal"
+)
+
+// Protobuf stores a node as a protocol buffers message, prefixed with its
+// length and padded to the slot size. Decoding skips fields it does not
+// know, so fields can be added without rewriting old records.
+//
+//	message Node {
+//	  uint32 id     = 1;
+//	  uint32 in_use = 2;
+//	  uint32 type   = 3;
+//	  uint32 gen    = 4;
+//	  bytes  value  = 5; // trailing zero bytes trimmed
+//	}
+type Protobuf struct{}
+
+const protobufSize = 96 // 2 (Length) + up to 81 bytes of message, padded
+
+func (Protobuf) ID() byte        { return 1 }
+func (Protobuf) Name() string    { return "protobuf" }
+func (Protobuf) RecordSize() int { return protobufSize }
+
+func (Protobuf) EncodeNode(buf []byte, n internal.Node) error {
+	msg := make([]byte, 0, protobufSize)
+	msg = appendVarintField(msg, 1, uint64(n.ID))
+	msg = appendVarintField(msg, 2, uint64(n.InUse))
+	msg = appendVarintField(msg, 3, uint64(n.Type))
+	msg = appendVarintField(msg, 4, uint64(n.Gen))
+	value := trimZeros(n.Value[:])
+	msg = binary.AppendUvarint(msg, 5<<3|2)
+	msg = binary.AppendUvarint(msg, uint64(len(value)))
+	msg = append(msg, value...)
+
+	if 2+len(msg) > protobufSize {
+		return fmt.Errorf("protobuf record of %d bytes does not fit a %d byte slot", len(msg), protobufSize)
+	}
+	clear(buf[:protobufSize])
+	Order.PutUint16(buf[0:], uint16(len(msg)))
+	copy(buf[2:], msg)
+	return nil
+}
+
+func (Protobuf) DecodeNode(buf []byte) (internal.Node, error) {
+	var n internal.Node
+	if err := checkSize(buf, protobufSize); err != nil {
+		return n, err
+	}
+	size := int(Order.Uint16(buf[0:2]))
+	if 2+size > protobufSize {
+		return n, fmt.Errorf("%w: protobuf record claims %d bytes", ErrCorrupt, size)
+	}
+	msg := buf[2 : 2+size]
+	for len(msg) > 0 {
+		key, k := binary.Uvarint(msg)
+		if k <= 0 {
+			return n, fmt.Errorf("%w: malformed protobuf field key", ErrCorrupt)
+		}
+		msg = msg[k:]
+		field, wire := key>>3, key&7
+		switch wire {
+		case 0: // varint
+			v, k := binary.Uvarint(msg)
+			if k <= 0 {
+				return n, fmt.Errorf("%w: malformed protobuf varint", ErrCorrupt)
+			}
+			msg = msg[k:]
+			if max := fieldMax[field]; max != 0 && v > max {
+				return n, fmt.Errorf("%w: protobuf field %d is %d, more than it holds", ErrCorrupt, field, v)
+			}
+			switch field {
+			case 1:
+				n.ID = uint32(v)
+			case 2:
+				n.InUse = byte(v)
+			case 3:
+				n.Type = byte(v)
+			case 4:
+				n.Gen = uint16(v)
+			}
+		case 2: // length delimited
+			l, k := binary.Uvarint(msg)
+			if k <= 0 || l > uint64(len(msg)-k) {
+				return n, fmt.Errorf("%w: malformed protobuf length", ErrCorrupt)
+			}
+			if field == 5 && l > uint64(len(n.Value)) {
+				return n, fmt.Errorf("%w: protobuf record value of %d bytes", ErrCorrupt, l)
+			}
+			if field == 5 {
+				copy(n.Value[:], msg[k:k+int(l)])
+			}
+			msg = msg[k+int(l):]
+		case 1: // fixed64
+			if len(msg) < 8 {
+				return n, fmt.Errorf("%w: truncated protobuf field", ErrCorrupt)
+			}
+			msg = msg[8:]
+		case 5: // fixed32
+			if len(msg) < 4 {
+				return n, fmt.Errorf("%w: truncated protobuf field", ErrCorrupt)
+			}
+			msg = msg[4:]
+		default:
+			return n, fmt.Errorf("%w: unsupported protobuf wire type %d", ErrCorrupt, wire)
+		}
+	}
+	return n, nil
+}
+
+// fieldMax is the largest value each varint field of a node holds
+var fieldMax = map[uint64]uint64{1: math.MaxUint32, 2: math.MaxUint8, 3: math.MaxUint8, 4: math.MaxUint16}
+
+func appendVarintField(buf []byte, field uint64, v uint64) []byte {
+	buf = binary.AppendUvarint(buf, field<<3)
+	return binary.AppendUvarint(buf, v)
+}
+
+// trimZeros drops the zero padding at the end of a fixed-size value
+func trimZeros(b []byte) []byte {
+	i := len(b)
+	for i > 0 && b[i-1] == 0 {
+		i--
+	}
+	return b[:i]
+}
@@ -4,7 +4,7 @@ type Node struct {
 	ID    uint32
 	Type  byte
 	InUse byte
-	_     [2]byte  // Padding
+	Gen   uint16   // Bumped every time the slot is freed
 	Value [64]byte // Fixed-size payload (e.g., name or encoded props)
 }
 
@@ -15,3 +15,61 @@ type Edge struct {
 	FromID uint32
 	ToID   uint32
 }
+
+// StoreMeta is the per-store metadata kept next to the store files
+type StoreMeta struct {
+	MaxNodes uint32 `json:"max_nodes,omitempty"` // 0 means no limit
+	MaxBytes int64  `json:"max_bytes,omitempty"` // 0 means no limit
+
+	// default limits of a query, 0 means no limit
+	MaxRows       int   `json:"max_rows,omitempty"`
+	MaxScanMillis int64 `json:"max_scan_ms,omitempty"`
+
+	VectorDim uint32 `json:"vector_dim,omitempty"` // set by the first vector stored
+	UUIDs     bool   `json:"uuids,omitempty"`      // assign a UUID to every node
+
+	Schema  []Property `json:"schema,omitempty"` // nil for schemaless stores
+	Indexes []IndexDef `json:"indexes,omitempty"`
+
+	Stats *Stats `json:"stats,omitempty"` // set by analyze
+
+	Webhooks []string `json:"webhooks,omitempty"` // URLs told about every write
+
+	Reserved []IDRange `json:"reserved,omitempty"` // node IDs held for a bulk load
+}
+
+// IDRange is the node IDs from First to Last, both included
+type IDRange struct {
+	First uint32 `json:"first"`
+	Last  uint32 `json:"last"`
+}
+
+// IndexDef names the properties an index orders nodes by
+type IndexDef struct {
+	Properties []string `json:"properties"`
+}
+
+// Property describes one named property of the node values in a store
+type Property struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"` // string, number or bool
+	Required bool   `json:"required,omitempty"`
+}
+
+// Stats describes the node values of a store, from a sample taken by
+// analyze
+type Stats struct {
+	Nodes      int64           `json:"nodes"`   // in-use document nodes
+	Sampled    int             `json:"sampled"` // documents the stats come from
+	Properties []PropertyStats `json:"properties"`
+}
+
+// PropertyStats describes the values of one top-level property
+type PropertyStats struct {
+	Name     string `json:"name"`
+	Present  int64  `json:"present"`  // estimated documents with the property
+	Distinct int64  `json:"distinct"` // estimated distinct values
+	// Histogram holds the bounds of equi-depth buckets, in value order:
+	// each bucket holds the same share of the values
+	Histogram []any `json:"histogram,omitempty"`
+}
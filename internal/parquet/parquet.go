@@ -0,0 +1,319 @@
+// Package parquet writes Apache Parquet files: one row group, one
+// uncompressed PLAIN data page per column, optional flat columns and one
+// level of required groups. That is all the exporter needs, and it is
+// enough for Spark, DuckDB and pandas to read.
+//
+// Parquet metadata is Thrift in the compact protocol; thrift.go has the
+// few pieces of it used here.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Type is a Parquet physical type
+type Type int32
+
+const (
+	Boolean   Type = 0
+	Int32     Type = 1
+	Int64     Type = 2
+	Double    Type = 5
+	ByteArray Type = 6
+)
+
+// Thrift enum values used in the metadata
+const (
+	repetitionRequired = 0
+	repetitionOptional = 1
+	convertedUTF8      = 0
+	encodingPlain      = 0
+	encodingRLE        = 3
+	pageData           = 0
+	codecUncompressed  = 0
+)
+
+var magic = []byte("PAR1")
+
+// Column is one leaf column. Values holds an entry per row: bool, int32,
+// int64, float64, or string or []byte for ByteArray, matching Type. A nil
+// entry is a null and is only allowed in optional columns.
+type Column struct {
+	Name     string
+	Group    string // required group the column sits in, "" for none
+	Type     Type
+	Optional bool
+	UTF8     bool // ByteArray values are text
+	Values   []any
+}
+
+func (c *Column) path() []string {
+	if c.Group == "" {
+		return []string{c.Name}
+	}
+	return []string{c.Group, c.Name}
+}
+
+// Write writes columns, which must all have the same number of rows, as a
+// Parquet file to w
+func Write(w io.Writer, columns []Column) error {
+	rows := 0
+	if len(columns) > 0 {
+		rows = len(columns[0].Values)
+	}
+	out := &countingWriter{w: w}
+	if _, err := out.Write(magic); err != nil {
+		return err
+	}
+
+	var chunks []chunk
+	for i := range columns {
+		c := &columns[i]
+		if len(c.Values) != rows {
+			return fmt.Errorf("column %s has %d rows, expected %d", c.Name, len(c.Values), rows)
+		}
+		data, err := c.page()
+		if err != nil {
+			return err
+		}
+		var header thrift
+		header.i32(1, pageData)
+		header.i32(2, int32(len(data)))
+		header.i32(3, int32(len(data)))
+		header.structBegin(5)
+		header.i32(1, int32(rows))
+		header.i32(2, encodingPlain)
+		header.i32(3, encodingRLE)
+		header.i32(4, encodingRLE)
+		header.structEnd()
+		header.stop()
+
+		ch := chunk{column: c, offset: out.n, size: int64(header.buf.Len() + len(data))}
+		if _, err := out.Write(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+		chunks = append(chunks, ch)
+	}
+
+	meta := fileMetadata(columns, chunks, rows)
+	if _, err := out.Write(meta); err != nil {
+		return err
+	}
+	size := make([]byte, 4)
+	binary.LittleEndian.PutUint32(size, uint32(len(meta)))
+	if _, err := out.Write(size); err != nil {
+		return err
+	}
+	_, err := out.Write(magic)
+	return err
+}
+
+type chunk struct {
+	column *Column
+	offset int64
+	size   int64
+}
+
+// page returns the data page of the column: the definition levels of
+// optional columns, then the non-null values, PLAIN encoded
+func (c *Column) page() ([]byte, error) {
+	var page bytes.Buffer
+	if c.Optional {
+		levels := make([]bool, len(c.Values))
+		for i, v := range c.Values {
+			levels[i] = v != nil
+		}
+		encoded := bitPacked(levels)
+		binary.Write(&page, binary.LittleEndian, uint32(len(encoded)))
+		page.Write(encoded)
+	}
+
+	var bools []bool
+	for _, v := range c.Values {
+		if v == nil {
+			if !c.Optional {
+				return nil, fmt.Errorf("column %s is required but has a null", c.Name)
+			}
+			continue
+		}
+		ok := true
+		switch c.Type {
+		case Boolean:
+			var b bool
+			b, ok = v.(bool)
+			bools = append(bools, b)
+		case Int32:
+			var n int32
+			n, ok = v.(int32)
+			binary.Write(&page, binary.LittleEndian, n)
+		case Int64:
+			var n int64
+			n, ok = v.(int64)
+			binary.Write(&page, binary.LittleEndian, n)
+		case Double:
+			var f float64
+			f, ok = v.(float64)
+			binary.Write(&page, binary.LittleEndian, math.Float64bits(f))
+		case ByteArray:
+			var b []byte
+			switch v := v.(type) {
+			case string:
+				b = []byte(v)
+			case []byte:
+				b = v
+			default:
+				ok = false
+			}
+			binary.Write(&page, binary.LittleEndian, uint32(len(b)))
+			page.Write(b)
+		default:
+			return nil, fmt.Errorf("column %s has unsupported type %d", c.Name, c.Type)
+		}
+		if !ok {
+			return nil, fmt.Errorf("column %s: value %v (%T) does not fit its type", c.Name, v, v)
+		}
+	}
+	if c.Type == Boolean {
+		page.Write(packBits(bools))
+	}
+	return page.Bytes(), nil
+}
+
+// packBits packs booleans eight to a byte, least significant bit first
+func packBits(bits []bool) []byte {
+	out := make([]byte, (len(bits)+7)/8)
+	for i, b := range bits {
+		if b {
+			out[i/8] |= 1 << (i % 8)
+		}
+	}
+	return out
+}
+
+// bitPacked encodes levels of bit width 1 as a single bit-packed run of
+// the RLE/bit-packing hybrid encoding. The run is padded to a multiple of
+// eight values; readers stop at the page's value count.
+func bitPacked(levels []bool) []byte {
+	groups := (len(levels) + 7) / 8
+	header := binary.AppendUvarint(nil, uint64(groups)<<1|1)
+	return append(header, packBits(levels)...)
+}
+
+// fileMetadata encodes the FileMetaData footer
+func fileMetadata(columns []Column, chunks []chunk, rows int) []byte {
+	var t thrift
+	t.i32(1, 1) // version
+
+	// the schema, depth first: the root, then each top-level column or
+	// group followed by the columns in it
+	type node struct {
+		column *Column
+		group  string
+		leaves []*Column
+	}
+	var top []*node
+	groups := make(map[string]*node)
+	for i := range columns {
+		c := &columns[i]
+		if c.Group == "" {
+			top = append(top, &node{column: c})
+			continue
+		}
+		g, ok := groups[c.Group]
+		if !ok {
+			g = &node{group: c.Group}
+			groups[c.Group] = g
+			top = append(top, g)
+		}
+		g.leaves = append(g.leaves, c)
+	}
+	t.listBegin(2, thriftStruct, 1+len(columns)+len(groups))
+	t.elemBegin()
+	t.str(4, "schema")
+	t.i32(5, int32(len(top)))
+	t.elemEnd()
+	leaf := func(c *Column) {
+		t.elemBegin()
+		t.i32(1, int32(c.Type))
+		if c.Optional {
+			t.i32(3, repetitionOptional)
+		} else {
+			t.i32(3, repetitionRequired)
+		}
+		t.str(4, c.Name)
+		if c.UTF8 {
+			t.i32(6, convertedUTF8)
+		}
+		t.elemEnd()
+	}
+	for _, n := range top {
+		if n.column != nil {
+			leaf(n.column)
+			continue
+		}
+		t.elemBegin()
+		t.i32(3, repetitionRequired)
+		t.str(4, n.group)
+		t.i32(5, int32(len(n.leaves)))
+		t.elemEnd()
+		for _, c := range n.leaves {
+			leaf(c)
+		}
+	}
+
+	t.i64(3, int64(rows))
+
+	var total int64
+	for _, ch := range chunks {
+		total += ch.size
+	}
+	t.listBegin(4, thriftStruct, 1)
+	t.elemBegin()
+	t.listBegin(1, thriftStruct, len(chunks))
+	for _, ch := range chunks {
+		t.elemBegin()
+		t.i64(2, ch.offset)
+		t.structBegin(3)
+		t.i32(1, int32(ch.column.Type))
+		t.listBegin(2, thriftI32, 2)
+		t.varint(encodingPlain)
+		t.varint(encodingRLE)
+		t.listBegin(3, thriftBinary, len(ch.column.path()))
+		for _, p := range ch.column.path() {
+			t.binary(p)
+		}
+		t.i32(4, codecUncompressed)
+		t.i64(5, int64(rows))
+		t.i64(6, ch.size)
+		t.i64(7, ch.size)
+		t.i64(9, ch.offset)
+		t.structEnd()
+		t.elemEnd()
+	}
+	t.i64(2, total)
+	t.i64(3, int64(rows))
+	t.elemEnd()
+
+	t.str(6, "peridot")
+	t.stop()
+	return t.buf.Bytes()
+}
+
+// countingWriter tracks the offset in the file being written
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,107 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Thrift compact protocol type codes
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thrift writes a Thrift struct in the compact protocol. Fields carry the
+// difference to the previous field ID of the same struct, so it keeps
+// the last ID of every struct being written.
+type thrift struct {
+	buf  bytes.Buffer
+	last []int16
+}
+
+func (t *thrift) lastID() int16 {
+	if len(t.last) == 0 {
+		t.last = append(t.last, 0)
+	}
+	return t.last[len(t.last)-1]
+}
+
+func (t *thrift) field(id int16, typ byte) {
+	if delta := id - t.lastID(); delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(int32(id))
+	}
+	t.last[len(t.last)-1] = id
+}
+
+// varint writes a zigzag encoded integer, as used for i16, i32 and i64
+// values and for list elements of those types
+func (t *thrift) varint(v int32) {
+	t.uvarint(uint64(uint32(v<<1) ^ uint32(v>>31)))
+}
+
+func (t *thrift) uvarint(v uint64) {
+	t.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (t *thrift) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(v)
+}
+
+func (t *thrift) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.uvarint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+// binary writes a length-prefixed string, as a list element
+func (t *thrift) binary(s string) {
+	t.uvarint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
+
+func (t *thrift) str(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.binary(s)
+}
+
+// listBegin starts a list field of n elements of type elem. Elements follow
+// directly; struct elements are written between elemBegin and elemEnd.
+func (t *thrift) listBegin(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elem)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elem)
+	t.uvarint(uint64(n))
+}
+
+func (t *thrift) structBegin(id int16) {
+	t.field(id, thriftStruct)
+	t.elemBegin()
+}
+
+func (t *thrift) structEnd() {
+	t.elemEnd()
+}
+
+func (t *thrift) elemBegin() {
+	t.lastID()
+	t.last = append(t.last, 0)
+}
+
+func (t *thrift) elemEnd() {
+	t.stop()
+	t.last = t.last[:len(t.last)-1]
+}
+
+// stop ends the struct being written
+func (t *thrift) stop() {
+	t.buf.WriteByte(0)
+}